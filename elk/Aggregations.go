@@ -0,0 +1,200 @@
+package elk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Aggregations holds the raw results of each aggregation by name
+type Aggregations map[string]json.RawMessage
+
+type BucketAggregation struct {
+	DocCountErrorUpperBound int `json:"doc_count_error_upper_bound"`
+	SumOtherDocCount int `json:"sum_other_doc_count"`
+	Buckets Buckets `json:"buckets"`
+}
+
+// Buckets decodes both the array form and the keyed object form of aggregation buckets
+type Buckets []Bucket
+
+// Bucket is a single bucket of a bucket aggregation. Sub aggregations are stored in Aggregations.
+type Bucket struct {
+	Key interface{} `json:"key"`
+	KeyAsString string `json:"key_as_string"`
+	From *float64 `json:"from"`
+	FromAsString string `json:"from_as_string"`
+	To *float64 `json:"to"`
+	ToAsString string `json:"to_as_string"`
+	DocCount int `json:"doc_count"`
+	Aggregations Aggregations `json:"-"`
+}
+
+type StatsAggregation struct {
+	Count int `json:"count"`
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	Avg float64 `json:"avg"`
+	Sum float64 `json:"sum"`
+}
+
+type ValueAggregation struct {
+	Value float64 `json:"value"`
+	ValueAsString string `json:"value_as_string"`
+}
+
+type PercentilesAggregation struct {
+	Values map[string]float64 `json:"values"`
+}
+
+type TopHitsAggregation struct {
+	Hits Hits `json:"hits"`
+}
+
+func (self *Buckets) UnmarshalJSON(data []byte) error {
+	buckets := []Bucket{}
+	if err := json.Unmarshal(data, &buckets); err == nil {
+		*self = Buckets(buckets)
+		return nil
+	}
+
+	// keyed buckets use the key of each bucket as the field name
+	keyed := map[string]Bucket{}
+	if err := json.Unmarshal(data, &keyed); err != nil {
+		return err
+	}
+	keys := []string{}
+	for key := range keyed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		bucket := keyed[key]
+		if bucket.Key == nil {
+			bucket.Key = key
+		}
+		buckets = append(buckets, bucket)
+	}
+	*self = Buckets(buckets)
+	return nil
+}
+
+func (self *Bucket) UnmarshalJSON(data []byte) error {
+	type bucket Bucket
+	if err := json.Unmarshal(data, (*bucket)(self)); err != nil {
+		return err
+	}
+
+	// every other field in the bucket is a sub aggregation
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for _, field := range []string{"key", "key_as_string", "from", "from_as_string", "to", "to_as_string", "doc_count"} {
+		delete(fields, field)
+	}
+	self.Aggregations = Aggregations(fields)
+	return nil
+}
+
+// KeyString returns the key of the bucket as a string
+func (self *Bucket) KeyString() string {
+	if self.KeyAsString != "" {
+		return self.KeyAsString
+	}
+	switch key := self.Key.(type) {
+		case string:
+			return key
+		case float64:
+			// numeric keys are formatted without an exponent so large values such as 1234567 stay readable
+			return strconv.FormatFloat(key, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", self.Key)
+}
+
+// Counts returns the document count of each bucket by key
+func (self *BucketAggregation) Counts() map[string]int {
+	counts := map[string]int{}
+	for i := range self.Buckets {
+		counts[self.Buckets[i].KeyString()] = self.Buckets[i].DocCount
+	}
+	return counts
+}
+
+// Decode unmarshals the raw results of the named aggregation into results
+func (self Aggregations) Decode(name string, results interface{}) error {
+	data, ok := self[name]
+	if !ok {
+		return errors.New(fmt.Sprintf("Aggregation %s not found", name))
+	}
+	return json.Unmarshal(data, results)
+}
+
+// Buckets returns the results of the named terms, histogram, date_histogram, range or filters aggregation
+func (self Aggregations) Buckets(name string) (*BucketAggregation, error) {
+	results := BucketAggregation{}
+	err := self.Decode(name, &results)
+	return &results, err
+}
+
+// Terms returns the results of the named terms aggregation
+func (self Aggregations) Terms(name string) (*BucketAggregation, error) {
+	return self.Buckets(name)
+}
+
+// DateHistogram returns the results of the named date_histogram aggregation
+func (self Aggregations) DateHistogram(name string) (*BucketAggregation, error) {
+	return self.Buckets(name)
+}
+
+// Histogram returns the results of the named histogram aggregation
+func (self Aggregations) Histogram(name string) (*BucketAggregation, error) {
+	return self.Buckets(name)
+}
+
+// Range returns the results of the named range aggregation
+func (self Aggregations) Range(name string) (*BucketAggregation, error) {
+	return self.Buckets(name)
+}
+
+// Filters returns the results of the named filters aggregation
+func (self Aggregations) Filters(name string) (*BucketAggregation, error) {
+	return self.Buckets(name)
+}
+
+// Nested returns the single bucket of the named nested aggregation
+func (self Aggregations) Nested(name string) (*Bucket, error) {
+	results := Bucket{}
+	err := self.Decode(name, &results)
+	return &results, err
+}
+
+// Stats returns the results of the named stats aggregation
+func (self Aggregations) Stats(name string) (*StatsAggregation, error) {
+	results := StatsAggregation{}
+	err := self.Decode(name, &results)
+	return &results, err
+}
+
+// Cardinality returns the results of the named cardinality aggregation
+func (self Aggregations) Cardinality(name string) (*ValueAggregation, error) {
+	results := ValueAggregation{}
+	err := self.Decode(name, &results)
+	return &results, err
+}
+
+// Percentiles returns the results of the named percentiles aggregation
+func (self Aggregations) Percentiles(name string) (*PercentilesAggregation, error) {
+	results := PercentilesAggregation{}
+	err := self.Decode(name, &results)
+	return &results, err
+}
+
+// TopHits returns the results of the named top_hits aggregation
+func (self Aggregations) TopHits(name string) (*TopHitsAggregation, error) {
+	results := TopHitsAggregation{}
+	err := self.Decode(name, &results)
+	return &results, err
+}
@@ -0,0 +1,137 @@
+package elk_test
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk"
+	"github.com/KarmaPenny/golib/elk/elktest"
+	"github.com/KarmaPenny/golib/elk/query"
+
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// aggregationsOf decodes the aggregations of a search response
+func aggregationsOf(t *testing.T, response string) elk.Aggregations {
+	results := elk.SearchResults{}
+	if err := json.Unmarshal([]byte(response), &results); err != nil {
+		t.Fatal(err)
+	}
+	return results.Aggregations
+}
+
+func TestBucketKeys(t *testing.T) {
+	aggregations := aggregationsOf(t, `{"aggregations": {
+		"sizes": {"buckets": [{"key": 1234567, "doc_count": 2}, {"key": 0.5, "doc_count": 1}, {"key": -3, "doc_count": 4}]},
+		"flags": {"buckets": [{"key": 1, "key_as_string": "true", "doc_count": 3}]},
+		"daily": {"buckets": [{"key": 1767225600000, "key_as_string": "2026-01-01", "doc_count": 5}]},
+		"names": {"buckets": [{"key": "a", "doc_count": 6}]}
+	}}`)
+	tests := map[string]map[string]int{
+		"sizes": {"1234567": 2, "0.5": 1, "-3": 4},
+		"flags": {"true": 3},
+		"daily": {"2026-01-01": 5},
+		"names": {"a": 6},
+	}
+	for name, expected := range tests {
+		buckets, err := aggregations.Buckets(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counts := buckets.Counts(); !reflect.DeepEqual(counts, expected) {
+			t.Errorf("%s: expected %v, got %v", name, expected, counts)
+		}
+	}
+}
+
+func TestKeyedBuckets(t *testing.T) {
+	aggregations := aggregationsOf(t, `{"aggregations": {
+		"ranges": {"buckets": {"small": {"to": 10.0, "doc_count": 1}, "large": {"from": 10.0, "doc_count": 2}}},
+		"open": {"buckets": {"new": {"doc_count": 3}}}
+	}}`)
+	ranges, err := aggregations.Range("ranges")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges.Buckets) != 2 || ranges.Buckets[0].KeyString() != "large" || *ranges.Buckets[0].From != 10 || ranges.Buckets[1].To == nil {
+		t.Errorf("unexpected range buckets %+v", ranges.Buckets)
+	}
+	filters, err := aggregations.Filters("open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts := filters.Counts(); counts["new"] != 3 {
+		t.Errorf("unexpected filter counts %v", counts)
+	}
+}
+
+func TestSubAggregations(t *testing.T) {
+	aggregations := aggregationsOf(t, `{"aggregations": {
+		"status": {"doc_count_error_upper_bound": 0, "sum_other_doc_count": 7, "buckets": [
+			{"key": "new", "doc_count": 4, "sizes": {"count": 4, "min": 1, "max": 10, "avg": 5, "sum": 20}}
+		]},
+		"analysis": {"doc_count": 9, "types": {"buckets": [{"key": "yara", "doc_count": 8, "owners": {"value": 2}}]}},
+		"latency": {"values": {"50.0": 12.5, "99.0": 80}}
+	}}`)
+	status, err := aggregations.Terms("status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.SumOtherDocCount != 7 || len(status.Buckets) != 1 {
+		t.Fatalf("unexpected terms %+v", status)
+	}
+	stats, err := status.Buckets[0].Aggregations.Stats("sizes")
+	if err != nil || stats.Count != 4 || stats.Sum != 20 {
+		t.Errorf("unexpected stats %+v %v", stats, err)
+	}
+
+	// nested aggregations have a single bucket whose sub aggregations may have buckets of their own
+	nested, err := aggregations.Nested("analysis")
+	if err != nil || nested.DocCount != 9 {
+		t.Fatalf("unexpected nested %+v %v", nested, err)
+	}
+	types, err := nested.Aggregations.Terms("types")
+	if err != nil || types.Counts()["yara"] != 8 {
+		t.Fatalf("unexpected nested terms %+v %v", types, err)
+	}
+	owners, err := types.Buckets[0].Aggregations.Cardinality("owners")
+	if err != nil || owners.Value != 2 {
+		t.Errorf("unexpected cardinality %+v %v", owners, err)
+	}
+	latency, err := aggregations.Percentiles("latency")
+	if err != nil || latency.Values["99.0"] != 80 {
+		t.Errorf("unexpected percentiles %+v %v", latency, err)
+	}
+	if _, err = aggregations.Terms("missing"); err == nil {
+		t.Error("expected an error for a missing aggregation")
+	}
+}
+
+func TestCountByAlertStatus(t *testing.T) {
+	server := elktest.NewServer()
+	defer server.Close()
+	statuses := []string{elk.ALERT_STATUS_NONE, elk.ALERT_STATUS_NONE, elk.ALERT_STATUS_QUEUED, elk.ALERT_STATUS_REVIEWED, "NOT_A_STATUS"}
+	for i, status := range statuses {
+		server.Put("alerts", string(rune('a' + i)), Object{"alert_status": status, "owner": i % 2})
+	}
+	client := server.NewClient()
+
+	counts, err := client.CountByAlertStatus("alerts", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != len(elk.ALERT_STATUSES) || counts[elk.ALERT_STATUS_NONE] != 2 || counts[elk.ALERT_STATUS_QUEUED] != 1 || counts[elk.ALERT_STATUS_REVIEWED] != 1 || counts[elk.ALERT_STATUS_EXFIL] != 0 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+	if _, ok := counts["NOT_A_STATUS"]; ok {
+		t.Error("expected unknown statuses to be left out")
+	}
+
+	counts, err = client.CountByAlertStatus("alerts", query.Term("owner", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[elk.ALERT_STATUS_NONE] != 1 || counts[elk.ALERT_STATUS_QUEUED] != 1 || counts[elk.ALERT_STATUS_REVIEWED] != 0 {
+		t.Errorf("unexpected filtered counts %v", counts)
+	}
+}
@@ -0,0 +1,74 @@
+package elk
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// ByQueryOptions field RequestsPerSecond throttles the operation, zero leaves it unthrottled
+// Slices field splits the operation into parallel slices, either "auto" or a number, empty uses one slice
+type ByQueryOptions struct {
+	RequestsPerSecond float64
+	Slices string
+}
+
+// parameters returns the url parameters of the options
+func (self *ByQueryOptions) parameters() url.Values {
+	parameters := url.Values{}
+	if self == nil {
+		return parameters
+	}
+	if self.RequestsPerSecond > 0 {
+		parameters.Set("requests_per_second", strconv.FormatFloat(self.RequestsPerSecond, 'f', -1, 64))
+	}
+	if self.Slices != "" {
+		parameters.Set("slices", self.Slices)
+	}
+	return parameters
+}
+
+// DeleteByQuery deletes every document in index matching the query, skipping documents that change while it runs
+func (self *Client) DeleteByQuery(index string, query interface{}, options *ByQueryOptions) (*DeleteByQueryResults, error) {
+	results := DeleteByQueryResults{}
+	parameters := options.parameters()
+	parameters.Set("conflicts", "proceed")
+	url := fmt.Sprintf("/%s/_delete_by_query?%s", index, parameters.Encode())
+	err := self.Request("POST", url, query, &results)
+	return &results, err
+}
+
+// DeleteByQueryAsync starts a delete by query as a task and returns without waiting for it to complete
+func (self *Client) DeleteByQueryAsync(index string, query interface{}, options *ByQueryOptions) (*Task, error) {
+	parameters := options.parameters()
+	parameters.Set("conflicts", "proceed")
+	return self.startTask(fmt.Sprintf("/%s/_delete_by_query", index), query, parameters)
+}
+
+// Reindex copies documents from the source to the destination index of the request, which is usually built with query.NewReindex
+func (self *Client) Reindex(request interface{}, options *ByQueryOptions) (*ReindexResults, error) {
+	results := ReindexResults{}
+	url := fmt.Sprintf("/_reindex?%s", options.parameters().Encode())
+	err := self.Request("POST", url, request, &results)
+	return &results, err
+}
+
+// ReindexAsync starts a reindex as a task and returns without waiting for it to complete
+func (self *Client) ReindexAsync(request interface{}, options *ByQueryOptions) (*Task, error) {
+	return self.startTask("/_reindex", request, options.parameters())
+}
+
+// UpdateByQueryAsync starts an update by query as a task and returns without waiting for it to complete
+func (self *Client) UpdateByQueryAsync(index string, query interface{}, options *ByQueryOptions) (*Task, error) {
+	parameters := options.parameters()
+	parameters.Set("conflicts", "proceed")
+	return self.startTask(fmt.Sprintf("/%s/_update_by_query", index), query, parameters)
+}
+
+// startTask sends a request with wait_for_completion=false and returns the task handle
+func (self *Client) startTask(path string, body interface{}, parameters url.Values) (*Task, error) {
+	task := Task{client: self}
+	parameters.Set("wait_for_completion", "false")
+	err := self.Request("POST", fmt.Sprintf("%s?%s", path, parameters.Encode()), body, &task)
+	return &task, err
+}
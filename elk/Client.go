@@ -2,58 +2,260 @@ package elk
 
 import (
 	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk/query"
 
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
+// ReadModifyWriteAttempts is the number of times ReadModifyWrite retries after a version conflict
+const ReadModifyWriteAttempts = 5
+
+// ReadModifyWriteBackoff is the base delay before ReadModifyWrite retries after a version conflict
+// The delay doubles with every attempt and is randomized so that competing writers do not collide again.
+const ReadModifyWriteBackoff = 50 * time.Millisecond
+
+// Headers field is added to every request, such as the authorization or security tenant headers of opensearch
+// Hooks field is notified before and after every request for logging and tracing
+// Metrics field receives request latencies and bulk item failures, nil discards them
+// Logger field receives structured log messages such as retries and fallbacks, nil discards them
+// DryRun field makes Push report the changes it would make in BulkResults.Diffs instead of writing them, see PushDryRun
 type Client struct {
 	BaseUrl string
+	DryRun bool
 	HttpClient *http.Client
+	Headers http.Header
+	Hooks []Hook
+	Logger Logger
+	Metrics Metrics
+
+	info *InfoResults
+	info_lock sync.Mutex
+	stored_scripts map[string]bool
+	stored_scripts_lock sync.Mutex
 }
 
 // Bulk executes a bulk operation
 func (self *Client) Bulk(bulk_actions []interface{}) (*BulkResults, error) {
 	results := BulkResults{}
 	err := self.BulkRequest("POST", "/_bulk", bulk_actions, &results)
+	metricsOrNop(self.Metrics).AddBulkFailures(countBulkFailures(&results))
 	return &results, err
 }
 
+// CountByAlertStatus returns the number of documents in index matching filter for each alert_status constant. A nil filter counts every document.
+func (self *Client) CountByAlertStatus(index string, filter query.Query) (map[string]int, error) {
+	counts := map[string]int{}
+	for _, status := range ALERT_STATUSES {
+		counts[status] = 0
+	}
+	search := query.NewSearch().
+		Size(0).
+		Aggregation("alert_status", query.TermsAgg("alert_status").Size(len(ALERT_STATUSES)))
+	if filter != nil {
+		search.Query(filter)
+	}
+	results, err := self.SearchResponse(index, search)
+	if err != nil {
+		return counts, err
+	}
+	statuses, err := results.Aggregations.Terms("alert_status")
+	if err != nil {
+		return counts, err
+	}
+	for status, count := range statuses.Counts() {
+		if _, ok := counts[status]; ok {
+			counts[status] = count
+		}
+	}
+	return counts, nil
+}
+
+// Push sends the updates in a single bulk request. Updates that fail because their stored script was deleted from the cluster are stored again and retried once.
+// If DryRun is set nothing is sent and the changes the updates would make are returned in the Diffs of the results.
 func (self *Client) Push(updates BulkUpdate) (*BulkResults, error) {
-	bulk_actions := Array{}
 	for path := range updates {
-		bulk_actions = append(bulk_actions, updates[path].Action())
+		if err := updates[path].Err(); err != nil {
+			return &BulkResults{}, errors.New(fmt.Sprintf("Invalid update of %s: %s", path, err))
+		}
+	}
+	if self.DryRun {
+		return self.pushDryRun(updates)
+	}
+	paths := []string{}
+	for path := range updates {
+		paths = append(paths, path)
+	}
+	results, err := self.pushPaths(updates, paths)
+	if err != nil {
+		return results, err
+	}
+
+	// store missing scripts again and retry the updates that used them
+	retry_paths := []string{}
+	retry_items := []int{}
+	for i := range results.Items {
+		if missingScript(results.Items[i].Update.Error) {
+			self.forgetUpdateScript(updates[paths[i]].ScriptId())
+			retry_paths = append(retry_paths, paths[i])
+			retry_items = append(retry_items, i)
+		}
+	}
+	if len(retry_paths) > 0 {
+		loggerOrNop(self.Logger).Warn("stored update script is missing, storing it again", "updates", len(retry_paths))
+		retried, err := self.pushPaths(updates, retry_paths)
+		if err != nil {
+			return results, err
+		}
+		for i := range retried.Items {
+			results.Items[retry_items[i]] = retried.Items[i]
+		}
+		results.Errors = countBulkFailures(results) > 0
+	}
+	metricsOrNop(self.Metrics).AddBulkFailures(countBulkFailures(results))
+	return results, nil
+}
+
+// pushDryRun returns the changes Push would make in the Diffs of the results, Errors is set if any update would fail
+func (self *Client) pushDryRun(updates BulkUpdate) (*BulkResults, error) {
+	results := BulkResults{}
+	diffs, err := self.PushDryRun(updates)
+	if err != nil {
+		return &results, err
+	}
+	results.Diffs = diffs
+	for i := range diffs {
+		if diffs[i].Error != nil {
+			results.Errors = true
+		}
+	}
+	return &results, nil
+}
+
+// pushPaths sends the updates identified by paths in a single bulk request, the items of the results are in the same order as paths
+func (self *Client) pushPaths(updates BulkUpdate, paths []string) (*BulkResults, error) {
+	typeless, err := self.Typeless()
+	if err != nil {
+		return &BulkResults{}, err
+	}
+	if err = self.storeUpdateScripts(updates); err != nil {
+		return &BulkResults{}, err
+	}
+	bulk_actions := Array{}
+	for _, path := range paths {
+		action := updates[path].Action()
+		if typeless {
+			action = TypelessAction(action)
+		}
+		bulk_actions = append(bulk_actions, action)
 		bulk_actions = append(bulk_actions, updates[path].Source())
 	}
 	results := BulkResults{}
 	if len(bulk_actions) == 0 {
 		return &results, nil
 	}
-	err := self.BulkRequest("POST", "/_bulk", bulk_actions, &results)
+	err = self.BulkRequest("POST", "/_bulk", bulk_actions, &results)
+	return &results, err
+}
+
+// Create indexes a document only if a document with the same id does not already exist. An ErrConflict error is returned if it does.
+func (self *Client) Create(index string, id string, document interface{}) (*Document, error) {
+	results := Document{}
+	url, err := self.documentUrl(index, id)
+	if err != nil {
+		return &results, err
+	}
+	err = self.Request("PUT", url + "?op_type=create", document, &results)
 	return &results, err
 }
 
+// Delete removes the document identified by path. An ErrNotFound error is returned if the document does not exist.
+func (self *Client) Delete(path string) (*Document, error) {
+	results := Document{}
+	url, err := self.pathUrl(path)
+	if err != nil {
+		return &results, err
+	}
+	err = self.Request("DELETE", url, nil, &results)
+	return &results, err
+}
+
+// DeleteIf removes the document identified by path only if it has not changed since the given sequence number and primary term. An ErrConflict error is returned if it has.
+func (self *Client) DeleteIf(path string, seq_no int, primary_term int) (*Document, error) {
+	results := Document{}
+	url, err := self.pathUrl(path)
+	if err != nil {
+		return &results, err
+	}
+	err = self.Request("DELETE", conditionalUrl(url, seq_no, primary_term), nil, &results)
+	return &results, err
+}
+
+// Exists returns true if the document identified by path exists
+func (self *Client) Exists(path string) (bool, error) {
+	url, err := self.pathUrl(path)
+	if err != nil {
+		return false, err
+	}
+	err = self.Request("HEAD", url, nil, nil)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // GetDocument returns the document with source fields identified by path
 func (self *Client) GetDocument(path string) (*Document, error) {
 	results := Document{}
-	err := self.Request("GET", path, nil, &results)
+	url, err := self.pathUrl(path)
+	if err != nil {
+		return &results, err
+	}
+	err = self.Request("GET", url, nil, &results)
 	return &results, err
 }
 
-// GetRefreshInterval retrieves the refresh interval for an index
+// GetDocumentFields returns the document identified by path with its source filtered to includes and without excludes
+func (self *Client) GetDocumentFields(path string, includes []string, excludes []string) (*Document, error) {
+	results := Document{}
+	full_path, err := self.pathUrl(path)
+	if err != nil {
+		return &results, err
+	}
+	parameters := url.Values{}
+	if len(includes) > 0 {
+		parameters.Set("_source_includes", strings.Join(includes, ","))
+	}
+	if len(excludes) > 0 {
+		parameters.Set("_source_excludes", strings.Join(excludes, ","))
+	}
+	if len(parameters) > 0 {
+		full_path = fmt.Sprintf("%s?%s", full_path, parameters.Encode())
+	}
+	err = self.Request("GET", full_path, nil, &results)
+	return &results, err
+}
+
+// GetRefreshInterval retrieves the refresh interval for an index. The elasticsearch default of 1s is returned if the index does not set one.
 func (self *Client) GetRefreshInterval(index string) (time.Duration, error) {
-	results := IndexSettingsResults{}
-	url := fmt.Sprintf("/%s/_settings/index.refresh_interval", index)
-	err := self.Request("GET", url, nil, &results)
+	results, err := self.GetSettings(index)
 	if err != nil {
 		return time.Second, err
 	}
 	for index_name := range results {
+		if results[index_name].Settings.Index.RefreshInterval == "" {
+			return time.Second, nil
+		}
 		refresh_interval, err := time.ParseDuration(results[index_name].Settings.Index.RefreshInterval)
 		if err != nil {
 			return time.Second, err
@@ -66,24 +268,98 @@ func (self *Client) GetRefreshInterval(index string) (time.Duration, error) {
 // Index indexes a document
 func (self *Client) Index(index string, id string, document interface{}) (*Document, error) {
 	results := Document{}
-	url := fmt.Sprintf("/%s/doc/%s", index, id)
-	err := self.Request("PUT", url, document, &results)
+	url, err := self.documentUrl(index, id)
+	if err != nil {
+		return &results, err
+	}
+	err = self.Request("PUT", url, document, &results)
 	return &results, err
 }
 
+// IsOpenSearch returns true if the cluster is opensearch rather than elasticsearch
+func (self *Client) IsOpenSearch() (bool, error) {
+	info, err := self.Info()
+	if err != nil {
+		return false, err
+	}
+	return info.Version.IsOpenSearch(), nil
+}
+
+// Info returns the name and version of the cluster. The response is cached after the first successful request.
+func (self *Client) Info() (*InfoResults, error) {
+	self.info_lock.Lock()
+	defer self.info_lock.Unlock()
+	if self.info != nil {
+		return self.info, nil
+	}
+	results := InfoResults{}
+	err := self.Request("GET", "/", nil, &results)
+	if err != nil {
+		return &results, err
+	}
+	self.info = &results
+	return self.info, nil
+}
+
+// IndexIf indexes a document only if the existing document has not changed since the given sequence number and primary term. An ErrConflict error is returned if it has.
+func (self *Client) IndexIf(index string, id string, document interface{}, seq_no int, primary_term int) (*Document, error) {
+	results := Document{}
+	url, err := self.documentUrl(index, id)
+	if err != nil {
+		return &results, err
+	}
+	err = self.Request("PUT", conditionalUrl(url, seq_no, primary_term), document, &results)
+	return &results, err
+}
+
+// MultiGet returns the documents identified by paths in the same order. Documents that do not exist have Found set to false.
+func (self *Client) MultiGet(paths []string) ([]Document, error) {
+	typeless, err := self.Typeless()
+	if err != nil {
+		return []Document{}, err
+	}
+	docs := Array{}
+	for i := range paths {
+		index, doc_type, id, err := SplitPath(paths[i])
+		if err != nil {
+			return []Document{}, err
+		}
+		doc := Object{"_index": index, "_id": id}
+		if !typeless {
+			doc["_type"] = typeOrDefault(doc_type)
+		}
+		docs = append(docs, doc)
+	}
+	results := MultiGetResults{}
+	err = self.Request("POST", "/_mget", Object{"docs": docs}, &results)
+	if err != nil {
+		return []Document{}, err
+	}
+	return results.Docs, nil
+}
+
+// MultiSearch executes a multisearch request and returns every response. Each response has a Status and an Error if that search failed.
+func (self *Client) MultiSearch(index string, bulk_queries []interface{}) ([]SearchResults, error) {
+	return self.multiSearch(fmt.Sprintf("/%s/_msearch", index), bulk_queries)
+}
+
 // MultiSearchTemplate executes a multisearch template request and returns the matching documents
+// The hits of a failed search are empty. Use MultiSearchTemplateResponses and NewMultiSearchError to find out which searches failed.
 func (self *Client) MultiSearchTemplate(index string, bulk_queries []interface{}) ([][]Document, error) {
 	hits := [][]Document{}
-	results := MultiSearchResults{}
-	url := fmt.Sprintf("/%s/_msearch/template", index)
-	err := self.BulkRequest("POST", url, bulk_queries, &results)
+	responses, err := self.MultiSearchTemplateResponses(index, bulk_queries)
 	if err != nil {
 		return hits, err
 	}
-	for i := range results.Responses {
-		hits = append(hits, results.Responses[i].Hits.Hits)
+	for i := range responses {
+		hits = append(hits, responses[i].Hits.Hits)
 	}
-	return hits, err
+	return hits, nil
+}
+
+// MultiSearchTemplateResponses executes a multisearch template request and returns every response. Each response has a Status and an Error if that search failed.
+func (self *Client) MultiSearchTemplateResponses(index string, bulk_queries []interface{}) ([]SearchResults, error) {
+	return self.multiSearch(fmt.Sprintf("/%s/_msearch/template", index), bulk_queries)
 }
 
 // BulkRequest sends a bulk request to the elasticsearch api
@@ -98,104 +374,114 @@ func (self *Client) BulkRequest(method string, url string, json_objects []interf
 		request_body.Write(data)
 		request_body.WriteRune('\n')
 	}
-	//fmt.Println(request_body.String())
-
-	// create the request
-	full_url := fmt.Sprintf("%s%s", self.BaseUrl, url)
-	request, err := http.NewRequest(method, full_url, request_body)
-	if err != nil {
-		return err
-	}
-
-	// add content type header
-	request.Header.Set("Content-Type", "application/x-ndjson")
+	return self.send(context.Background(), method, url, "application/x-ndjson", request_body.Bytes(), results)
+}
 
-	// do the request
-	response, err := self.HttpClient.Do(request)
-	if err != nil {
-		return err
-	}
-	defer response.Body.Close()
+// Request sends a request to the elasticsearch api
+func (self *Client) Request(method string, url string, json_object interface{}, results interface{}) error {
+	return self.RequestWithContext(context.Background(), method, url, json_object, results)
+}
 
-	// read the response
-	response_body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return err
-	}
-	if response.StatusCode != 200  && response.StatusCode != 201 {
-		return errors.New(fmt.Sprintf("StatusCode (%d): %s", response.StatusCode, response_body))
+// RequestWithContext sends a request to the elasticsearch api that is canceled when ctx is done
+func (self *Client) RequestWithContext(ctx context.Context, method string, url string, json_object interface{}, results interface{}) error {
+	// create the request_body
+	if json_object == nil {
+		return self.send(ctx, method, url, "", nil, results)
 	}
-	//fmt.Println(string(response_body))
-
-	// decode response
-	err = json.Unmarshal(response_body, results)
+	data, err := json.Marshal(json_object)
 	if err != nil {
 		return err
 	}
-	return nil
+	return self.send(ctx, method, url, "application/json", data, results)
 }
 
-// Request sends a request to the elasticsearch api
-func (self *Client) Request(method string, url string, json_object interface{}, results interface{}) error {
-	// create the request_body
-	var request_body *bytes.Buffer = bytes.NewBuffer(make([]byte, 0))
-	if json_object != nil {
-		data, err := json.Marshal(json_object)
+// ReadModifyWrite loads the document identified by path, passes it to modify and writes the modified source back only if the document did not change in between.
+// On a version conflict the whole cycle is retried up to ReadModifyWriteAttempts times with a jittered backoff. An error returned by modify aborts without writing.
+func (self *Client) ReadModifyWrite(path string, modify func(*Document) error) (*Document, error) {
+	var err error
+	for attempt := 0; attempt < ReadModifyWriteAttempts; attempt++ {
+		// load the current version of the document
+		var document *Document
+		document, err = self.GetDocument(path)
 		if err != nil {
-			return err
+			return document, err
 		}
-		request_body = bytes.NewBuffer(data)
-	}
 
-	// create the request
-	full_url := fmt.Sprintf("%s%s", self.BaseUrl, url)
-	request, err := http.NewRequest(method, full_url, request_body)
-	if err != nil {
-		return err
-	}
+		// apply the modifications
+		if err = modify(document); err != nil {
+			return document, err
+		}
 
-	// add content-type header
-	if json_object != nil {
-		request.Header.Set("Content-Type", "application/json")
+		// write the document back if it has not changed
+		results := Document{}
+		var url string
+		if url, err = self.pathUrl(path); err != nil {
+			return &results, err
+		}
+		err = self.Request("PUT", conditionalUrl(url, document.SequenceNumber, document.PrimaryTerm), document.Source, &results)
+		if !IsConflict(err) {
+			return &results, err
+		}
+		loggerOrNop(self.Logger).Debug("version conflict, retrying read modify write", "path", path, "attempt", attempt + 1)
+		if attempt + 1 < ReadModifyWriteAttempts {
+			time.Sleep(retryDelay(ReadModifyWriteBackoff, attempt))
+		}
 	}
+	return &Document{}, err
+}
 
-	// do the request
-	response, err := self.HttpClient.Do(request)
-	if err != nil {
-		return err
-	}
-	defer response.Body.Close()
+// retryDelay returns a random delay between half and all of base doubled attempt times
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	delay := base << uint(attempt)
+	return delay / 2 + time.Duration(rand.Int63n(int64(delay / 2) + 1))
+}
 
-	// read the response
-	response_body, err := ioutil.ReadAll(response.Body)
+// Search executes a query and returns the results
+func (self *Client) Search(index string, query interface{}) ([]Document, error) {
+	results, err := self.SearchResponse(index, query)
 	if err != nil {
-		return err
-	}
-	if response.StatusCode != 200  && response.StatusCode != 201 {
-		return errors.New(fmt.Sprintf("StatusCode (%d): %s", response.StatusCode, response_body))
+		return []Document{}, err
 	}
+	return results.Hits.Hits, nil
+}
 
-	// decode response
-	err = json.Unmarshal(response_body, results)
+// SearchResponse executes a query and returns the complete response including totals and aggregations
+func (self *Client) SearchResponse(index string, query interface{}) (*SearchResults, error) {
+	results := SearchResults{}
+	url := fmt.Sprintf("/%s/_search", index)
+	err := self.Request("POST", url, query, &results)
 	if err != nil {
-		return err
+		return &results, err
 	}
-	return nil
+	if results.TimedOut {
+		took := time.Duration(results.Took) * time.Millisecond
+		return &results, errors.New(fmt.Sprintf("Search timed out after %s", took))
+	}
+	return &results, nil
 }
 
-// Search executes a query and returns the results
-func (self *Client) Search(index string, query interface{}) ([]Document, error) {
+// SearchTemplate executes the stored search template with id and params
+func (self *Client) SearchTemplate(index string, id string, params Object) (*SearchResults, error) {
 	results := SearchResults{}
-	url := fmt.Sprintf("/%s/_search", index)
-	err := self.Request("POST", url, query, &results)
+	url := fmt.Sprintf("/%s/_search/template", index)
+	err := self.Request("POST", url, Object{"id": id, "params": params}, &results)
 	if err != nil {
-		return []Document{}, err
+		return &results, err
 	}
 	if results.TimedOut {
 		took := time.Duration(results.Took) * time.Millisecond
-		return []Document{}, errors.New(fmt.Sprintf("Search timed out after %s", took))
+		return &results, errors.New(fmt.Sprintf("Search timed out after %s", took))
 	}
-	return results.Hits.Hits, nil
+	return &results, nil
+}
+
+// Typeless returns true if the cluster is elasticsearch 7 or later where mapping types are removed and _doc endpoints are used
+func (self *Client) Typeless() (bool, error) {
+	info, err := self.Info()
+	if err != nil {
+		return false, err
+	}
+	return info.Version.Typeless(), nil
 }
 
 // Unlock releases the lock on the document identified by path
@@ -203,7 +489,19 @@ func (self *Client) Unlock(path string) error {
 	update := Object{
 		"doc": Object{
 			"lock_until": "0",
-			"lock_owner": "None",
+			"lock_owner": LOCK_OWNER_NONE,
+		},
+	}
+	_, err := self.Update(path, &update)
+	return err
+}
+
+// Fail locks the document identified by path forever so that no pipeline runs it again. Unlock it to run it again.
+func (self *Client) Fail(path string) error {
+	update := Object{
+		"doc": Object{
+			"lock_until": LOCK_UNTIL_FOREVER,
+			"lock_owner": LOCK_OWNER_FAILED,
 		},
 	}
 	_, err := self.Update(path, &update)
@@ -213,8 +511,22 @@ func (self *Client) Unlock(path string) error {
 // Update modifies a document
 func (self *Client) Update(path string, update interface{}) (*Document, error) {
 	results := Document{}
-	url := fmt.Sprintf("%s/_update?retry_on_conflict=3", path)
-	err := self.Request("POST", url, update, &results)
+	url, err := self.updateUrl(path)
+	if err != nil {
+		return &results, err
+	}
+	err = self.Request("POST", url + "?retry_on_conflict=3", update, &results)
+	return &results, err
+}
+
+// UpdateIf modifies a document only if it has not changed since the given sequence number and primary term. An ErrConflict error is returned if it has.
+func (self *Client) UpdateIf(path string, update interface{}, seq_no int, primary_term int) (*Document, error) {
+	results := Document{}
+	url, err := self.updateUrl(path)
+	if err != nil {
+		return &results, err
+	}
+	err = self.Request("POST", conditionalUrl(url, seq_no, primary_term), update, &results)
 	return &results, err
 }
 
@@ -225,3 +537,149 @@ func (self *Client) UpdateByQuery(index string, query interface{}) (*UpdateByQue
 	err := self.Request("POST", url, query, &results)
 	return &results, err
 }
+
+// conditionalUrl adds if_seq_no and if_primary_term parameters to url
+func conditionalUrl(url string, seq_no int, primary_term int) string {
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%s%sif_seq_no=%d&if_primary_term=%d", url, separator, seq_no, primary_term)
+}
+
+// documentUrl returns the url of a document using the doc type on elasticsearch 6 and _doc on later versions
+func (self *Client) documentUrl(index string, id string) (string, error) {
+	typeless, err := self.Typeless()
+	if err != nil {
+		return "", err
+	}
+	if typeless {
+		return fmt.Sprintf("/%s/_doc/%s", index, id), nil
+	}
+	return fmt.Sprintf("/%s/doc/%s", index, id), nil
+}
+
+// pathUrl returns the url of the document identified by a typed or typeless path
+// Typeless clusters always use _doc, elasticsearch 6 keeps the type of the path or uses the doc type.
+func (self *Client) pathUrl(path string) (string, error) {
+	index, doc_type, id, err := SplitPath(path)
+	if err != nil {
+		return "", err
+	}
+	typeless, err := self.Typeless()
+	if err != nil {
+		return "", err
+	}
+	if doc_type == "" || typeless {
+		return self.documentUrl(index, id)
+	}
+	return fmt.Sprintf("/%s/%s/%s", index, doc_type, id), nil
+}
+
+// updateUrl returns the _update url of the document identified by path
+func (self *Client) updateUrl(path string) (string, error) {
+	typeless, err := self.Typeless()
+	if err != nil {
+		return "", err
+	}
+	index, doc_type, id, err := SplitPath(path)
+	if err != nil {
+		return "", err
+	}
+	if typeless {
+		return fmt.Sprintf("/%s/_update/%s", index, id), nil
+	}
+	return fmt.Sprintf("/%s/%s/%s/_update", index, typeOrDefault(doc_type), id), nil
+}
+
+// addHeaders adds the client headers to request
+func (self *Client) addHeaders(request *http.Request) {
+	for name := range self.Headers {
+		for _, value := range self.Headers[name] {
+			request.Header.Add(name, value)
+		}
+	}
+}
+
+// multiSearch sends a multisearch request to url and returns the responses
+func (self *Client) multiSearch(url string, bulk_queries []interface{}) ([]SearchResults, error) {
+	results := MultiSearchResults{}
+	err := self.BulkRequest("POST", url, bulk_queries, &results)
+	if err != nil {
+		return []SearchResults{}, err
+	}
+	return results.Responses, nil
+}
+
+// send sends a request body to the elasticsearch api, notifying the client hooks before and after, and decodes the response into results
+func (self *Client) send(ctx context.Context, method string, url string, content_type string, request_body []byte, results interface{}) error {
+	// create the request
+	full_url := fmt.Sprintf("%s%s", self.BaseUrl, url)
+	request, err := http.NewRequest(method, full_url, bytes.NewReader(request_body))
+	if err != nil {
+		return err
+	}
+
+	// add client headers and content-type header
+	self.addHeaders(request)
+	if content_type != "" {
+		request.Header.Set("Content-Type", content_type)
+	}
+
+	// notify hooks of the request
+	for i := range self.Hooks {
+		ctx = self.Hooks[i].BeforeRequest(ctx, request)
+	}
+	request = request.WithContext(ctx)
+	info := RequestInfo{
+		Method: method,
+		Url: url,
+		RequestSize: len(request_body),
+		RequestBody: request_body,
+	}
+	start := time.Now()
+
+	// do the request
+	response_body, err := self.do(request, &info)
+	info.Duration = time.Since(start)
+	info.ResponseSize = len(response_body)
+	info.ResponseBody = response_body
+	info.Err = err
+	metricsOrNop(self.Metrics).ObserveRequest(Endpoint(url), method, info.StatusCode, info.Duration)
+	for i := len(self.Hooks) - 1; i >= 0; i-- {
+		self.Hooks[i].AfterRequest(ctx, &info)
+	}
+	if err != nil {
+		return err
+	}
+
+	// decode response unless the caller does not need it
+	if results == nil {
+		return nil
+	}
+	err = json.Unmarshal(response_body, results)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// do executes the request and reads the response body, returning a *ResponseError for unsuccessful status codes
+func (self *Client) do(request *http.Request, info *RequestInfo) ([]byte, error) {
+	response, err := self.HttpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	// read the response
+	info.StatusCode = response.StatusCode
+	response_body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return response_body, err
+	}
+	if response.StatusCode != 200  && response.StatusCode != 201 {
+		return response_body, &ResponseError{StatusCode: response.StatusCode, Body: response_body}
+	}
+	return response_body, nil
+}
@@ -0,0 +1,94 @@
+package elk_test
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk"
+	"github.com/KarmaPenny/golib/elk/elktest"
+
+	"net/http"
+	"testing"
+)
+
+// replayClient returns a client that answers requests from a fixtures file and fails on any request that was not recorded
+// The product detection and lifecycle fixtures are written by hand from the documented responses of each version,
+// the pipeline fixtures are recorded by TestPipelineOnProducts.
+func replayClient(t *testing.T, path string) (*elk.Client, *elktest.Replayer) {
+	replayer, err := elktest.NewReplayer(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &elk.Client{BaseUrl: "http://fixtures", HttpClient: &http.Client{Transport: replayer}}, replayer
+}
+
+// checkReplayed fails the test if any recorded request was not sent
+func checkReplayed(t *testing.T, replayer *elktest.Replayer) {
+	for _, fixture := range replayer.Unused() {
+		t.Errorf("fixture was not used: %s %s", fixture.Method, fixture.Path)
+	}
+}
+
+func TestProductDetection(t *testing.T) {
+	tests := []struct {
+		fixtures string
+		opensearch bool
+		typeless bool
+		pit bool
+		composable bool
+	}{
+		{"testdata/elasticsearch-6.8.json", false, false, false, false},
+		{"testdata/elasticsearch-7.7.json", false, true, false, false},
+		{"testdata/elasticsearch-7.17.json", false, true, true, true},
+		{"testdata/opensearch-1.3.json", true, true, false, true},
+		{"testdata/opensearch-2.11.json", true, true, true, true},
+	}
+	for _, test := range tests {
+		client, _ := replayClient(t, test.fixtures)
+		info, err := client.Info()
+		if err != nil {
+			t.Fatalf("%s: %s", test.fixtures, err)
+		}
+		if info.Version.IsOpenSearch() != test.opensearch {
+			t.Errorf("%s: expected opensearch %v", test.fixtures, test.opensearch)
+		}
+		if info.Version.Typeless() != test.typeless {
+			t.Errorf("%s: expected typeless %v", test.fixtures, test.typeless)
+		}
+		if info.Version.SupportsPit() != test.pit {
+			t.Errorf("%s: expected point in time support %v", test.fixtures, test.pit)
+		}
+		if info.Version.SupportsComposableTemplates() != test.composable {
+			t.Errorf("%s: expected composable template support %v", test.fixtures, test.composable)
+		}
+	}
+}
+
+func TestMultiSearchTemplatePartialFailure(t *testing.T) {
+	server := elktest.NewServer()
+	defer server.Close()
+	server.Put("jobs", "1", Object{"status": "new"})
+	client := server.NewClient()
+	if _, err := client.PutSearchTemplate("by_status", `{"query":{"term":{"status":"{{status}}"}}}`); err != nil {
+		t.Fatal(err)
+	}
+	queries := []interface{}{
+		Object{}, Object{"id": "by_status", "params": Object{"status": "new"}},
+		Object{}, Object{"id": "missing", "params": Object{}},
+	}
+
+	// a failed search does not hide the hits of the others
+	hits, err := client.MultiSearchTemplate("jobs", queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 2 || len(hits[0]) != 1 || len(hits[1]) != 0 {
+		t.Fatalf("unexpected hits %v", hits)
+	}
+	responses, err := client.MultiSearchTemplateResponses("jobs", queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures, ok := elk.NewMultiSearchError(responses).(*elk.MultiSearchError)
+	if !ok || failures.Failures[1] == nil || failures.Failures[0] != nil {
+		t.Fatalf("expected only the second search to fail, got %v", failures)
+	}
+}
@@ -0,0 +1,79 @@
+package elk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// ClusterHealth returns the health of the cluster. If wait_for_status is set the request blocks until the
+// cluster reaches that status or timeout passes, in which case the results are returned with an error.
+// A zero timeout uses the default timeout of the cluster.
+func (self *Client) ClusterHealth(wait_for_status string, timeout time.Duration) (*ClusterHealthResults, error) {
+	results := ClusterHealthResults{}
+	parameters := url.Values{}
+	if wait_for_status != "" {
+		parameters.Set("wait_for_status", wait_for_status)
+		if timeout > 0 {
+			parameters.Set("timeout", fmt.Sprintf("%dms", timeout.Milliseconds()))
+		}
+	}
+	full_url := "/_cluster/health"
+	if len(parameters) > 0 {
+		full_url = fmt.Sprintf("%s?%s", full_url, parameters.Encode())
+	}
+	err := self.Request("GET", full_url, nil, &results)
+
+	// elasticsearch responds with 408 when the status is not reached in time
+	response_error := &ResponseError{}
+	if errors.As(err, &response_error) && response_error.StatusCode == 408 {
+		if json.Unmarshal(response_error.Body, &results) == nil {
+			err = errors.New(fmt.Sprintf("Cluster health is %s after waiting for %s", results.Status, wait_for_status))
+		}
+	}
+	return &results, err
+}
+
+// CatIndices returns the health, status, document counts and size of the indices matching pattern
+func (self *Client) CatIndices(pattern string) ([]CatIndex, error) {
+	results := []CatIndex{}
+	err := self.Request("GET", fmt.Sprintf("/_cat/indices%s?format=json&bytes=b", catPattern(pattern)), nil, &results)
+	return results, err
+}
+
+// CatShards returns the state and location of each shard of the indices matching pattern including why unassigned shards are unassigned
+func (self *Client) CatShards(pattern string) ([]CatShard, error) {
+	results := []CatShard{}
+	full_url := fmt.Sprintf("/_cat/shards%s?format=json&bytes=b&h=index,shard,prirep,state,docs,store,ip,node,unassigned.reason", catPattern(pattern))
+	err := self.Request("GET", full_url, nil, &results)
+	return results, err
+}
+
+// catPattern returns the index pattern path segment of a _cat request
+func catPattern(pattern string) string {
+	if pattern == "" {
+		return ""
+	}
+	return fmt.Sprintf("/%s", pattern)
+}
+
+// NodesStats returns memory, cpu, disk and index statistics of every node in the cluster
+func (self *Client) NodesStats() (*NodesStatsResults, error) {
+	results := NodesStatsResults{}
+	err := self.Request("GET", "/_nodes/stats/jvm,os,fs,indices", nil, &results)
+	return &results, err
+}
+
+// Tasks returns the running tasks whose action matches actions, such as *byquery. An empty actions returns every task.
+func (self *Client) Tasks(actions string) (*TasksResults, error) {
+	results := TasksResults{}
+	parameters := url.Values{}
+	parameters.Set("detailed", "true")
+	if actions != "" {
+		parameters.Set("actions", actions)
+	}
+	err := self.Request("GET", fmt.Sprintf("/_tasks?%s", parameters.Encode()), nil, &results)
+	return &results, err
+}
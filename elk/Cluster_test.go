@@ -0,0 +1,45 @@
+package elk_test
+
+import (
+	"github.com/KarmaPenny/golib/elk"
+	"github.com/KarmaPenny/golib/elk/elktest"
+
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClusterHealth(t *testing.T) {
+	tests := []struct {
+		health string
+		wait_for_status string
+		timeout time.Duration
+		path string
+		fails bool
+	}{
+		{"", "", 0, "/_cluster/health", false},
+		{"", elk.HEALTH_GREEN, 0, "/_cluster/health?wait_for_status=green", false},
+		{elk.HEALTH_YELLOW, elk.HEALTH_YELLOW, 5 * time.Second, "/_cluster/health?timeout=5000ms&wait_for_status=yellow", false},
+		{elk.HEALTH_YELLOW, elk.HEALTH_GREEN, time.Second, "/_cluster/health?timeout=1000ms&wait_for_status=green", true},
+		{elk.HEALTH_RED, elk.HEALTH_YELLOW, 0, "/_cluster/health?wait_for_status=yellow", true},
+	}
+	for _, test := range tests {
+		server := elktest.NewServer()
+		server.Health = test.health
+		recorder := elktest.NewRecorder("", server.Client().Transport)
+		client := &elk.Client{BaseUrl: server.URL, HttpClient: &http.Client{Transport: recorder}}
+
+		results, err := client.ClusterHealth(test.wait_for_status, test.timeout)
+		server.Close()
+		if test.fails != (err != nil) {
+			t.Errorf("waiting for %q on a %q cluster returned %v", test.wait_for_status, test.health, err)
+		}
+		if test.health != "" && results.Status != test.health {
+			t.Errorf("status is %q, expected %q", results.Status, test.health)
+		}
+		fixtures := recorder.Fixtures()
+		if len(fixtures) != 1 || fixtures[0].Path != test.path {
+			t.Errorf("requested %v, expected %s", fixtures, test.path)
+		}
+	}
+}
@@ -20,9 +20,56 @@ const (
 	ALERT_STATUS_DAMAGE = "DAMAGE"
 )
 
+// ALERT_STATUSES lists every alert_status constant
+var ALERT_STATUSES = []string{
+	ALERT_STATUS_NONE,
+	ALERT_STATUS_QUEUED,
+	ALERT_STATUS_FALSE_POSITIVE,
+	ALERT_STATUS_IGNORE,
+	ALERT_STATUS_UNKNOWN,
+	ALERT_STATUS_REVIEWED,
+	ALERT_STATUS_GRAYWARE,
+	ALERT_STATUS_POLICY_VIOLATION,
+	ALERT_STATUS_RECONNAISSANCE,
+	ALERT_STATUS_WEAPONIZATION,
+	ALERT_STATUS_DELIVERY,
+	ALERT_STATUS_EXPLOITATION,
+	ALERT_STATUS_INSTALLATION,
+	ALERT_STATUS_COMMAND_AND_CONTROL,
+	ALERT_STATUS_EXFIL,
+	ALERT_STATUS_DAMAGE,
+}
+
 // analysis_status constants
 const (
 	ANALYSIS_STATUS_QUEUED = "QUEUED"
 	ANALYSIS_STATUS_ANALYZING = "ANALYZING"
 	ANALYSIS_STATUS_COMPLETE = "COMPLETE"
 )
+
+// distribution constants
+const (
+	DISTRIBUTION_ELASTICSEARCH = ""
+	DISTRIBUTION_OPENSEARCH = "opensearch"
+)
+
+// cluster health status constants
+const (
+	HEALTH_GREEN = "green"
+	HEALTH_YELLOW = "yellow"
+	HEALTH_RED = "red"
+)
+
+// job lock constants, LOCK_UNTIL_FOREVER is the last millisecond of the year 9999
+const (
+	LOCK_OWNER_NONE = "None"
+	LOCK_OWNER_FAILED = "Failed"
+	LOCK_UNTIL_FOREVER = "253402300799999"
+)
+
+// field change kind constants
+const (
+	FIELD_ADDED = "added"
+	FIELD_REMOVED = "removed"
+	FIELD_CHANGED = "changed"
+)
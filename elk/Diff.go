@@ -0,0 +1,159 @@
+package elk
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// UpdateDiff is the change an update would make to a document
+// Found field is false if the document does not exist, in which case After is the document the update would create or Error is set if the update would fail
+// Error field is set if the update can not be applied, such as when the script fails
+type UpdateDiff struct {
+	Path string
+	Found bool
+	Before Object
+	After Object
+	Changes []FieldChange
+	Error error
+}
+
+// FieldChange is a field that an update adds, removes or changes. Nested fields are named with dots and Kind is one of the FIELD constants.
+type FieldChange struct {
+	Field string
+	Kind string
+	Before interface{}
+	After interface{}
+}
+
+// PushDryRun returns the changes Push would make to each document, sorted by path, without writing anything
+// The current source of each document is fetched and the updates are applied locally with Update.ApplyTo.
+// Conditional updates whose sequence number and primary term do not match the document report an error that IsConflict matches.
+func (self *Client) PushDryRun(updates BulkUpdate) ([]UpdateDiff, error) {
+	diffs := []UpdateDiff{}
+	paths := []string{}
+	for path := range updates {
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		return diffs, nil
+	}
+	sort.Strings(paths)
+	documents, err := self.MultiGet(paths)
+	if err != nil {
+		return diffs, err
+	}
+	for i := range paths {
+		diff := UpdateDiff{Path: paths[i], Found: documents[i].Found}
+		if err := updates[paths[i]].checkCondition(&documents[i]); err != nil {
+			diff.Before = documents[i].Source
+			diff.Error = err
+			diffs = append(diffs, diff)
+			continue
+		}
+		if !diff.Found {
+			created, ok, err := updates[paths[i]].ApplyToMissing()
+			if err != nil {
+				diff.Error = err
+			} else if !ok {
+				diff.Error = errors.New(fmt.Sprintf("Document %s does not exist", paths[i]))
+			} else {
+				diff.After = created
+				diff.Changes = DiffObjects(Object{}, created)
+			}
+			diffs = append(diffs, diff)
+			continue
+		}
+		diff.Before = documents[i].Source
+		after, err := copyObject(diff.Before)
+		if err == nil {
+			err = updates[paths[i]].ApplyTo(after)
+		}
+		if err != nil {
+			diff.Error = err
+		} else {
+			diff.After = after
+			diff.Changes = DiffObjects(diff.Before, diff.After)
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// checkCondition returns the version conflict a conditional update would fail with on document
+func (self *Update) checkCondition(document *Document) error {
+	if !self.conditional {
+		return nil
+	}
+	if !document.Found {
+		return &conflictError{fmt.Sprintf("[%s]: version conflict, required seqNo [%d], primary term [%d] but no document was found", self.path, self.seq_no, self.primary_term)}
+	}
+	if document.SequenceNumber != self.seq_no || document.PrimaryTerm != self.primary_term {
+		return &conflictError{fmt.Sprintf("[%s]: version conflict, required seqNo [%d], primary term [%d]. current document has seqNo [%d] and primary term [%d]",
+			self.path, self.seq_no, self.primary_term, document.SequenceNumber, document.PrimaryTerm)}
+	}
+	return nil
+}
+
+// DiffObjects returns the fields that differ between before and after sorted by field. Objects are compared field by field and arrays as a whole.
+func DiffObjects(before Object, after Object) []FieldChange {
+	changes := []FieldChange{}
+	diffFields("", before, after, &changes)
+	sort.Slice(changes, func(i int, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// diffFields adds the differences between two objects to changes
+func diffFields(prefix string, before map[string]interface{}, after map[string]interface{}, changes *[]FieldChange) {
+	for field := range before {
+		name := prefix + field
+		after_value, ok := after[field]
+		if !ok {
+			*changes = append(*changes, FieldChange{Field: name, Kind: FIELD_REMOVED, Before: before[field]})
+			continue
+		}
+		before_object, before_is_object := asMap(before[field])
+		after_object, after_is_object := asMap(after_value)
+		if before_is_object && after_is_object {
+			diffFields(name + ".", before_object, after_object, changes)
+		} else if !reflect.DeepEqual(before[field], after_value) {
+			*changes = append(*changes, FieldChange{Field: name, Kind: FIELD_CHANGED, Before: before[field], After: after_value})
+		}
+	}
+	for field := range after {
+		if _, ok := before[field]; !ok {
+			*changes = append(*changes, FieldChange{Field: prefix + field, Kind: FIELD_ADDED, After: after[field]})
+		}
+	}
+}
+
+// asMap returns value as a map if it is an object
+func asMap(value interface{}) (map[string]interface{}, bool) {
+	switch typed := value.(type) {
+		case map[string]interface{}:
+			return typed, true
+		case Object:
+			return typed, true
+	}
+	return nil, false
+}
+
+// copyObject deep copies a document source by encoding it as json. A nil source is copied as an empty object.
+func copyObject(source Object) (Object, error) {
+	copied := Object{}
+	data, err := json.Marshal(source)
+	if err != nil {
+		return copied, err
+	}
+	err = json.Unmarshal(data, &copied)
+	if copied == nil {
+		copied = Object{}
+	}
+	return copied, err
+}
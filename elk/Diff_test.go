@@ -0,0 +1,194 @@
+package elk_test
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk"
+	"github.com/KarmaPenny/golib/elk/elktest"
+
+	"reflect"
+	"testing"
+)
+
+func TestDiffObjects(t *testing.T) {
+	before := Object{
+		"status": "new",
+		"score": 1.0,
+		"removed": true,
+		"tags": Array{"a", "b"},
+		"owner": Object{"name": "x", "team": "y"},
+	}
+	after := Object{
+		"status": "done",
+		"score": 1.0,
+		"tags": Array{"a", "c"},
+		"owner": map[string]interface{}{"name": "x", "email": "x@example.com"},
+		"added": Object{"n": 1.0},
+	}
+	expected := []elk.FieldChange{
+		{Field: "added", Kind: elk.FIELD_ADDED, After: Object{"n": 1.0}},
+		{Field: "owner.email", Kind: elk.FIELD_ADDED, After: "x@example.com"},
+		{Field: "owner.team", Kind: elk.FIELD_REMOVED, Before: "y"},
+		{Field: "removed", Kind: elk.FIELD_REMOVED, Before: true},
+		{Field: "status", Kind: elk.FIELD_CHANGED, Before: "new", After: "done"},
+		{Field: "tags", Kind: elk.FIELD_CHANGED, Before: Array{"a", "b"}, After: Array{"a", "c"}},
+	}
+	if changes := elk.DiffObjects(before, after); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+	if changes := elk.DiffObjects(before, before); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+
+	// an object replacing a value is a change of the whole field
+	changes := elk.DiffObjects(Object{"owner": "x"}, Object{"owner": Object{"name": "x"}})
+	if len(changes) != 1 || changes[0].Field != "owner" || changes[0].Kind != elk.FIELD_CHANGED {
+		t.Errorf("expected owner to change, got %+v", changes)
+	}
+}
+
+func TestApplyToMissing(t *testing.T) {
+	script := elk.NewUpdate("/jobs/1")
+	script.SetField("status", "done")
+
+	upsert := elk.NewUpdate("/jobs/1")
+	upsert.SetField("status", "done")
+	upsert.SetUpsert(Object{"status": "new"})
+
+	scripted := elk.NewUpdate("/jobs/1")
+	scripted.SetField("status", "done")
+	scripted.SetUpsert(Object{"n": 1})
+	scripted.UseScriptedUpsert()
+
+	merge := elk.NewUpdate("/jobs/1")
+	merge.MergeDocument(Object{"status": "merged"})
+
+	doc_as_upsert := elk.NewUpdate("/jobs/1")
+	doc_as_upsert.MergeDocument(Object{"status": "merged"})
+	doc_as_upsert.UseDocAsUpsert()
+
+	tests := []struct {
+		name string
+		update *elk.Update
+		created bool
+		expected Object
+	}{
+		{"script", script, false, nil},
+		{"upsert", upsert, true, Object{"status": "new"}},
+		{"scripted upsert", scripted, true, Object{"n": 1.0, "status": "done"}},
+		{"merge", merge, false, nil},
+		{"doc as upsert", doc_as_upsert, true, Object{"status": "merged"}},
+	}
+	for _, test := range tests {
+		document, created, err := test.update.ApplyToMissing()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if created != test.created || (created && !reflect.DeepEqual(document, test.expected)) {
+			t.Errorf("%s: expected %v %v, got %v %v", test.name, test.created, test.expected, created, document)
+		}
+	}
+
+	invalid := elk.NewUpdate("/jobs")
+	if _, created, err := invalid.ApplyToMissing(); err == nil || created {
+		t.Errorf("expected an invalid update to fail, got %v %v", created, err)
+	}
+}
+
+// TestPushDryRun previews changes, missing documents and conditional updates without writing anything
+func TestPushDryRun(t *testing.T) {
+	server := elktest.NewServer()
+	defer server.Close()
+	client := server.NewClient()
+	server.Put("jobs", "1", Object{"status": "new", "n": 1})
+	server.Put("jobs", "2", Object{"status": "new"})
+	server.Put("jobs", "2", Object{"status": "seen"})
+	current, err := client.GetDocument("/jobs/_doc/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := elk.NewUpdate("/jobs/_doc/1")
+	changed.SetField("status", "done")
+	changed.RemoveField("n")
+	changed.IfSequenceNumber(current.SequenceNumber, current.PrimaryTerm)
+
+	stale := elk.NewUpdate("/jobs/_doc/2")
+	stale.SetField("status", "done")
+	stale.IfSequenceNumber(0, 1)
+
+	missing := elk.NewUpdate("/jobs/_doc/3")
+	missing.SetField("status", "done")
+
+	upsert := elk.NewUpdate("/jobs/_doc/4")
+	upsert.SetField("status", "done")
+	upsert.SetUpsert(Object{"status": "new"})
+
+	conditional_missing := elk.NewUpdate("/jobs/_doc/5")
+	conditional_missing.SetUpsert(Object{"status": "new"})
+	conditional_missing.IfSequenceNumber(0, 1)
+
+	updates := elk.BulkUpdate{
+		"/jobs/_doc/1": changed,
+		"/jobs/_doc/2": stale,
+		"/jobs/_doc/3": missing,
+		"/jobs/_doc/4": upsert,
+		"/jobs/_doc/5": conditional_missing,
+	}
+
+	client.DryRun = true
+	results, err := client.Push(updates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results.Errors || len(results.Items) != 0 || len(results.Diffs) != 5 {
+		t.Fatalf("expected 5 diffs with errors and no items, got %+v", results)
+	}
+	diffs := results.Diffs
+
+	expected := []elk.FieldChange{
+		{Field: "n", Kind: elk.FIELD_REMOVED, Before: 1.0},
+		{Field: "status", Kind: elk.FIELD_CHANGED, Before: "new", After: "done"},
+	}
+	if diffs[0].Path != "/jobs/_doc/1" || !diffs[0].Found || diffs[0].Error != nil || !reflect.DeepEqual(diffs[0].Changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, diffs[0])
+	}
+	if !elk.IsConflict(diffs[1].Error) || !diffs[1].Found || diffs[1].Before["status"] != "seen" {
+		t.Errorf("expected a version conflict, got %+v", diffs[1])
+	}
+	if diffs[2].Found || diffs[2].Error == nil || elk.IsConflict(diffs[2].Error) {
+		t.Errorf("expected a missing document error, got %+v", diffs[2])
+	}
+	expected = []elk.FieldChange{{Field: "status", Kind: elk.FIELD_ADDED, After: "new"}}
+	if diffs[3].Found || diffs[3].Error != nil || !reflect.DeepEqual(diffs[3].Changes, expected) {
+		t.Errorf("expected the upsert to be created, got %+v", diffs[3])
+	}
+	if !elk.IsConflict(diffs[4].Error) {
+		t.Errorf("expected a conditional update of a missing document to conflict, got %+v", diffs[4])
+	}
+
+	// nothing was written
+	for id, status := range map[string]string{"1": "new", "2": "seen"} {
+		if document, _ := server.Get("jobs", id); document["status"] != status {
+			t.Errorf("dry run changed document %s: %v", id, document)
+		}
+	}
+	for _, id := range []string{"3", "4", "5"} {
+		if document, found := server.Get("jobs", id); found {
+			t.Errorf("dry run created document %s: %v", id, document)
+		}
+	}
+
+	// pushing for real gives the same outcome
+	client.DryRun = false
+	results, err = client.Push(updates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if document, _ := server.Get("jobs", "1"); document["status"] != "done" || document["n"] != nil {
+		t.Errorf("expected the update to be applied, got %v", document)
+	}
+	if document, _ := server.Get("jobs", "2"); document["status"] != "seen" {
+		t.Errorf("expected the stale update to conflict, got %v", document)
+	}
+}
@@ -2,7 +2,9 @@ package elk
 
 import (
 	. "github.com/KarmaPenny/golib/dynamics"
+	"errors"
 	"fmt"
+	"strings"
 )
 
 type Document struct {
@@ -18,6 +20,11 @@ type Document struct {
 	Score float32 `json:"_score"`
 	Source Object `json:"_source"`
 	Status int `json:"status"`
+	Error *ErrorCause `json:"error"`
+	Sort Array `json:"sort"`
+	Highlight map[string][]string `json:"highlight"`
+	Fields Object `json:"fields"`
+	InnerHits map[string]InnerHits `json:"inner_hits"`
 }
 
 // Key returns a uid for this document and version
@@ -25,7 +32,36 @@ func (self *Document) Key() string {
 	return fmt.Sprintf("%s|%d", self.Id, self.Version)
 }
 
-// Path returns the path in elasticsearch to this document
+// Path returns the path in elasticsearch to this document. Documents without a mapping type use _doc.
 func (self *Document) Path() string {
-	return fmt.Sprintf("/%s/%s/%s", self.Index, self.Type, self.Id)
+	doc_type := self.Type
+	if doc_type == "" {
+		doc_type = "_doc"
+	}
+	return fmt.Sprintf("/%s/%s/%s", self.Index, doc_type, self.Id)
+}
+
+// SplitPath splits a document path into its index, type and id. The type is empty for typeless paths of the form /index/id
+func SplitPath(path string) (string, string, string, error) {
+	ids := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i := range ids {
+		if ids[i] == "" {
+			return "", "", "", errors.New(fmt.Sprintf("Invalid document path: %s", path))
+		}
+	}
+	switch len(ids) {
+		case 2:
+			return ids[0], "", ids[1], nil
+		case 3:
+			return ids[0], ids[1], ids[2], nil
+	}
+	return "", "", "", errors.New(fmt.Sprintf("Invalid document path: %s", path))
+}
+
+// typeOrDefault returns doc_type or the doc type used on elasticsearch 6 if doc_type is empty
+func typeOrDefault(doc_type string) string {
+	if doc_type == "" {
+		return "doc"
+	}
+	return doc_type
 }
@@ -0,0 +1,77 @@
+package elk_test
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk"
+	"github.com/KarmaPenny/golib/elk/elktest"
+
+	"testing"
+)
+
+// TestTypelessPaths sends /index/id paths through every document api on typed and typeless clusters
+func TestTypelessPaths(t *testing.T) {
+	for _, version := range []string{"6.8.23", elktest.DefaultVersion, "2.11.0"} {
+		server := elktest.NewServer()
+		server.Version = version
+		if version == "2.11.0" {
+			server.Distribution = elk.DISTRIBUTION_OPENSEARCH
+		}
+		client := server.NewClient()
+		for _, id := range []string{"1", "2", "3"} {
+			server.Put("jobs", id, Object{"status": "new", "owner": "a"})
+		}
+
+		if exists, err := client.Exists("/jobs/1"); err != nil || !exists {
+			t.Fatalf("%s: Exists: %v %v", version, exists, err)
+		}
+		if exists, err := client.Exists("/jobs/missing"); err != nil || exists {
+			t.Fatalf("%s: Exists of a missing document: %v %v", version, exists, err)
+		}
+		document, err := client.GetDocument("/jobs/1")
+		if err != nil || document.Source["status"] != "new" {
+			t.Fatalf("%s: GetDocument: %+v %v", version, document, err)
+		}
+		document, err = client.GetDocumentFields("/jobs/1", []string{"status"}, nil)
+		if err != nil || document.Source["status"] != "new" || document.Source["owner"] != nil {
+			t.Fatalf("%s: GetDocumentFields: %+v %v", version, document, err)
+		}
+		document, err = client.ReadModifyWrite("/jobs/1", func(document *elk.Document) error {
+			document.Source["status"] = "done"
+			return nil
+		})
+		if source, _ := server.Get("jobs", "1"); err != nil || source["status"] != "done" {
+			t.Fatalf("%s: ReadModifyWrite: %v %v", version, source, err)
+		}
+		if _, err = client.Delete("/jobs/2"); err != nil {
+			t.Fatalf("%s: Delete: %s", version, err)
+		}
+		current, err := client.GetDocument("/jobs/3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.DeleteIf("/jobs/3", current.SequenceNumber + 1, current.PrimaryTerm); !elk.IsConflict(err) {
+			t.Fatalf("%s: expected DeleteIf of a changed document to conflict, got %v", version, err)
+		}
+		if _, err = client.DeleteIf("/jobs/3", current.SequenceNumber, current.PrimaryTerm); err != nil {
+			t.Fatalf("%s: DeleteIf: %s", version, err)
+		}
+		for _, id := range []string{"2", "3"} {
+			if _, ok := server.Get("jobs", id); ok {
+				t.Errorf("%s: expected document %s to be deleted", version, id)
+			}
+		}
+
+		// typed paths still work and invalid paths are rejected before a request is sent
+		doc_type := "_doc"
+		if version == "6.8.23" {
+			doc_type = "doc"
+		}
+		if _, err = client.GetDocument("/jobs/" + doc_type + "/1"); err != nil {
+			t.Errorf("%s: GetDocument of a typed path: %s", version, err)
+		}
+		if _, err = client.GetDocument("/jobs"); err == nil {
+			t.Errorf("%s: expected an error for an invalid path", version)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,89 @@
+package elk
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound matches any response error with a 404 status code using errors.Is
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict matches any response error with a 409 status code using errors.Is
+var ErrConflict = errors.New("version conflict")
+
+// ResponseError is returned when elasticsearch responds with an unsuccessful status code
+type ResponseError struct {
+	StatusCode int
+	Body []byte
+}
+
+func (self *ResponseError) Error() string {
+	return fmt.Sprintf("StatusCode (%d): %s", self.StatusCode, self.Body)
+}
+
+// Is allows errors.Is to match ErrNotFound and ErrConflict against the status code
+func (self *ResponseError) Is(target error) bool {
+	switch target {
+		case ErrNotFound:
+			return self.StatusCode == 404
+		case ErrConflict:
+			return self.StatusCode == 409
+		default:
+			return false
+	}
+}
+
+// IsNotFound returns true if err is a response error with a 404 status code
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// IsConflict returns true if err is a response error with a 409 status code
+func IsConflict(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
+
+// conflictError is a version conflict found without sending a request, such as by PushDryRun. It matches ErrConflict using errors.Is.
+type conflictError struct {
+	message string
+}
+
+func (self *conflictError) Error() string {
+	return self.message
+}
+
+// Is allows errors.Is to match ErrConflict
+func (self *conflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// MultiSearchError describes the searches of a multisearch request that failed, NewMultiSearchError builds it from the responses
+type MultiSearchError struct {
+	Total int
+	Failures map[int]*ErrorCause
+}
+
+// NewMultiSearchError returns a *MultiSearchError for the failed responses or nil if every search succeeded
+func NewMultiSearchError(responses []SearchResults) error {
+	failures := map[int]*ErrorCause{}
+	for i := range responses {
+		if responses[i].Failed() {
+			failures[i] = responses[i].Error
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return &MultiSearchError{Total: len(responses), Failures: failures}
+}
+
+func (self *MultiSearchError) Error() string {
+	first := -1
+	for i := range self.Failures {
+		if first == -1 || i < first {
+			first = i
+		}
+	}
+	cause := self.Failures[first]
+	return fmt.Sprintf("%d of %d searches failed, search %d: %s: %s", len(self.Failures), self.Total, first, cause.Type, cause.Reason)
+}
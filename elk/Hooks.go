@@ -0,0 +1,146 @@
+package elk
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RequestInfo describes a completed request to elasticsearch
+// Url field is the path and query of the request without the client BaseUrl
+// Err field is the transport or status error of the request, if any
+type RequestInfo struct {
+	Method string
+	Url string
+	StatusCode int
+	Duration time.Duration
+	RequestSize int
+	ResponseSize int
+	RequestBody []byte
+	ResponseBody []byte
+	Err error
+}
+
+// Hook is notified around every request sent by a Client
+// BeforeRequest may return a new context, such as one carrying a trace span, that is used for the request and passed to AfterRequest
+type Hook interface {
+	BeforeRequest(ctx context.Context, request *http.Request) context.Context
+	AfterRequest(ctx context.Context, info *RequestInfo)
+}
+
+// Span is the subset of an OpenTelemetry span used by TracingHook
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// Tracer is the subset of an OpenTelemetry tracer used by TracingHook. Wrap an otel trace.Tracer to satisfy it.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// TracingHook starts a span for every request and ends it with the status, duration and sizes as attributes
+type TracingHook struct {
+	Tracer Tracer
+}
+
+type spanKey struct{}
+
+func (self *TracingHook) BeforeRequest(ctx context.Context, request *http.Request) context.Context {
+	ctx, span := self.Tracer.Start(ctx, "elasticsearch " + request.Method)
+	span.SetAttribute("db.system", "elasticsearch")
+	span.SetAttribute("http.request.method", request.Method)
+	span.SetAttribute("url.path", request.URL.Path)
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+func (self *TracingHook) AfterRequest(ctx context.Context, info *RequestInfo) {
+	span, ok := ctx.Value(spanKey{}).(Span)
+	if !ok {
+		return
+	}
+	span.SetAttribute("http.response.status_code", info.StatusCode)
+	span.SetAttribute("http.request.body.size", info.RequestSize)
+	span.SetAttribute("http.response.body.size", info.ResponseSize)
+	if info.Err != nil {
+		span.RecordError(errors.New(errorSummary(info.Err)))
+	}
+	span.End()
+}
+
+// errorSummary describes err without the response body, which may echo document values. Response errors are reduced to the status code and error type.
+func errorSummary(err error) string {
+	response_error := &ResponseError{}
+	if !errors.As(err, &response_error) {
+		return err.Error()
+	}
+	response := struct {
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}{}
+	if json.Unmarshal(response_error.Body, &response) != nil || response.Error.Type == "" {
+		return fmt.Sprintf("StatusCode (%d)", response_error.StatusCode)
+	}
+	return fmt.Sprintf("StatusCode (%d): %s", response_error.StatusCode, response.Error.Type)
+}
+
+// REDACTED replaces the values of redacted fields
+const REDACTED = "[REDACTED]"
+
+// RedactFields returns a function that replaces the value of every field named in fields with REDACTED in a json or ndjson body
+// Bodies that are not json are replaced entirely.
+func RedactFields(fields ...string) func([]byte) []byte {
+	redacted := map[string]bool{}
+	for _, field := range fields {
+		redacted[field] = true
+	}
+	return func(body []byte) []byte {
+		output := bytes.NewBuffer(make([]byte, 0))
+		for _, line := range strings.Split(strings.TrimRight(string(body), "\n"), "\n") {
+			if line == "" {
+				continue
+			}
+			var value interface{}
+			if err := json.Unmarshal([]byte(line), &value); err != nil {
+				return []byte(REDACTED)
+			}
+			data, _ := json.Marshal(redact(value, redacted))
+			output.Write(data)
+			output.WriteRune('\n')
+		}
+		return bytes.TrimRight(output.Bytes(), "\n")
+	}
+}
+
+// redact replaces the values of redacted fields in a decoded json value
+func redact(value interface{}, redacted map[string]bool) interface{} {
+	switch typed := value.(type) {
+		case map[string]interface{}:
+			object := Object{}
+			for key := range typed {
+				if redacted[key] {
+					object[key] = REDACTED
+				} else {
+					object[key] = redact(typed[key], redacted)
+				}
+			}
+			return object
+		case []interface{}:
+			array := Array{}
+			for i := range typed {
+				array = append(array, redact(typed[i], redacted))
+			}
+			return array
+		default:
+			return value
+	}
+}
@@ -0,0 +1,57 @@
+package elk_test
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk"
+	"github.com/KarmaPenny/golib/elk/elktest"
+
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+type recordingSpan struct {
+	errors []error
+}
+
+func (self *recordingSpan) SetAttribute(key string, value interface{}) {}
+func (self *recordingSpan) RecordError(err error) { self.errors = append(self.errors, err) }
+func (self *recordingSpan) End() {}
+
+type recordingTracer struct {
+	span recordingSpan
+}
+
+func (self *recordingTracer) Start(ctx context.Context, name string) (context.Context, elk.Span) {
+	return ctx, &self.span
+}
+
+func TestHookErrorsOmitResponseBodies(t *testing.T) {
+	server := elktest.NewServer()
+	defer server.Close()
+	server.Put("jobs", "1", Object{"secret": "hunter2"})
+
+	output := bytes.Buffer{}
+	tracer := &recordingTracer{}
+	client := server.NewClient()
+	client.Hooks = []elk.Hook{
+		&elk.SlogHook{Logger: slog.New(slog.NewTextHandler(&output, nil))},
+		&elk.TracingHook{Tracer: tracer},
+	}
+
+	// the error response of a failed update echoes the document value
+	if _, err := client.Create("jobs", "1", Object{"secret": "hunter2"}); err == nil {
+		t.Fatal("expected a conflict")
+	}
+	if strings.Contains(output.String(), "hunter2") || strings.Contains(output.String(), "document already exists") {
+		t.Fatalf("log contains the response body: %s", output.String())
+	}
+	if !strings.Contains(output.String(), "version_conflict_engine_exception") {
+		t.Fatalf("log is missing the error type: %s", output.String())
+	}
+	if len(tracer.span.errors) != 1 || strings.Contains(tracer.span.errors[0].Error(), "already exists") {
+		t.Fatalf("span recorded the response body: %v", tracer.span.errors)
+	}
+}
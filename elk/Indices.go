@@ -0,0 +1,158 @@
+package elk
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"fmt"
+)
+
+// CreateIndex creates an index. The body may contain settings, mappings and aliases or be nil.
+func (self *Client) CreateIndex(index string, body interface{}) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	err := self.Request("PUT", fmt.Sprintf("/%s", index), body, &results)
+	return &results, err
+}
+
+// DeleteIndex deletes an index and all of its documents
+func (self *Client) DeleteIndex(index string) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	err := self.Request("DELETE", fmt.Sprintf("/%s", index), nil, &results)
+	return &results, err
+}
+
+// IndexExists returns true if the index or alias exists
+func (self *Client) IndexExists(index string) (bool, error) {
+	err := self.Request("HEAD", fmt.Sprintf("/%s", index), nil, nil)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// EnsureIndex creates an index if it does not already exist. Creating an index that another client created first is not an error.
+func (self *Client) EnsureIndex(index string, body interface{}) error {
+	exists, err := self.IndexExists(index)
+	if err != nil || exists {
+		return err
+	}
+	_, err = self.CreateIndex(index, body)
+	if err != nil {
+		if exists, _ := self.IndexExists(index); exists {
+			return nil
+		}
+	}
+	return err
+}
+
+// GetMapping returns the mappings of an index by index name
+func (self *Client) GetMapping(index string) (MappingResults, error) {
+	results := MappingResults{}
+	err := self.Request("GET", fmt.Sprintf("/%s/_mapping", index), nil, &results)
+	return results, err
+}
+
+// PutMapping adds fields to the mapping of an index. The mapping should contain properties without a type name.
+func (self *Client) PutMapping(index string, mapping interface{}) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	typeless, err := self.Typeless()
+	if err != nil {
+		return &results, err
+	}
+	url := fmt.Sprintf("/%s/_mapping", index)
+	if !typeless {
+		url = fmt.Sprintf("/%s/_mapping/doc", index)
+	}
+	err = self.Request("PUT", url, mapping, &results)
+	return &results, err
+}
+
+// GetSettings returns the settings of an index by index name
+func (self *Client) GetSettings(index string) (IndexSettingsResults, error) {
+	results := IndexSettingsResults{}
+	err := self.Request("GET", fmt.Sprintf("/%s/_settings", index), nil, &results)
+	return results, err
+}
+
+// GetSetting returns the value of a single setting such as index.refresh_interval for each index by index name
+func (self *Client) GetSetting(index string, name string) (map[string]interface{}, error) {
+	results := FlatSettingsResults{}
+	values := map[string]interface{}{}
+	url := fmt.Sprintf("/%s/_settings/%s?flat_settings=true", index, name)
+	err := self.Request("GET", url, nil, &results)
+	if err != nil {
+		return values, err
+	}
+	for index_name := range results {
+		if value, ok := results[index_name].Settings[name]; ok {
+			values[index_name] = value
+		}
+	}
+	return values, nil
+}
+
+// PutSettings updates the dynamic settings of an index
+func (self *Client) PutSettings(index string, settings interface{}) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	err := self.Request("PUT", fmt.Sprintf("/%s/_settings", index), settings, &results)
+	return &results, err
+}
+
+// GetAlias returns the names of the indices an alias points to. An empty list is returned if the alias does not exist.
+func (self *Client) GetAlias(alias string) ([]string, error) {
+	results := AliasResults{}
+	indices := []string{}
+	err := self.Request("GET", fmt.Sprintf("/_alias/%s", alias), nil, &results)
+	if IsNotFound(err) {
+		return indices, nil
+	}
+	if err != nil {
+		return indices, err
+	}
+	for index := range results {
+		indices = append(indices, index)
+	}
+	return indices, nil
+}
+
+// UpdateAliases applies all alias actions atomically
+func (self *Client) UpdateAliases(actions ...Object) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	err := self.Request("POST", "/_aliases", Object{"actions": actions}, &results)
+	return &results, err
+}
+
+// AddAlias points alias at index
+func (self *Client) AddAlias(index string, alias string) (*AcknowledgedResults, error) {
+	return self.UpdateAliases(AddAliasAction(index, alias))
+}
+
+// RemoveAlias removes alias from index
+func (self *Client) RemoveAlias(index string, alias string) (*AcknowledgedResults, error) {
+	return self.UpdateAliases(RemoveAliasAction(index, alias))
+}
+
+// SwapAlias atomically moves alias from every index it currently points to onto index
+func (self *Client) SwapAlias(alias string, index string) (*AcknowledgedResults, error) {
+	current, err := self.GetAlias(alias)
+	if err != nil {
+		return &AcknowledgedResults{}, err
+	}
+	actions := []Object{}
+	for i := range current {
+		if current[i] != index {
+			actions = append(actions, RemoveAliasAction(current[i], alias))
+		}
+	}
+	actions = append(actions, AddAliasAction(index, alias))
+	return self.UpdateAliases(actions...)
+}
+
+// AddAliasAction returns an alias action that points alias at index
+func AddAliasAction(index string, alias string) Object {
+	return Object{"add": Object{"index": index, "alias": alias}}
+}
+
+// RemoveAliasAction returns an alias action that removes alias from index
+func RemoveAliasAction(index string, alias string) Object {
+	return Object{"remove": Object{"index": index, "alias": alias}}
+}
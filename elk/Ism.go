@@ -0,0 +1,232 @@
+package elk
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// lifecyclePhases is the order elasticsearch runs ILM phases in, used to chain the states of an ISM policy
+var lifecyclePhases = []string{"hot", "warm", "cold", "frozen", "delete"}
+
+// lifecycleActions is the order elasticsearch runs the actions of an ILM phase in
+var lifecycleActions = []string{"set_priority", "unfollow", "rollover", "readonly", "downsample", "allocate", "migrate", "shrink", "forcemerge", "searchable_snapshot", "freeze", "wait_for_snapshot", "delete"}
+
+// ismActionNames maps ILM action names to the ISM action names that differ
+var ismActionNames = map[string]string{"set_priority": "index_priority", "readonly": "read_only", "allocate": "allocation"}
+
+// ismRolloverConditions maps ILM rollover conditions to ISM rollover conditions
+var ismRolloverConditions = map[string]string{"max_age": "min_index_age", "max_docs": "min_doc_count", "max_size": "min_size", "max_primary_shard_size": "min_primary_shard_size"}
+
+// putIsmPolicy creates or replaces the ISM policy equivalent to an ILM policy on opensearch
+func (self *Client) putIsmPolicy(name string, policy *LifecyclePolicy) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	url := fmt.Sprintf("/_plugins/_ism/policies/%s", name)
+
+	// ISM only replaces a policy given its current sequence number
+	existing := IsmPolicyResults{}
+	err := self.Request("GET", url, nil, &existing)
+	if err == nil {
+		url = conditionalUrl(url, existing.SequenceNumber, existing.PrimaryTerm)
+	} else if !IsNotFound(err) {
+		return &results, err
+	}
+	err = self.Request("PUT", url, Object{"policy": NewIsmPolicy(policy)}, &IsmPolicyResults{})
+	results.Acknowledged = err == nil
+	return &results, err
+}
+
+// getIsmPolicy returns the ISM policy with name converted to an ILM policy
+func (self *Client) getIsmPolicy(name string) (*LifecyclePolicyVersion, error) {
+	results := IsmPolicyResults{}
+	err := self.Request("GET", fmt.Sprintf("/_plugins/_ism/policies/%s", name), nil, &results)
+	if err != nil {
+		return &LifecyclePolicyVersion{}, err
+	}
+	return &LifecyclePolicyVersion{
+		Version: results.Version,
+		ModifiedDate: strconv.FormatInt(results.Policy.LastUpdatedTime, 10),
+		Policy: *results.Policy.LifecyclePolicy(),
+	}, nil
+}
+
+// deleteIsmPolicy deletes an ISM policy
+func (self *Client) deleteIsmPolicy(name string) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	err := self.Request("DELETE", fmt.Sprintf("/_plugins/_ism/policies/%s", name), nil, &Object{})
+	results.Acknowledged = err == nil
+	return &results, err
+}
+
+// explainIsm returns the ISM state of each index matching index as ILM explanations where the state is the phase
+func (self *Client) explainIsm(index string) (map[string]LifecycleExplanation, error) {
+	explanations := map[string]LifecycleExplanation{}
+	results := map[string]json.RawMessage{}
+	err := self.Request("GET", fmt.Sprintf("/_plugins/_ism/explain/%s", index), nil, &results)
+	if err != nil {
+		return explanations, err
+	}
+	for name := range results {
+		if name == "total_managed_indices" {
+			continue
+		}
+		ism := IsmExplanation{}
+		if err = json.Unmarshal(results[name], &ism); err != nil {
+			return explanations, err
+		}
+		explanation := LifecycleExplanation{
+			Index: name,
+			Managed: ism.PolicyId != "",
+			Policy: ism.PolicyId,
+			Phase: ism.State.Name,
+			Action: ism.Action.Name,
+			Step: ism.Step.Name,
+			StepInfo: ism.Info,
+		}
+		if ism.Action.Failed {
+			explanation.FailedStep = ism.Step.Name
+		}
+		explanations[name] = explanation
+	}
+	return explanations, nil
+}
+
+// NewIsmPolicy converts an ILM policy to an opensearch ISM policy
+// Each phase becomes a state that transitions to the next phase once the index is older than its min_age.
+// The description is taken from the description field of the policy _meta.
+func NewIsmPolicy(policy *LifecyclePolicy) *IsmPolicy {
+	ism := IsmPolicy{States: []IsmState{}}
+	if description, ok := policy.Meta["description"].(string); ok {
+		ism.Description = description
+	}
+	phases := orderedKeys(policy.Phases, lifecyclePhases)
+	for i, phase := range phases {
+		state := IsmState{Name: phase, Actions: []Object{}, Transitions: []IsmTransition{}}
+		actions := policy.Phases[phase].Actions
+		for _, action := range orderedKeys(actions, lifecycleActions) {
+			config := actions[action]
+			if action == "rollover" {
+				config = renameFields(config, ismRolloverConditions)
+			}
+			if name, ok := ismActionNames[action]; ok {
+				action = name
+			}
+			if config == nil {
+				config = Object{}
+			}
+			state.Actions = append(state.Actions, Object{action: config})
+		}
+		if i + 1 < len(phases) {
+			transition := IsmTransition{StateName: phases[i + 1]}
+			if min_age := policy.Phases[phases[i + 1]].MinAge; min_age != "" {
+				transition.Conditions = Object{"min_index_age": min_age}
+			}
+			state.Transitions = append(state.Transitions, transition)
+		}
+		ism.States = append(ism.States, state)
+	}
+	if len(phases) > 0 {
+		ism.DefaultState = phases[0]
+	}
+	return &ism
+}
+
+// LifecyclePolicy converts an ISM policy to an ILM policy where each state is a phase
+func (self *IsmPolicy) LifecyclePolicy() *LifecyclePolicy {
+	policy := LifecyclePolicy{Phases: map[string]LifecyclePhase{}}
+	if self.Description != "" {
+		policy.Meta = Object{"description": self.Description}
+	}
+	for _, state := range self.States {
+		phase := policy.Phases[state.Name]
+		phase.Actions = Object{}
+		for _, action := range state.Actions {
+			for name := range action {
+				// retry and timeout configure the action rather than being actions
+				if name == "retry" || name == "timeout" {
+					continue
+				}
+				config := action[name]
+				for ilm_name, ism_name := range ismActionNames {
+					if name == ism_name {
+						name = ilm_name
+					}
+				}
+				if name == "rollover" {
+					config = renameFields(config, invertNames(ismRolloverConditions))
+				}
+				phase.Actions[name] = config
+			}
+		}
+		policy.Phases[state.Name] = phase
+
+		// the min_age of a phase is the condition of the transition into it
+		for _, transition := range state.Transitions {
+			next := policy.Phases[transition.StateName]
+			if min_age, ok := transition.Conditions["min_index_age"].(string); ok {
+				next.MinAge = min_age
+			}
+			policy.Phases[transition.StateName] = next
+		}
+	}
+	return &policy
+}
+
+// orderedKeys returns the keys of values in the given order followed by any other keys sorted by name
+func orderedKeys(values interface{}, order []string) []string {
+	keys := []string{}
+	switch typed := values.(type) {
+		case map[string]LifecyclePhase:
+			for key := range typed {
+				keys = append(keys, key)
+			}
+		case Object:
+			for key := range typed {
+				keys = append(keys, key)
+			}
+	}
+	rank := func(key string) int {
+		for i := range order {
+			if order[i] == key {
+				return i
+			}
+		}
+		return len(order)
+	}
+	sort.Slice(keys, func(i int, j int) bool {
+		if rank(keys[i]) != rank(keys[j]) {
+			return rank(keys[i]) < rank(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// renameFields returns a copy of an object with the fields in names renamed
+func renameFields(value interface{}, names map[string]string) interface{} {
+	fields, ok := asMap(value)
+	if !ok {
+		return value
+	}
+	renamed := Object{}
+	for field := range fields {
+		if name, ok := names[field]; ok {
+			renamed[name] = fields[field]
+		} else {
+			renamed[field] = fields[field]
+		}
+	}
+	return renamed
+}
+
+// invertNames swaps the keys and values of names
+func invertNames(names map[string]string) map[string]string {
+	inverted := map[string]string{}
+	for key := range names {
+		inverted[names[key]] = key
+	}
+	return inverted
+}
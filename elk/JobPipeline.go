@@ -2,9 +2,11 @@ package elk
 
 import (
 	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk/query"
 
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"time"
 )
@@ -13,12 +15,22 @@ import (
 // Filter field determines what documents in the index to process
 // Order field determines the order to process the documents
 // NumWorkers field sets the number of threads to process documents with
-// Task field is the function each document is passed to for processing
+// Task field is the function each document is passed to for processing, documents it panics on are locked forever by Client.Fail
 // TaskName field identifies the task for this pipeline inside elasticsearch
+// LockOptions field throttles the update by query tasks used to lock jobs, nil runs them unthrottled
+// Metrics field receives queue, lock, worker and task measurements labeled with TaskName, nil discards them
+// Logger field receives structured log messages of the pipeline and its workers, nil discards them
+// Now field returns the time lock_until and expires_at are computed from, nil uses time.Now
+// Host field identifies this pipeline among the pipelines of the task, empty uses the hostname
 type JobPipeline struct {
 	Client *Client
 	Filter Object
+	Host string
 	Index string
+	LockOptions *ByQueryOptions
+	Logger Logger
+	Metrics Metrics
+	Now func() time.Time
 	NumWorkers int
 	Order Array
 	Task func(*Document)
@@ -36,33 +48,69 @@ type JobPipeline struct {
 	workers []*Worker
 }
 
+// WorkersMapping is the mapping of the workers index used to split jobs between pipelines in the cluster
+var WorkersMapping = Object{
+	"properties": Object{
+		"task": Object{"type": "keyword"},
+		"expires_at": Object{"type": "date", "format": "epoch_millis"},
+	},
+}
+
+// LockMapping is the mapping of the lock fields the pipeline adds to each job
+var LockMapping = Object{
+	"properties": Object{
+		"lock_owner": Object{"type": "keyword"},
+		"lock_until": Object{"type": "date", "format": "epoch_millis"},
+	},
+}
+
+// EnsureIndices creates the workers index and the job index if they do not exist and maps the fields the pipeline depends on
+func (self *JobPipeline) EnsureIndices() error {
+	if err := self.Client.EnsureIndex("workers", nil); err != nil {
+		return err
+	}
+	if _, err := self.Client.PutMapping("workers", WorkersMapping); err != nil {
+		return err
+	}
+	if err := self.Client.EnsureIndex(self.Index, nil); err != nil {
+		return err
+	}
+	if _, err := self.Client.PutMapping(self.Index, LockMapping); err != nil {
+		return err
+	}
+	return nil
+}
+
 // Start initializes the pipeline and fires up all the workers
 func (self *JobPipeline) Start() {
-	self.host, _ = os.Hostname()
+	self.host = self.Host
+	if self.host == "" {
+		self.host, _ = os.Hostname()
+	}
 	self.id = fmt.Sprintf("%s|%s", self.TaskName, self.host)
 	self.refresh_interval = time.Second
 	self.last_update = time.Now().Add(-1 * self.refresh_interval)
 	self.queue = []*Document{}
 	self.running_jobs = map[string]bool{}
 
-	log.Printf("Starting %s", self.TaskName)
+	loggerOrNop(self.Logger).Info("starting pipeline", "task", self.TaskName, "pipeline_id", self.id, "workers", self.NumWorkers)
 	self.workers = make([]*Worker, self.NumWorkers)
 	for i := 0; i < self.NumWorkers; i++ {
-		self.workers[i] = &Worker{Client: self.Client, Task: self.Task}
+		self.workers[i] = &Worker{Client: self.Client, Id: i, Logger: self.Logger, Metrics: self.Metrics, Task: self.Task, TaskName: self.TaskName}
 		self.workers[i].Start()
 	}
 }
 
 // Stop tells all workers to stop and then waits for them to finish
 func (self *JobPipeline) Stop() {
-	log.Printf("Stopping %s", self.TaskName)
+	loggerOrNop(self.Logger).Info("stopping pipeline", "task", self.TaskName, "pipeline_id", self.id)
 	for i := range(self.workers) {
 		self.workers[i].Stop()
 	}
 	for i := range(self.workers) {
 		self.workers[i].WaitForStop()
 	}
-	log.Printf("%s Stopped", self.TaskName)
+	loggerOrNop(self.Logger).Info("pipeline stopped", "task", self.TaskName, "pipeline_id", self.id)
 }
 
 // ProcessNext attempts to assign the next job on the queue to a worker
@@ -84,6 +132,7 @@ func (self *JobPipeline) Process() {
 		if self.workers[i].SendJob(job) {
 			self.running_jobs[job.Key()] = true
 			self.queue_index++
+			metricsOrNop(self.Metrics).SetQueueDepth(self.TaskName, len(self.queue) - self.queue_index)
 			return
 		}
 	}
@@ -93,71 +142,48 @@ func (self *JobPipeline) Process() {
 }
 
 func (self *JobPipeline) refresh() {
+	metrics := metricsOrNop(self.Metrics)
+
 	// get the refresh interval of the targeted index
 	refresh_interval, err := self.Client.GetRefreshInterval(self.Index)
 	if err != nil {
-		log.Printf("[ERROR] unable to get %s refresh_interval: %s", self.Index, err)
+		self.refreshFailed("unable to get refresh_interval", err)
 		return
 	}
 	self.refresh_interval = refresh_interval
 
 	// add or update host entry in list of workers
-	expiration := Timestamp(time.Now().Add(10 * self.refresh_interval))
+	expiration := Timestamp(self.now().Add(10 * self.refresh_interval))
 	worker := Object{
 		"task": self.TaskName,
 		"expires_at": expiration,
 	}
 	_, err = self.Client.Index("workers", self.id, &worker)
 	if err != nil {
-		log.Printf("[ERROR] unable to register worker: %s", err)
+		self.refreshFailed("unable to register worker", err)
 		return
 	}
 
 	// find all workers in cluster
-	query := Object{
-		"size": 10000,
-		"_source": false,
-		"sort": Array{
-			Object{
-				"_id": "asc",
-			},
-		},
-		"query": Object{
-			"bool": Object{
-				"filter": Array{
-					Object{
-						"range": Object{
-							"expires_at": Object{
-								"gt": "now",
-							},
-						},
-					},
-					Object{
-						"term": Object{
-							"task": self.TaskName,
-						},
-					},
-				},
-			},
-		},
-	}
-	results, err := self.Client.Search("workers", &query)
-	if err != nil {
-		log.Printf("[ERROR] failed to get list of workers in cluster: %s", err)
-		return
-	}
-
-	// set slice max to number of workers in cluster
-	self.slice_max = len(results)
+	workers := self.Client.SearchIter(context.Background(), "workers", self.workersSearch())
+	defer workers.Close()
 
 	// set slice id to our position in the sorted list of workers
+	// set slice max to number of workers in cluster
+	slice_max := 0
 	self.slice_id = -1
-	for i := 0; i < len(results); i++ {
-		if results[i].Id == self.id {
-			self.slice_id = i
-			break
+	for workers.Next() {
+		if workers.Document().Id == self.id {
+			self.slice_id = slice_max
 		}
+		slice_max++
+	}
+	if err = workers.Err(); err != nil {
+		self.refreshFailed("failed to get list of workers in cluster", err)
+		return
 	}
+	self.slice_max = slice_max
+	metrics.SetClusterWorkers(self.TaskName, self.slice_max)
 
 	// wait until we are in the list of workers
 	if self.slice_id == -1 {
@@ -165,121 +191,159 @@ func (self *JobPipeline) refresh() {
 	}
 
 	// lock new jobs in our slice
-	expiration = Timestamp(time.Now().Add(10 * self.refresh_interval))
-	query = Object{
-		"script": Object{
-			"lang": "painless",
-			"source": "ctx._source.lock_owner = params.lock_owner; ctx._source.lock_until = params.lock_until",
-			"params": Object{
-				"lock_owner": self.id,
-				"lock_until": expiration,
-			},
-		},
-		"query": Object{
-			"bool": Object{
-				"should": Array{
-					Object{
-						"range": Object{
-							"lock_until": Object{
-								"lt": "now",
-							},
-						},
-					},
-					Object{
-						"bool": Object{
-							"must_not": Object{
-								"exists": Object{
-									"field": "lock_until",
-								},
-							},
-						},
-					},
-				},
-				"filter": Array{
-					self.Filter,
-				},
-				"minimum_should_match": 1,
-			},
-		},
-	}
-	if self.slice_max > 1 {
-		query["slice"] = Object{
-			"id": self.slice_id,
-			"max": self.slice_max,
-		}
-	}
-	_, err = self.Client.UpdateByQuery(self.Index, &query)
+	locked, err := self.updateByQuery(self.lockRequest(), self.slice_max > 1)
 	if err != nil {
-		log.Printf("[ERROR] unable to lock jobs: %s", err)
+		self.refreshFailed("unable to lock jobs", err)
 		return
 	}
+	metrics.AddLockAcquisitions(self.TaskName, locked.Updated)
 
 	// prevent locks we own from expiring
-	expiration = Timestamp(time.Now().Add(10 * self.refresh_interval))
-	query = Object{
-		"script": Object{
-			"lang": "painless",
-			"source": "ctx._source.lock_owner = params.lock_owner; ctx._source.lock_until = params.lock_until",
-			"params": Object{
-				"lock_owner": self.id,
-				"lock_until": expiration,
-			},
-		},
-		"query": Object{
-			"bool": Object{
-				"filter": Array{
-					Object{
-						"term": Object{
-							"lock_owner": self.id,
-						},
-					},
-				},
-			},
-		},
-	}
-	_, err = self.Client.UpdateByQuery(self.Index, &query)
+	renewed, err := self.updateByQuery(self.renewRequest(), false)
 	if err != nil {
-		log.Printf("[ERROR] unable to renew locks: %s", err)
+		self.refreshFailed("unable to renew locks", err)
 		return
 	}
+	metrics.AddLockRenewals(self.TaskName, renewed.Updated)
 
 	// find all jobs that are locked by us
-	query = Object{
-		"size": 10000,
-		"_source": false,
-		"sort": self.Order,
-		"query": Object{
-			"bool": Object{
-				"filter": Array{
-					Object{
-						"term": Object{
-							"lock_owner": self.id,
-						},
-					},
-				},
-			},
-		},
-	}
-	results, err = self.Client.Search(self.Index, &query)
-	if err != nil {
-		log.Printf("[ERROR] failed to find locked jobs: %s", err)
-		return
-	}
+	jobs := self.Client.SearchIter(context.Background(), self.Index, self.jobsSearch())
+	defer jobs.Close()
 
 	// rebuild queue from jobs that are not currently running
 	new_running_jobs := map[string]bool{}
-	self.queue = []*Document{}
-	for i := range(results) {
-		key := results[i].Key()
+	queue := []*Document{}
+	for jobs.Next() {
+		job := jobs.Document()
+		key := job.Key()
 		if _, ok := self.running_jobs[key]; ok {
 			new_running_jobs[key] = true
 		} else {
-			self.queue = append(self.queue, &results[i])
+			queue = append(queue, job)
 		}
 	}
+	if err = jobs.Err(); err != nil {
+		self.refreshFailed("failed to find locked jobs", err)
+		return
+	}
+	self.queue = queue
 	self.running_jobs = new_running_jobs
 	self.queue_index = 0
+	metrics.SetQueueDepth(self.TaskName, len(self.queue))
 
 	// set lat update time
 	self.last_update = time.Now()
 }
+
+// refreshFailed logs an error that stopped the refresh and counts the failure
+func (self *JobPipeline) refreshFailed(msg string, err error) {
+	loggerOrNop(self.Logger).Error(msg,
+		"task", self.TaskName,
+		"pipeline_id", self.id,
+		"index", self.Index,
+		"slice_id", self.slice_id,
+		"slice_max", self.slice_max,
+		"error", err,
+	)
+	metricsOrNop(self.Metrics).IncRefreshFailures(self.TaskName)
+}
+
+// updateByQuery runs an update by query on the index as a task and waits until it completes or the locks it sets would expire
+// automatic slicing is disabled when the request is already restricted to a slice of the index
+func (self *JobPipeline) updateByQuery(request interface{}, sliced bool) (*UpdateByQueryResults, error) {
+	options := ByQueryOptions{}
+	if self.LockOptions != nil {
+		options = *self.LockOptions
+	}
+	if sliced {
+		options.Slices = ""
+	}
+	task, err := self.Client.UpdateByQueryAsync(self.Index, request, &options)
+	if err != nil {
+		return &UpdateByQueryResults{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * self.refresh_interval)
+	defer cancel()
+	results, err := task.Wait(ctx, nil)
+	if errors.Is(err, context.DeadlineExceeded) {
+		// a cancelled task still stores its result once it stops, so wait for that before deleting it
+		if cancel_err := task.Cancel(); cancel_err != nil {
+			loggerOrNop(self.Logger).Warn("unable to cancel task", "task", task.Id, "error", cancel_err)
+		} else {
+			cancel_ctx, cancel_cancel := context.WithTimeout(context.Background(), 10 * self.refresh_interval)
+			defer cancel_cancel()
+			results, _ = task.Wait(cancel_ctx, nil)
+		}
+	}
+	if results.Completed {
+		// completed tasks store their result in the .tasks index and nothing else removes it
+		if delete_err := task.DeleteResult(); delete_err != nil {
+			loggerOrNop(self.Logger).Warn("unable to delete task result", "task", task.Id, "error", delete_err)
+		}
+	} else {
+		loggerOrNop(self.Logger).Warn("task did not stop, its result will remain in the .tasks index", "task", task.Id)
+	}
+	if err != nil {
+		return &UpdateByQueryResults{}, err
+	}
+	return results.ByQueryResponse()
+}
+
+// workersSearch returns the search for the pipelines of the task that have not expired sorted by id
+func (self *JobPipeline) workersSearch() *query.SearchRequest {
+	return query.NewSearch().
+		FetchSource(false).
+		Sort(query.Asc("_id")).
+		Query(query.Bool().Filter(
+			query.Range("expires_at").Gt("now"),
+			query.Term("task", self.TaskName),
+		))
+}
+
+// lockRequest returns the update by query that locks the unlocked or expired jobs in the slice of this pipeline
+func (self *JobPipeline) lockRequest() *query.UpdateByQueryRequest {
+	lock := query.NewUpdateByQuery().
+		Script(self.lockScript()).
+		Query(query.Bool().
+			Should(
+				query.Range("lock_until").Lt("now"),
+				query.Bool().MustNot(query.Exists("lock_until")),
+			).
+			Filter(query.Raw(self.Filter)).
+			MinimumShouldMatch(1))
+	if self.slice_max > 1 {
+		lock.Slice(self.slice_id, self.slice_max)
+	}
+	return lock
+}
+
+// renewRequest returns the update by query that extends the locks owned by this pipeline
+func (self *JobPipeline) renewRequest() *query.UpdateByQueryRequest {
+	return query.NewUpdateByQuery().
+		Script(self.lockScript()).
+		Query(query.Bool().Filter(query.Term("lock_owner", self.id)))
+}
+
+// jobsSearch returns the search for the jobs locked by this pipeline in the pipeline order
+func (self *JobPipeline) jobsSearch() *query.SearchRequest {
+	return query.NewSearch().
+		FetchSource(false).
+		SortRaw(self.Order).
+		Query(query.Bool().Filter(query.Term("lock_owner", self.id)))
+}
+
+// lockScript returns a script that locks a job to this pipeline until 10 refresh intervals from now
+func (self *JobPipeline) lockScript() *query.Script {
+	expiration := Timestamp(self.now().Add(10 * self.refresh_interval))
+	return query.NewScript("ctx._source.lock_owner = params.lock_owner; ctx._source.lock_until = params.lock_until").
+		Param("lock_owner", self.id).
+		Param("lock_until", expiration)
+}
+
+// now returns the current time of the Now field or the wall clock
+func (self *JobPipeline) now() time.Time {
+	if self.Now == nil {
+		return time.Now()
+	}
+	return self.Now()
+}
@@ -0,0 +1,136 @@
+package elk
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// assertSameJson fails the test if the builder does not marshal to the same json as the handwritten query
+func assertSameJson(t *testing.T, name string, builder interface{}, literal interface{}) {
+	var built interface{}
+	var expected interface{}
+	data, _ := json.Marshal(builder)
+	json.Unmarshal(data, &built)
+	data, _ = json.Marshal(literal)
+	json.Unmarshal(data, &expected)
+	if !reflect.DeepEqual(built, expected) {
+		t.Errorf("%s: expected %v, got %v", name, expected, built)
+	}
+}
+
+// TestPipelineQueries compares the pipeline queries with the handwritten queries the builders replaced
+func TestPipelineQueries(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	pipeline := &JobPipeline{
+		TaskName: "scan",
+		Filter: Object{"term": Object{"status": "new"}},
+		Order: Array{Object{"priority": "desc"}, Object{"created": "asc"}},
+		Now: func() time.Time { return now },
+		id: "scan|host",
+		refresh_interval: time.Second,
+		slice_id: 1,
+		slice_max: 3,
+	}
+	expiration := Timestamp(now.Add(10 * time.Second))
+	script := Object{
+		"lang": "painless",
+		"source": "ctx._source.lock_owner = params.lock_owner; ctx._source.lock_until = params.lock_until",
+		"params": Object{
+			"lock_owner": "scan|host",
+			"lock_until": expiration,
+		},
+	}
+
+	assertSameJson(t, "workers", pipeline.workersSearch(), Object{
+		"_source": false,
+		"sort": Array{
+			Object{
+				"_id": "asc",
+			},
+		},
+		"query": Object{
+			"bool": Object{
+				"filter": Array{
+					Object{
+						"range": Object{
+							"expires_at": Object{
+								"gt": "now",
+							},
+						},
+					},
+					Object{
+						"term": Object{
+							"task": "scan",
+						},
+					},
+				},
+			},
+		},
+	})
+
+	assertSameJson(t, "lock", pipeline.lockRequest(), Object{
+		"script": script,
+		"query": Object{
+			"bool": Object{
+				"should": Array{
+					Object{
+						"range": Object{
+							"lock_until": Object{
+								"lt": "now",
+							},
+						},
+					},
+					Object{
+						"bool": Object{
+							"must_not": Object{
+								"exists": Object{
+									"field": "lock_until",
+								},
+							},
+						},
+					},
+				},
+				"filter": Array{
+					pipeline.Filter,
+				},
+				"minimum_should_match": 1,
+			},
+		},
+		"slice": Object{
+			"id": 1,
+			"max": 3,
+		},
+	})
+
+	lock_owner_filter := Object{
+		"bool": Object{
+			"filter": Array{
+				Object{
+					"term": Object{
+						"lock_owner": "scan|host",
+					},
+				},
+			},
+		},
+	}
+	assertSameJson(t, "renew", pipeline.renewRequest(), Object{
+		"script": script,
+		"query": lock_owner_filter,
+	})
+	assertSameJson(t, "jobs", pipeline.jobsSearch(), Object{
+		"_source": false,
+		"sort": pipeline.Order,
+		"query": lock_owner_filter,
+	})
+
+	// a single pipeline locks every job without slicing
+	pipeline.slice_id = 0
+	pipeline.slice_max = 1
+	if _, ok := pipeline.lockRequest().Source()["slice"]; ok {
+		t.Error("expected no slice for a single pipeline")
+	}
+}
@@ -0,0 +1,218 @@
+package elk_test
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk"
+	"github.com/KarmaPenny/golib/elk/elktest"
+
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestJobPipeline locks, queues and processes every job against the fake server using its clock
+func TestJobPipeline(t *testing.T) {
+	for _, version := range []string{"6.8.23", elktest.DefaultVersion} {
+		server := elktest.NewServer()
+		server.Version = version
+
+		// the server clock is far from the wall clock so locks computed from time.Now would already be expired
+		server.Clock.Set(time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC))
+		client := server.NewClient()
+		client.Logger = elk.NopLogger{}
+
+		for i := 0; i < 25; i++ {
+			server.Put("jobs", strconv.Itoa(i), Object{"n": i, "done": false})
+		}
+		if _, err := client.PutSettings("jobs", Object{"index": Object{"refresh_interval": "50ms"}}); err != nil {
+			t.Fatal(err)
+		}
+
+		lock := sync.Mutex{}
+		processed := map[string]int{}
+		pipeline := &elk.JobPipeline{
+			Client: client,
+			Index: "jobs",
+			Filter: Object{"term": Object{"done": false}},
+			Order: Array{Object{"n": "asc"}},
+			NumWorkers: 3,
+			TaskName: "test",
+			Logger: elk.NopLogger{},
+			Now: server.Clock.Now,
+			Task: func(job *elk.Document) {
+				lock.Lock()
+				processed[job.Id]++
+				lock.Unlock()
+				if _, err := client.Update(job.Path(), Object{"doc": Object{"done": true}}); err != nil {
+					t.Error(err)
+				}
+			},
+		}
+		if err := pipeline.EnsureIndices(); err != nil {
+			t.Fatal(err)
+		}
+		pipeline.Start()
+
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			pipeline.Process()
+			if countDone(t, client) == 25 {
+				break
+			}
+		}
+		pipeline.Stop()
+
+		lock.Lock()
+		if len(processed) != 25 {
+			t.Errorf("%s: expected 25 processed jobs, got %d", version, len(processed))
+		}
+		lock.Unlock()
+
+		// the worker registration expires relative to the injected clock
+		host, _ := os.Hostname()
+		worker, _ := server.Get("workers", "test|" + host)
+		expected := elk.Timestamp(server.Clock.Now().Add(500 * time.Millisecond))
+		if worker["expires_at"] != expected {
+			t.Errorf("%s: expected worker to expire at %s, got %v", version, expected, worker["expires_at"])
+		}
+
+		if stored := server.TaskResults(); len(stored) != 0 {
+			t.Errorf("%s: expected lock task results to be deleted, found %v", version, stored)
+		}
+		server.Close()
+	}
+}
+
+// countDone iterates over the finished jobs in pages smaller than the number of jobs
+func countDone(t *testing.T, client *elk.Client) int {
+	iterator := client.SearchIter(context.Background(), "jobs", Object{"size": 10, "query": Object{"term": Object{"done": true}}})
+	count := 0
+	for iterator.Next() {
+		count++
+	}
+	if err := iterator.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// TestLockTimeoutDeletesTaskResult cancels a lock that does not complete in time and deletes the result the cancelled task stores
+func TestLockTimeoutDeletesTaskResult(t *testing.T) {
+	server := elktest.NewServer()
+	defer server.Close()
+	server.HoldTasks = true
+	client := server.NewClient()
+	server.Put("jobs", "1", Object{"done": false})
+	if _, err := client.PutSettings("jobs", Object{"index": Object{"refresh_interval": "20ms"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	pipeline := &elk.JobPipeline{
+		Client: client,
+		Index: "jobs",
+		TaskName: "test",
+		Logger: elk.NopLogger{},
+		Now: server.Clock.Now,
+		Task: func(job *elk.Document) {
+			t.Errorf("job %s was processed without being locked", job.Id)
+		},
+	}
+	if err := pipeline.EnsureIndices(); err != nil {
+		t.Fatal(err)
+	}
+	pipeline.Start()
+	pipeline.Process()
+	pipeline.Stop()
+
+	if stored := server.TaskResults(); len(stored) != 0 {
+		t.Errorf("expected the cancelled task result to be deleted, found %v", stored)
+	}
+}
+
+// TestTaskPanic marks a job the task panics on as failed and keeps processing the other jobs
+func TestTaskPanic(t *testing.T) {
+	server := elktest.NewServer()
+	defer server.Close()
+	client := server.NewClient()
+	client.Logger = elk.NopLogger{}
+	for i := 0; i < 5; i++ {
+		server.Put("jobs", strconv.Itoa(i), Object{"done": false})
+	}
+	if _, err := client.PutSettings("jobs", Object{"index": Object{"refresh_interval": "20ms"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	lock := sync.Mutex{}
+	runs := map[string]int{}
+	pipeline := &elk.JobPipeline{
+		Client: client,
+		Index: "jobs",
+		Filter: Object{"term": Object{"done": false}},
+		NumWorkers: 1,
+		TaskName: "test",
+		Logger: elk.NopLogger{},
+		Now: server.Clock.Now,
+		Task: func(job *elk.Document) {
+			lock.Lock()
+			runs[job.Id]++
+			lock.Unlock()
+			if job.Id == "2" {
+				panic("bad job")
+			}
+			if _, err := client.Update(job.Path(), Object{"doc": Object{"done": true}}); err != nil {
+				t.Error(err)
+			}
+		},
+	}
+	if err := pipeline.EnsureIndices(); err != nil {
+		t.Fatal(err)
+	}
+	pipeline.Start()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) && countDone(t, client) < 4 {
+		pipeline.Process()
+	}
+
+	// keep processing after the locks of the other jobs are released to show the failed job is not run again
+	for settle := time.Now().Add(20 * 20 * time.Millisecond); time.Now().Before(settle); {
+		pipeline.Process()
+	}
+	pipeline.Stop()
+
+	lock.Lock()
+	defer lock.Unlock()
+	for i := 0; i < 5; i++ {
+		if runs[strconv.Itoa(i)] != 1 {
+			t.Errorf("job %d ran %d times", i, runs[strconv.Itoa(i)])
+		}
+	}
+	failed, _ := server.Get("jobs", "2")
+	if failed["lock_owner"] != elk.LOCK_OWNER_FAILED || failed["lock_until"] != elk.LOCK_UNTIL_FOREVER {
+		t.Errorf("expected the job to be locked forever, got %v", failed)
+	}
+}
+
+// TestNilLoggerDiscards leaves the default slog logger untouched when Logger is nil
+func TestNilLoggerDiscards(t *testing.T) {
+	output := bytes.Buffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&output, nil)))
+	defer slog.SetDefault(previous)
+
+	server := elktest.NewServer()
+	defer server.Close()
+	pipeline := &elk.JobPipeline{Client: server.NewClient(), Index: "jobs", TaskName: "test", Task: func(job *elk.Document) {}}
+	if err := pipeline.EnsureIndices(); err != nil {
+		t.Fatal(err)
+	}
+	pipeline.Start()
+	pipeline.Stop()
+	if output.Len() != 0 {
+		t.Errorf("expected nothing to be logged, got %s", output.String())
+	}
+}
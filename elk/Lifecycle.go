@@ -0,0 +1,182 @@
+package elk
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"errors"
+	"fmt"
+)
+
+// IndexTemplate is a composable index template applied to new indices matching IndexPatterns
+type IndexTemplate struct {
+	IndexPatterns []string `json:"index_patterns"`
+	Template *Template `json:"template,omitempty"`
+	ComposedOf []string `json:"composed_of,omitempty"`
+	Priority int `json:"priority,omitempty"`
+	Version int `json:"version,omitempty"`
+	DataStream Object `json:"data_stream,omitempty"`
+	Meta Object `json:"_meta,omitempty"`
+}
+
+// ComponentTemplate is a reusable block of settings, mappings and aliases used by index templates
+type ComponentTemplate struct {
+	Template Template `json:"template"`
+	Version int `json:"version,omitempty"`
+	Meta Object `json:"_meta,omitempty"`
+}
+
+type Template struct {
+	Settings Object `json:"settings,omitempty"`
+	Mappings Object `json:"mappings,omitempty"`
+	Aliases Object `json:"aliases,omitempty"`
+}
+
+// LifecyclePolicy is an ILM policy made of phases such as hot, warm, cold and delete
+type LifecyclePolicy struct {
+	Phases map[string]LifecyclePhase `json:"phases"`
+	Meta Object `json:"_meta,omitempty"`
+}
+
+type LifecyclePhase struct {
+	MinAge string `json:"min_age,omitempty"`
+	Actions Object `json:"actions"`
+}
+
+// PutIndexTemplate creates or replaces a composable index template. Composable templates require elasticsearch 7.8 or later, or opensearch.
+func (self *Client) PutIndexTemplate(name string, template *IndexTemplate) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	if err := self.requireComposableTemplates(); err != nil {
+		return &results, err
+	}
+	err := self.Request("PUT", fmt.Sprintf("/_index_template/%s", name), template, &results)
+	return &results, err
+}
+
+// GetIndexTemplate returns the index templates matching name, which may contain wildcards
+func (self *Client) GetIndexTemplate(name string) ([]NamedIndexTemplate, error) {
+	results := IndexTemplateResults{}
+	if err := self.requireComposableTemplates(); err != nil {
+		return results.IndexTemplates, err
+	}
+	err := self.Request("GET", fmt.Sprintf("/_index_template/%s", name), nil, &results)
+	return results.IndexTemplates, err
+}
+
+// DeleteIndexTemplate deletes an index template
+func (self *Client) DeleteIndexTemplate(name string) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	if err := self.requireComposableTemplates(); err != nil {
+		return &results, err
+	}
+	err := self.Request("DELETE", fmt.Sprintf("/_index_template/%s", name), nil, &results)
+	return &results, err
+}
+
+// PutComponentTemplate creates or replaces a component template
+func (self *Client) PutComponentTemplate(name string, template *ComponentTemplate) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	if err := self.requireComposableTemplates(); err != nil {
+		return &results, err
+	}
+	err := self.Request("PUT", fmt.Sprintf("/_component_template/%s", name), template, &results)
+	return &results, err
+}
+
+// GetComponentTemplate returns the component templates matching name, which may contain wildcards
+func (self *Client) GetComponentTemplate(name string) ([]NamedComponentTemplate, error) {
+	results := ComponentTemplateResults{}
+	if err := self.requireComposableTemplates(); err != nil {
+		return results.ComponentTemplates, err
+	}
+	err := self.Request("GET", fmt.Sprintf("/_component_template/%s", name), nil, &results)
+	return results.ComponentTemplates, err
+}
+
+// DeleteComponentTemplate deletes a component template
+func (self *Client) DeleteComponentTemplate(name string) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	if err := self.requireComposableTemplates(); err != nil {
+		return &results, err
+	}
+	err := self.Request("DELETE", fmt.Sprintf("/_component_template/%s", name), nil, &results)
+	return &results, err
+}
+
+// PutLifecyclePolicy creates or replaces an ILM policy. On opensearch the policy is converted to an ISM policy with NewIsmPolicy.
+func (self *Client) PutLifecyclePolicy(name string, policy *LifecyclePolicy) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	opensearch, err := self.IsOpenSearch()
+	if err != nil {
+		return &results, err
+	} else if opensearch {
+		return self.putIsmPolicy(name, policy)
+	}
+	err = self.Request("PUT", fmt.Sprintf("/_ilm/policy/%s", name), Object{"policy": policy}, &results)
+	return &results, err
+}
+
+// GetLifecyclePolicy returns the ILM policy with name. On opensearch the ISM policy is converted to an ILM policy.
+func (self *Client) GetLifecyclePolicy(name string) (*LifecyclePolicyVersion, error) {
+	opensearch, err := self.IsOpenSearch()
+	if err != nil {
+		return &LifecyclePolicyVersion{}, err
+	} else if opensearch {
+		return self.getIsmPolicy(name)
+	}
+	results := LifecyclePolicyResults{}
+	err = self.Request("GET", fmt.Sprintf("/_ilm/policy/%s", name), nil, &results)
+	if err != nil {
+		return &LifecyclePolicyVersion{}, err
+	}
+	policy := results[name]
+	return &policy, nil
+}
+
+// DeleteLifecyclePolicy deletes an ILM policy, or the ISM policy on opensearch
+func (self *Client) DeleteLifecyclePolicy(name string) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	opensearch, err := self.IsOpenSearch()
+	if err != nil {
+		return &results, err
+	} else if opensearch {
+		return self.deleteIsmPolicy(name)
+	}
+	err = self.Request("DELETE", fmt.Sprintf("/_ilm/policy/%s", name), nil, &results)
+	return &results, err
+}
+
+// ExplainLifecycle returns the current lifecycle phase, action and step of each index matching index. On opensearch the phase is the ISM state.
+func (self *Client) ExplainLifecycle(index string) (map[string]LifecycleExplanation, error) {
+	opensearch, err := self.IsOpenSearch()
+	if err != nil {
+		return map[string]LifecycleExplanation{}, err
+	} else if opensearch {
+		return self.explainIsm(index)
+	}
+	results := LifecycleExplainResults{}
+	err = self.Request("GET", fmt.Sprintf("/%s/_ilm/explain", index), nil, &results)
+	return results.Indices, err
+}
+
+// Rollover creates a new index for alias if any of the conditions such as max_age or max_docs are met. Nil conditions always roll over.
+func (self *Client) Rollover(alias string, conditions Object) (*RolloverResults, error) {
+	results := RolloverResults{}
+	var body interface{}
+	if conditions != nil {
+		body = Object{"conditions": conditions}
+	}
+	err := self.Request("POST", fmt.Sprintf("/%s/_rollover", alias), body, &results)
+	return &results, err
+}
+
+// requireComposableTemplates returns an error if the cluster does not support composable index and component templates
+func (self *Client) requireComposableTemplates() error {
+	info, err := self.Info()
+	if err != nil {
+		return err
+	}
+	if !info.Version.SupportsComposableTemplates() {
+		return errors.New(fmt.Sprintf("Composable index templates require elasticsearch 7.8 or later, the cluster is %s", info.Version.Number))
+	}
+	return nil
+}
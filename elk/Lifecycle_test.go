@@ -0,0 +1,71 @@
+package elk_test
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk"
+
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func jobsPolicy() *elk.LifecyclePolicy {
+	return &elk.LifecyclePolicy{Phases: map[string]elk.LifecyclePhase{
+		"hot": elk.LifecyclePhase{Actions: Object{"rollover": Object{"max_age": "1d"}}},
+		"delete": elk.LifecyclePhase{MinAge: "30d", Actions: Object{"delete": Object{}}},
+	}}
+}
+
+// TestLifecycle runs the same lifecycle calls against elasticsearch and opensearch fixtures, opensearch uses the ISM api
+func TestLifecycle(t *testing.T) {
+	for _, fixtures := range []string{"testdata/elasticsearch-7.17.json", "testdata/opensearch-2.11.json"} {
+		client, replayer := replayClient(t, fixtures)
+
+		if results, err := client.PutLifecyclePolicy("jobs", jobsPolicy()); err != nil || !results.Acknowledged {
+			t.Fatalf("%s: put policy: %v", fixtures, err)
+		}
+		policy, err := client.GetLifecyclePolicy("jobs")
+		if err != nil {
+			t.Fatalf("%s: get policy: %s", fixtures, err)
+		}
+		delete_phase := policy.Policy.Phases["delete"]
+		if policy.Version != 1 || delete_phase.MinAge != "30d" || delete_phase.Actions["delete"] == nil {
+			t.Errorf("%s: unexpected delete phase %+v", fixtures, delete_phase)
+		}
+		rollover, _ := json.Marshal(policy.Policy.Phases["hot"].Actions["rollover"])
+		if !strings.Contains(string(rollover), `"max_age":"1d"`) {
+			t.Errorf("%s: unexpected hot phase %+v", fixtures, policy.Policy.Phases["hot"])
+		}
+
+		// replacing an existing policy needs its sequence number on opensearch
+		if results, err := client.PutLifecyclePolicy("jobs", jobsPolicy()); err != nil || !results.Acknowledged {
+			t.Fatalf("%s: replace policy: %v", fixtures, err)
+		}
+
+		explanations, err := client.ExplainLifecycle("jobs-000001")
+		if err != nil {
+			t.Fatalf("%s: explain: %s", fixtures, err)
+		}
+		explanation := explanations["jobs-000001"]
+		if !explanation.Managed || explanation.Policy != "jobs" || explanation.Phase != "hot" || explanation.Action != "rollover" {
+			t.Errorf("%s: unexpected explanation %+v", fixtures, explanation)
+		}
+		if results, err := client.DeleteLifecyclePolicy("jobs"); err != nil || !results.Acknowledged {
+			t.Fatalf("%s: delete policy: %v", fixtures, err)
+		}
+		if _, err := client.PutIndexTemplate("jobs", &elk.IndexTemplate{IndexPatterns: []string{"jobs-*"}}); err != nil {
+			t.Fatalf("%s: put index template: %s", fixtures, err)
+		}
+		checkReplayed(t, replayer)
+	}
+}
+
+func TestComposableTemplatesRequire78(t *testing.T) {
+	client, replayer := replayClient(t, "testdata/elasticsearch-7.7.json")
+	if _, err := client.PutIndexTemplate("jobs", &elk.IndexTemplate{IndexPatterns: []string{"jobs-*"}}); err == nil {
+		t.Fatal("expected composable templates to be rejected before elasticsearch 7.8")
+	}
+	if unmatched := replayer.Unmatched(); len(unmatched) > 0 {
+		t.Fatalf("unexpected requests %v", unmatched)
+	}
+}
@@ -0,0 +1,25 @@
+package elk
+
+// Logger receives structured log messages with alternating key and value arguments. *slog.Logger satisfies it.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// NopLogger discards all log messages, for example in tests
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, args ...interface{}) {}
+func (NopLogger) Info(msg string, args ...interface{}) {}
+func (NopLogger) Warn(msg string, args ...interface{}) {}
+func (NopLogger) Error(msg string, args ...interface{}) {}
+
+// loggerOrNop returns logger or a NopLogger if logger is nil
+func loggerOrNop(logger Logger) Logger {
+	if logger == nil {
+		return NopLogger{}
+	}
+	return logger
+}
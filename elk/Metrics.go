@@ -0,0 +1,88 @@
+package elk
+
+import (
+	"strings"
+	"time"
+)
+
+// Metrics receives measurements from clients, pipelines and workers
+// Endpoint arguments are the elasticsearch api of a request such as _search or _bulk
+// Task arguments are the TaskName of the pipeline
+type Metrics interface {
+	ObserveRequest(endpoint string, method string, status int, duration time.Duration)
+	AddBulkFailures(count int)
+	SetQueueDepth(task string, depth int)
+	AddRunningJobs(task string, delta int)
+	ObserveTask(task string, duration time.Duration)
+	IncTaskPanics(task string)
+	AddLockAcquisitions(task string, count int)
+	AddLockRenewals(task string, count int)
+	SetClusterWorkers(task string, count int)
+	IncRefreshFailures(task string)
+}
+
+// NopMetrics discards all measurements. It is used when no Metrics are set.
+type NopMetrics struct{}
+
+func (NopMetrics) ObserveRequest(endpoint string, method string, status int, duration time.Duration) {}
+func (NopMetrics) AddBulkFailures(count int) {}
+func (NopMetrics) SetQueueDepth(task string, depth int) {}
+func (NopMetrics) AddRunningJobs(task string, delta int) {}
+func (NopMetrics) ObserveTask(task string, duration time.Duration) {}
+func (NopMetrics) IncTaskPanics(task string) {}
+func (NopMetrics) AddLockAcquisitions(task string, count int) {}
+func (NopMetrics) AddLockRenewals(task string, count int) {}
+func (NopMetrics) SetClusterWorkers(task string, count int) {}
+func (NopMetrics) IncRefreshFailures(task string) {}
+
+// metricsOrNop returns metrics or NopMetrics if metrics is nil
+func metricsOrNop(metrics Metrics) Metrics {
+	if metrics == nil {
+		return NopMetrics{}
+	}
+	return metrics
+}
+
+// Endpoint returns the elasticsearch api of a request url so that metrics are not labeled with index names or ids
+func Endpoint(url string) string {
+	path := strings.SplitN(url, "?", 2)[0]
+	segments := []string{}
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	for i := range segments {
+		// typeless document paths are labeled the same as typed ones
+		if segments[i] == "_doc" && i == 1 && len(segments) <= 3 {
+			return "document"
+		}
+		if strings.HasPrefix(segments[i], "_") {
+			return segments[i]
+		}
+	}
+	switch len(segments) {
+		case 0:
+			return "root"
+		case 1:
+			return "index"
+		default:
+			return "document"
+	}
+}
+
+// countBulkFailures returns the number of bulk items that failed
+func countBulkFailures(results *BulkResults) int {
+	if !results.Errors {
+		return 0
+	}
+	failures := 0
+	for i := range results.Items {
+		for _, item := range []*Document{&results.Items[i].Index, &results.Items[i].Create, &results.Items[i].Update, &results.Items[i].Delete} {
+			if item.Error != nil {
+				failures++
+			}
+		}
+	}
+	return failures
+}
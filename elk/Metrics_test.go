@@ -0,0 +1,27 @@
+package elk_test
+
+import (
+	"github.com/KarmaPenny/golib/elk"
+
+	"testing"
+)
+
+func TestEndpoint(t *testing.T) {
+	endpoints := map[string]string{
+		"/": "root",
+		"/jobs": "index",
+		"/jobs/doc/1": "document",
+		"/jobs/_doc/1?refresh=true": "document",
+		"/jobs/doc": "document",
+		"/jobs/_doc": "document",
+		"/jobs/_update/1": "_update",
+		"/jobs/doc/1/_update": "_update",
+		"/jobs/_search": "_search",
+		"/_bulk": "_bulk",
+	}
+	for url, expected := range endpoints {
+		if endpoint := elk.Endpoint(url); endpoint != expected {
+			t.Errorf("%s: expected %s, got %s", url, expected, endpoint)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package elk_test
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk"
+	"github.com/KarmaPenny/golib/elk/elktest"
+
+	"net/http"
+	"os"
+	"testing"
+)
+
+// products are the clusters the pipeline scenario fixtures are recorded from
+// The checked in fixtures were recorded from the fake server configured as each product. Set the environment variable of a
+// product to the url of a real cluster of that version to record its fixtures again, or to elktest to record them from the fake server.
+var products = []struct {
+	fixtures string
+	env string
+	version string
+	distribution string
+}{
+	{"testdata/elasticsearch-7.17-pipeline.json", "ELK_RECORD_ELASTICSEARCH", "7.17.0", ""},
+	{"testdata/opensearch-2.11-pipeline.json", "ELK_RECORD_OPENSEARCH", "2.11.0", elk.DISTRIBUTION_OPENSEARCH},
+}
+
+// fixtureIndex is created and deleted by the scenario so recording does not touch other indices
+const fixtureIndex = "elk-fixture-jobs"
+
+// TestPipelineOnProducts runs a pipeline refresh and a push unchanged against elasticsearch and opensearch
+func TestPipelineOnProducts(t *testing.T) {
+	for _, product := range products {
+		target := os.Getenv(product.env)
+		if target == "" {
+			replayer, err := elktest.NewReplayer(product.fixtures, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// lock times are computed from the clock of the machine that recorded the fixtures
+			replayer.Ignore = []string{"lock_until", "expires_at"}
+			pipelineScenario(t, &elk.Client{BaseUrl: "http://fixtures", HttpClient: &http.Client{Transport: replayer}})
+			if unmatched := replayer.Unmatched(); len(unmatched) > 0 {
+				t.Errorf("%s: unexpected requests %v", product.fixtures, unmatched)
+			}
+			checkReplayed(t, replayer)
+			continue
+		}
+
+		// record the scenario from a cluster or from the fake server
+		var transport http.RoundTripper
+		if target == "elktest" {
+			server := elktest.NewServer()
+			defer server.Close()
+			server.Version = product.version
+			server.Distribution = product.distribution
+			target = server.URL
+			transport = server.Server.Client().Transport
+		}
+		recorder := elktest.NewRecorder(product.fixtures, transport)
+		pipelineScenario(t, &elk.Client{BaseUrl: target, HttpClient: &http.Client{Transport: recorder}})
+		if err := recorder.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// pipelineScenario indexes jobs, runs one pipeline refresh that locks them and pushes an update to one of them
+func pipelineScenario(t *testing.T, client *elk.Client) {
+	client.Logger = elk.NopLogger{}
+	pipeline := &elk.JobPipeline{
+		Client: client,
+		Index: fixtureIndex,
+		Filter: Object{"term": Object{"done": false}},
+		Order: Array{Object{"n": "asc"}},
+		Host: "recorder",
+		Logger: elk.NopLogger{},
+		TaskName: "fixture",
+	}
+	if err := pipeline.EnsureIndices(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.DeleteIndex(fixtureIndex)
+	defer client.Delete("/workers/fixture|recorder")
+	for _, id := range []string{"1", "2", "3"} {
+		if _, err := client.Index(fixtureIndex, id, Object{"n": len(id), "done": false}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	refresh := func() {
+		if err := client.Request("POST", "/" + fixtureIndex + "/_refresh", nil, &Object{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	refresh()
+
+	// a refresh with no workers registers the pipeline and locks every job without processing any
+	pipeline.Start()
+	pipeline.Process()
+	pipeline.Stop()
+	refresh()
+	for _, id := range []string{"1", "2", "3"} {
+		document, err := client.GetDocument("/" + fixtureIndex + "/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if document.Source["lock_owner"] != "fixture|recorder" {
+			t.Errorf("expected job %s to be locked by the pipeline, got %v", id, document.Source)
+		}
+	}
+
+	update := elk.NewUpdate("/" + fixtureIndex + "/1")
+	update.SetField("done", true)
+	results, err := client.Push(elk.BulkUpdate{"/" + fixtureIndex + "/1": update})
+	if err != nil || results.Errors {
+		t.Fatalf("push failed: %v %+v", err, results)
+	}
+	document, err := client.GetDocument("/" + fixtureIndex + "/1")
+	if err != nil || document.Source["done"] != true {
+		t.Fatalf("expected the pushed update to be applied, got %+v %v", document, err)
+	}
+}
@@ -0,0 +1,220 @@
+package elk
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the latency histograms
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// PrometheusMetrics implements Metrics and serves them in the prometheus text exposition format
+type PrometheusMetrics struct {
+	lock sync.Mutex
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	name string
+	help string
+	kind string
+	buckets []float64
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	value float64
+	counts []uint64
+	sum float64
+	count uint64
+}
+
+// NewPrometheusMetrics returns metrics with every family registered
+func NewPrometheusMetrics() *PrometheusMetrics {
+	metrics := &PrometheusMetrics{families: map[string]*metricFamily{}}
+	metrics.register("elk_request_duration_seconds", "histogram", "Latency of elasticsearch requests by endpoint, method and status.")
+	metrics.register("elk_bulk_item_failures_total", "counter", "Bulk items that elasticsearch failed to apply.")
+	metrics.register("elk_pipeline_queue_depth", "gauge", "Locked jobs waiting for a worker.")
+	metrics.register("elk_pipeline_running_jobs", "gauge", "Jobs currently being processed by workers.")
+	metrics.register("elk_task_duration_seconds", "histogram", "Time taken by the task function for each job.")
+	metrics.register("elk_task_panics_total", "counter", "Task functions that panicked.")
+	metrics.register("elk_lock_acquisitions_total", "counter", "Jobs newly locked by the pipeline.")
+	metrics.register("elk_lock_renewals_total", "counter", "Job locks renewed by the pipeline.")
+	metrics.register("elk_cluster_workers", "gauge", "Pipelines with the same task in the cluster.")
+	metrics.register("elk_pipeline_refresh_failures_total", "counter", "Pipeline refreshes that failed.")
+	return metrics
+}
+
+func (self *PrometheusMetrics) ObserveRequest(endpoint string, method string, status int, duration time.Duration) {
+	self.observe("elk_request_duration_seconds", duration.Seconds(), "endpoint", endpoint, "method", method, "status", strconv.Itoa(status))
+}
+
+func (self *PrometheusMetrics) AddBulkFailures(count int) {
+	self.add("elk_bulk_item_failures_total", float64(count))
+}
+
+func (self *PrometheusMetrics) SetQueueDepth(task string, depth int) {
+	self.set("elk_pipeline_queue_depth", float64(depth), "task", task)
+}
+
+func (self *PrometheusMetrics) AddRunningJobs(task string, delta int) {
+	self.add("elk_pipeline_running_jobs", float64(delta), "task", task)
+}
+
+func (self *PrometheusMetrics) ObserveTask(task string, duration time.Duration) {
+	self.observe("elk_task_duration_seconds", duration.Seconds(), "task", task)
+}
+
+func (self *PrometheusMetrics) IncTaskPanics(task string) {
+	self.add("elk_task_panics_total", 1, "task", task)
+}
+
+func (self *PrometheusMetrics) AddLockAcquisitions(task string, count int) {
+	self.add("elk_lock_acquisitions_total", float64(count), "task", task)
+}
+
+func (self *PrometheusMetrics) AddLockRenewals(task string, count int) {
+	self.add("elk_lock_renewals_total", float64(count), "task", task)
+}
+
+func (self *PrometheusMetrics) SetClusterWorkers(task string, count int) {
+	self.set("elk_cluster_workers", float64(count), "task", task)
+}
+
+func (self *PrometheusMetrics) IncRefreshFailures(task string) {
+	self.add("elk_pipeline_refresh_failures_total", 1, "task", task)
+}
+
+// ServeHTTP writes every metric in the prometheus text exposition format
+func (self *PrometheusMetrics) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	self.WriteTo(writer)
+}
+
+// WriteTo writes every metric in the prometheus text exposition format
+func (self *PrometheusMetrics) WriteTo(writer io.Writer) (int64, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	output := strings.Builder{}
+	names := []string{}
+	for name := range self.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		family := self.families[name]
+		fmt.Fprintf(&output, "# HELP %s %s\n", name, family.help)
+		fmt.Fprintf(&output, "# TYPE %s %s\n", name, family.kind)
+		labels := []string{}
+		for label := range family.series {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			series := family.series[label]
+			if family.kind != "histogram" {
+				fmt.Fprintf(&output, "%s%s %s\n", name, braces(label), formatFloat(series.value))
+				continue
+			}
+			for i, bound := range family.buckets {
+				fmt.Fprintf(&output, "%s_bucket%s %d\n", name, braces(joinLabels(label, fmt.Sprintf("le=\"%s\"", formatFloat(bound)))), series.counts[i])
+			}
+			fmt.Fprintf(&output, "%s_bucket%s %d\n", name, braces(joinLabels(label, "le=\"+Inf\"")), series.count)
+			fmt.Fprintf(&output, "%s_sum%s %s\n", name, braces(label), formatFloat(series.sum))
+			fmt.Fprintf(&output, "%s_count%s %d\n", name, braces(label), series.count)
+		}
+	}
+	written, err := io.WriteString(writer, output.String())
+	return int64(written), err
+}
+
+// register adds a metric family
+func (self *PrometheusMetrics) register(name string, kind string, help string) {
+	family := metricFamily{name: name, help: help, kind: kind, series: map[string]*metricSeries{}}
+	if kind == "histogram" {
+		family.buckets = DefaultBuckets
+	}
+	self.families[name] = &family
+}
+
+// get returns the series of a family with the given label pairs, creating it if needed. The lock must be held.
+func (self *PrometheusMetrics) get(name string, label_pairs []string) *metricSeries {
+	family := self.families[name]
+	label := formatLabels(label_pairs)
+	series, ok := family.series[label]
+	if !ok {
+		series = &metricSeries{counts: make([]uint64, len(family.buckets))}
+		family.series[label] = series
+	}
+	return series
+}
+
+func (self *PrometheusMetrics) add(name string, value float64, label_pairs ...string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.get(name, label_pairs).value += value
+}
+
+func (self *PrometheusMetrics) set(name string, value float64, label_pairs ...string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.get(name, label_pairs).value = value
+}
+
+func (self *PrometheusMetrics) observe(name string, value float64, label_pairs ...string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	series := self.get(name, label_pairs)
+	for i, bound := range self.families[name].buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+// formatLabels formats label name and value pairs as name="value",...
+func formatLabels(label_pairs []string) string {
+	labels := []string{}
+	for i := 0; i + 1 < len(label_pairs); i += 2 {
+		value := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(label_pairs[i+1])
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", label_pairs[i], value))
+	}
+	return strings.Join(labels, ",")
+}
+
+// joinLabels joins formatted labels
+func joinLabels(labels ...string) string {
+	nonempty := []string{}
+	for _, label := range labels {
+		if label != "" {
+			nonempty = append(nonempty, label)
+		}
+	}
+	return strings.Join(nonempty, ",")
+}
+
+// braces wraps formatted labels in braces unless there are none
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+// formatFloat formats a sample value
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
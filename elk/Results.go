@@ -1,16 +1,93 @@
 package elk
 
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type InfoResults struct {
+	Name string `json:"name"`
+	ClusterName string `json:"cluster_name"`
+	ClusterUuid string `json:"cluster_uuid"`
+	Version VersionInfo `json:"version"`
+	Tagline string `json:"tagline"`
+}
+
+type VersionInfo struct {
+	Number string `json:"number"`
+	Distribution string `json:"distribution"`
+	BuildFlavor string `json:"build_flavor"`
+	LuceneVersion string `json:"lucene_version"`
+}
+
+// Major returns the major version number
+func (self *VersionInfo) Major() int {
+	major, _ := strconv.Atoi(strings.SplitN(self.Number, ".", 3)[0])
+	return major
+}
+
+// Minor returns the minor version number
+func (self *VersionInfo) Minor() int {
+	parts := strings.SplitN(self.Number, ".", 3)
+	if len(parts) < 2 {
+		return 0
+	}
+	minor, _ := strconv.Atoi(parts[1])
+	return minor
+}
+
+// AtLeast returns true if the version is major.minor or later
+func (self *VersionInfo) AtLeast(major int, minor int) bool {
+	return self.Major() > major || (self.Major() == major && self.Minor() >= minor)
+}
+
+// IsOpenSearch returns true if the cluster is opensearch rather than elasticsearch
+func (self *VersionInfo) IsOpenSearch() bool {
+	return self.Distribution == DISTRIBUTION_OPENSEARCH
+}
+
+// SupportsPit returns true if the cluster supports point in time searches (elasticsearch 7.12+, opensearch 2.4+)
+// Elasticsearch added point in time in 7.10 but the _shard_doc tiebreaker search_after needs only exists from 7.12.
+func (self *VersionInfo) SupportsPit() bool {
+	if self.IsOpenSearch() {
+		return self.AtLeast(2, 4)
+	}
+	return self.AtLeast(7, 12)
+}
+
+// SupportsComposableTemplates returns true if the cluster supports _index_template and _component_template (elasticsearch 7.8+ and every opensearch version)
+func (self *VersionInfo) SupportsComposableTemplates() bool {
+	return self.IsOpenSearch() || self.AtLeast(7, 8)
+}
+
+// Typeless returns true if mapping types are removed and _doc endpoints are used (elasticsearch 7+ and every opensearch version)
+func (self *VersionInfo) Typeless() bool {
+	return self.IsOpenSearch() || self.Major() >= 7
+}
+
+// BulkResults is the response of a bulk request
+// Diffs field is only set by Push when Client.DryRun is set, in which case there are no Items
 type BulkResults struct {
 	Took int `json:"took"`
 	Errors bool `json:"errors"`
 	Items []OperationResults `json:"items"`
+	Diffs []UpdateDiff `json:"-"`
 }
 
 type OperationResults struct {
 	Index Document `json:"index"`
-	Delete Document `json:"index"`
-	Create Document `json:"index"`
-	Update Document `json:"index"`
+	Delete Document `json:"delete"`
+	Create Document `json:"create"`
+	Update Document `json:"update"`
+}
+
+type MultiGetResults struct {
+	Docs []Document `json:"docs"`
 }
 
 type MultiSearchResults struct {
@@ -22,6 +99,22 @@ type SearchResults struct {
 	TimedOut bool `json:"timed_out"`
 	Shards Shards `json:"_shards"`
 	Hits Hits `json:"hits"`
+	Aggregations Aggregations `json:"aggregations"`
+	PitId string `json:"pit_id"`
+	ScrollId string `json:"_scroll_id"`
+	Status int `json:"status"`
+	Error *ErrorCause `json:"error"`
+}
+
+// Failed returns true if the search of a multisearch response failed rather than finding no hits
+func (self *SearchResults) Failed() bool {
+	return self.Error != nil
+}
+
+// PitResults is the response to opening a point in time. Elasticsearch returns id while opensearch returns pit_id.
+type PitResults struct {
+	Id string `json:"id"`
+	PitId string `json:"pit_id"`
 }
 
 type Shards struct {
@@ -29,14 +122,52 @@ type Shards struct {
 	Successful int `json:"successful"`
 	Skipped int `json:"skipped"`
 	Failed int `json:"failed"`
+	Failures []ShardFailure `json:"failures"`
+}
+
+type ShardFailure struct {
+	Shard int `json:"shard"`
+	Index string `json:"index"`
+	Node string `json:"node"`
+	Status string `json:"status"`
+	Reason ErrorCause `json:"reason"`
+}
+
+type ErrorCause struct {
+	Type string `json:"type"`
+	Reason string `json:"reason"`
+	Index string `json:"index"`
+	CausedBy *ErrorCause `json:"caused_by"`
+	RootCause []ErrorCause `json:"root_cause"`
 }
 
 type Hits struct {
-	Total int `json:"total"`
+	Total Total `json:"total"`
 	MaxScore float32 `json:"max_score"`
 	Hits []Document `json:"hits"`
 }
 
+// Total is the number of hits, decoded from either the integer form of elasticsearch 6 or the {value, relation} form of elasticsearch 7+
+type Total struct {
+	Value int `json:"value"`
+	Relation string `json:"relation"`
+}
+
+func (self *Total) UnmarshalJSON(data []byte) error {
+	var value int
+	if err := json.Unmarshal(data, &value); err == nil {
+		self.Value = value
+		self.Relation = "eq"
+		return nil
+	}
+	type total Total
+	return json.Unmarshal(data, (*total)(self))
+}
+
+type InnerHits struct {
+	Hits Hits `json:"hits"`
+}
+
 type IndexSettingsResults map[string]SettingsResults
 
 type SettingsResults struct {
@@ -49,6 +180,36 @@ type Settings struct {
 
 type IndexSettings struct {
 	RefreshInterval string `json:"refresh_interval"`
+	NumberOfShards string `json:"number_of_shards"`
+	NumberOfReplicas string `json:"number_of_replicas"`
+	MaxResultWindow string `json:"max_result_window"`
+	CreationDate string `json:"creation_date"`
+	ProvidedName string `json:"provided_name"`
+	Uuid string `json:"uuid"`
+}
+
+type FlatSettingsResults map[string]FlatSettings
+
+type FlatSettings struct {
+	Settings map[string]interface{} `json:"settings"`
+}
+
+type MappingResults map[string]IndexMapping
+
+type IndexMapping struct {
+	Mappings Object `json:"mappings"`
+}
+
+type AliasResults map[string]IndexAliases
+
+type IndexAliases struct {
+	Aliases map[string]Object `json:"aliases"`
+}
+
+type AcknowledgedResults struct {
+	Acknowledged bool `json:"acknowledged"`
+	ShardsAcknowledged bool `json:"shards_acknowledged"`
+	Index string `json:"index"`
 }
 
 type UpdateByQueryResults struct {
@@ -56,6 +217,7 @@ type UpdateByQueryResults struct {
 	TimedOut bool `json:"timed_out"`
 	Total int `json:"total"`
 	Updated int `json:"updated"`
+	Created int `json:"created"`
 	Deleted int `json:"deleted"`
 	Batches int `json:"batches"`
 	VersionConflicts int `json:"version_conflicts"`
@@ -64,10 +226,286 @@ type UpdateByQueryResults struct {
 	ThrottledMillis int `json:"throttled_millis"`
 	RequestsPerSecond float64 `json:"requests_per_second"`
 	ThrottledUntilMillis int `json:"throttled_until_millis"`
-	Failures []interface{} `json:"failures"`
+	Failures []ByQueryFailure `json:"failures"`
+}
+
+type DeleteByQueryResults = UpdateByQueryResults
+
+type ReindexResults = UpdateByQueryResults
+
+// ByQueryFailure is either a bulk failure with an id and cause or a search failure with a shard and reason
+type ByQueryFailure struct {
+	Index string `json:"index"`
+	Type string `json:"type"`
+	Id string `json:"id"`
+	Status int `json:"status"`
+	Cause *ErrorCause `json:"cause"`
+	Shard int `json:"shard"`
+	Node string `json:"node"`
+	Reason *ErrorCause `json:"reason"`
 }
 
 type Retries struct {
 	Bulk int `json:"bulk"`
 	Search int `json:"search"`
 }
+
+type IndexTemplateResults struct {
+	IndexTemplates []NamedIndexTemplate `json:"index_templates"`
+}
+
+type NamedIndexTemplate struct {
+	Name string `json:"name"`
+	IndexTemplate IndexTemplate `json:"index_template"`
+}
+
+type ComponentTemplateResults struct {
+	ComponentTemplates []NamedComponentTemplate `json:"component_templates"`
+}
+
+type NamedComponentTemplate struct {
+	Name string `json:"name"`
+	ComponentTemplate ComponentTemplate `json:"component_template"`
+}
+
+type LifecyclePolicyResults map[string]LifecyclePolicyVersion
+
+type LifecyclePolicyVersion struct {
+	Version int `json:"version"`
+	ModifiedDate string `json:"modified_date"`
+	Policy LifecyclePolicy `json:"policy"`
+}
+
+type LifecycleExplainResults struct {
+	Indices map[string]LifecycleExplanation `json:"indices"`
+}
+
+type LifecycleExplanation struct {
+	Index string `json:"index"`
+	Managed bool `json:"managed"`
+	Policy string `json:"policy"`
+	Phase string `json:"phase"`
+	Action string `json:"action"`
+	Step string `json:"step"`
+	Age string `json:"age"`
+	FailedStep string `json:"failed_step"`
+	StepInfo Object `json:"step_info"`
+}
+
+type RolloverResults struct {
+	Acknowledged bool `json:"acknowledged"`
+	ShardsAcknowledged bool `json:"shards_acknowledged"`
+	OldIndex string `json:"old_index"`
+	NewIndex string `json:"new_index"`
+	RolledOver bool `json:"rolled_over"`
+	DryRun bool `json:"dry_run"`
+	Conditions map[string]bool `json:"conditions"`
+}
+
+type ClusterHealthResults struct {
+	ClusterName string `json:"cluster_name"`
+	Status string `json:"status"`
+	TimedOut bool `json:"timed_out"`
+	NumberOfNodes int `json:"number_of_nodes"`
+	NumberOfDataNodes int `json:"number_of_data_nodes"`
+	ActivePrimaryShards int `json:"active_primary_shards"`
+	ActiveShards int `json:"active_shards"`
+	RelocatingShards int `json:"relocating_shards"`
+	InitializingShards int `json:"initializing_shards"`
+	UnassignedShards int `json:"unassigned_shards"`
+	DelayedUnassignedShards int `json:"delayed_unassigned_shards"`
+	NumberOfPendingTasks int `json:"number_of_pending_tasks"`
+	ActiveShardsPercentAsNumber float64 `json:"active_shards_percent_as_number"`
+}
+
+type CatIndex struct {
+	Health string `json:"health"`
+	Status string `json:"status"`
+	Index string `json:"index"`
+	Uuid string `json:"uuid"`
+	Primaries string `json:"pri"`
+	Replicas string `json:"rep"`
+	DocsCount string `json:"docs.count"`
+	DocsDeleted string `json:"docs.deleted"`
+	StoreSize string `json:"store.size"`
+	PrimaryStoreSize string `json:"pri.store.size"`
+}
+
+type CatShard struct {
+	Index string `json:"index"`
+	Shard string `json:"shard"`
+	PrimaryOrReplica string `json:"prirep"`
+	State string `json:"state"`
+	Docs string `json:"docs"`
+	Store string `json:"store"`
+	Ip string `json:"ip"`
+	Node string `json:"node"`
+	UnassignedReason string `json:"unassigned.reason"`
+}
+
+type NodesStatsResults struct {
+	ClusterName string `json:"cluster_name"`
+	Nodes map[string]NodeStats `json:"nodes"`
+}
+
+type NodeStats struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Ip string `json:"ip"`
+	Roles []string `json:"roles"`
+	Jvm struct {
+		Mem struct {
+			HeapUsedPercent int `json:"heap_used_percent"`
+			HeapUsedInBytes int64 `json:"heap_used_in_bytes"`
+			HeapMaxInBytes int64 `json:"heap_max_in_bytes"`
+		} `json:"mem"`
+	} `json:"jvm"`
+	Os struct {
+		Cpu struct {
+			Percent int `json:"percent"`
+		} `json:"cpu"`
+	} `json:"os"`
+	Fs struct {
+		Total struct {
+			TotalInBytes int64 `json:"total_in_bytes"`
+			FreeInBytes int64 `json:"free_in_bytes"`
+			AvailableInBytes int64 `json:"available_in_bytes"`
+		} `json:"total"`
+	} `json:"fs"`
+	Indices struct {
+		Docs struct {
+			Count int64 `json:"count"`
+		} `json:"docs"`
+		Store struct {
+			SizeInBytes int64 `json:"size_in_bytes"`
+		} `json:"store"`
+	} `json:"indices"`
+}
+
+type TasksResults struct {
+	Nodes map[string]TaskNode `json:"nodes"`
+}
+
+type TaskNode struct {
+	Name string `json:"name"`
+	Tasks map[string]TaskInfo `json:"tasks"`
+}
+
+type TaskInfo struct {
+	Node string `json:"node"`
+	Id int64 `json:"id"`
+	Type string `json:"type"`
+	Action string `json:"action"`
+	Description string `json:"description"`
+	StartTimeInMillis int64 `json:"start_time_in_millis"`
+	RunningTimeInNanos int64 `json:"running_time_in_nanos"`
+	Cancellable bool `json:"cancellable"`
+	ParentTaskId string `json:"parent_task_id"`
+	Status json.RawMessage `json:"status"`
+}
+
+// TaskId returns the node:id form used to address the task
+func (self *TaskInfo) TaskId() string {
+	return fmt.Sprintf("%s:%d", self.Node, self.Id)
+}
+
+// All returns every task on every node ordered by task id
+func (self *TasksResults) All() []TaskInfo {
+	ids := []string{}
+	tasks := map[string]TaskInfo{}
+	for node := range self.Nodes {
+		for id, task := range self.Nodes[node].Tasks {
+			ids = append(ids, id)
+			tasks[id] = task
+		}
+	}
+	sort.Strings(ids)
+	all := []TaskInfo{}
+	for _, id := range ids {
+		all = append(all, tasks[id])
+	}
+	return all
+}
+
+type TaskResults struct {
+	Completed bool `json:"completed"`
+	Task TaskInfo `json:"task"`
+	Response json.RawMessage `json:"response"`
+	Error *ErrorCause `json:"error"`
+}
+
+// ByQueryResponse decodes the response of a completed update by query, delete by query or reindex task
+func (self *TaskResults) ByQueryResponse() (*UpdateByQueryResults, error) {
+	results := UpdateByQueryResults{}
+	err := json.Unmarshal(self.Response, &results)
+	return &results, err
+}
+
+// ByQueryStatus is the progress of a running update by query, delete by query or reindex task
+type ByQueryStatus struct {
+	Total int `json:"total"`
+	Updated int `json:"updated"`
+	Created int `json:"created"`
+	Deleted int `json:"deleted"`
+	Batches int `json:"batches"`
+	VersionConflicts int `json:"version_conflicts"`
+	Noops int `json:"noops"`
+	Retries Retries `json:"retries"`
+	ThrottledMillis int `json:"throttled_millis"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	ThrottledUntilMillis int `json:"throttled_until_millis"`
+}
+
+type StoredScriptResults struct {
+	Id string `json:"_id"`
+	Found bool `json:"found"`
+	Script StoredScript `json:"script"`
+}
+
+type RenderTemplateResults struct {
+	TemplateOutput Object `json:"template_output"`
+}
+
+type IsmPolicyResults struct {
+	Id string `json:"_id"`
+	Version int `json:"_version"`
+	SequenceNumber int `json:"_seq_no"`
+	PrimaryTerm int `json:"_primary_term"`
+	Policy IsmPolicy `json:"policy"`
+}
+
+// IsmPolicy is an opensearch index state management policy, the opensearch equivalent of an ILM policy
+type IsmPolicy struct {
+	Description string `json:"description,omitempty"`
+	DefaultState string `json:"default_state"`
+	States []IsmState `json:"states"`
+	LastUpdatedTime int64 `json:"last_updated_time,omitempty"`
+}
+
+type IsmState struct {
+	Name string `json:"name"`
+	Actions []Object `json:"actions"`
+	Transitions []IsmTransition `json:"transitions"`
+}
+
+type IsmTransition struct {
+	StateName string `json:"state_name"`
+	Conditions Object `json:"conditions,omitempty"`
+}
+
+type IsmExplanation struct {
+	Index string `json:"index"`
+	PolicyId string `json:"policy_id"`
+	State struct {
+		Name string `json:"name"`
+	} `json:"state"`
+	Action struct {
+		Name string `json:"name"`
+		Failed bool `json:"failed"`
+	} `json:"action"`
+	Step struct {
+		Name string `json:"name"`
+		StepStatus string `json:"step_status"`
+	} `json:"step"`
+	Info Object `json:"info"`
+}
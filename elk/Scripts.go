@@ -0,0 +1,108 @@
+package elk
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"fmt"
+	"strings"
+)
+
+// StoredScript is a painless script or mustache search template stored in the cluster state
+type StoredScript struct {
+	Lang string `json:"lang"`
+	Source interface{} `json:"source"`
+}
+
+// PutScript stores a painless script under id so it can be invoked by id without being recompiled
+func (self *Client) PutScript(id string, source string) (*AcknowledgedResults, error) {
+	return self.putStoredScript(id, &StoredScript{Lang: "painless", Source: source})
+}
+
+// PutSearchTemplate stores a mustache search template under id. The source may be a template string or a query object.
+func (self *Client) PutSearchTemplate(id string, source interface{}) (*AcknowledgedResults, error) {
+	return self.putStoredScript(id, &StoredScript{Lang: "mustache", Source: source})
+}
+
+// GetScript returns the stored script or search template with id. An ErrNotFound error is returned if it does not exist.
+func (self *Client) GetScript(id string) (*StoredScript, error) {
+	results := StoredScriptResults{}
+	err := self.Request("GET", fmt.Sprintf("/_scripts/%s", id), nil, &results)
+	return &results.Script, err
+}
+
+// DeleteScript deletes the stored script or search template with id
+func (self *Client) DeleteScript(id string) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	err := self.Request("DELETE", fmt.Sprintf("/_scripts/%s", id), nil, &results)
+	return &results, err
+}
+
+// RenderTemplate returns the query produced by the stored search template with id and params
+func (self *Client) RenderTemplate(id string, params Object) (Object, error) {
+	results := RenderTemplateResults{}
+	err := self.Request("POST", "/_render/template", Object{"id": id, "params": params}, &results)
+	return results.TemplateOutput, err
+}
+
+// RenderInlineTemplate returns the query produced by an unstored search template source and params
+func (self *Client) RenderInlineTemplate(source interface{}, params Object) (Object, error) {
+	results := RenderTemplateResults{}
+	err := self.Request("POST", "/_render/template", Object{"source": source, "params": params}, &results)
+	return results.TemplateOutput, err
+}
+
+// putStoredScript stores a script or search template under id
+func (self *Client) putStoredScript(id string, script *StoredScript) (*AcknowledgedResults, error) {
+	results := AcknowledgedResults{}
+	err := self.Request("PUT", fmt.Sprintf("/_scripts/%s", id), Object{"script": script}, &results)
+	return &results, err
+}
+
+// storeUpdateScripts stores the scripts of updates that are invoked by id. Scripts already stored by this client are skipped.
+func (self *Client) storeUpdateScripts(updates BulkUpdate) error {
+	self.stored_scripts_lock.Lock()
+	defer self.stored_scripts_lock.Unlock()
+	if self.stored_scripts == nil {
+		self.stored_scripts = map[string]bool{}
+	}
+	for path := range updates {
+		if !updates[path].stored || updates[path].doc != nil || updates[path].script.Len() == 0 {
+			continue
+		}
+		id := updates[path].ScriptId()
+		if self.stored_scripts[id] {
+			continue
+		}
+		if _, err := self.PutScript(id, updates[path].Script()); err != nil {
+			return err
+		}
+		self.stored_scripts[id] = true
+	}
+	return nil
+}
+
+// DeleteUpdateScripts deletes the scripts stored by Push for updates with UseStoredScript. Stored scripts stay in the cluster state until deleted.
+// Scripts that were already deleted are skipped.
+func (self *Client) DeleteUpdateScripts() error {
+	self.stored_scripts_lock.Lock()
+	defer self.stored_scripts_lock.Unlock()
+	for id := range self.stored_scripts {
+		if _, err := self.DeleteScript(id); err != nil && !IsNotFound(err) {
+			return err
+		}
+		delete(self.stored_scripts, id)
+	}
+	return nil
+}
+
+// forgetUpdateScript makes the next Push store the script with id again
+func (self *Client) forgetUpdateScript(id string) {
+	self.stored_scripts_lock.Lock()
+	defer self.stored_scripts_lock.Unlock()
+	delete(self.stored_scripts, id)
+}
+
+// missingScript returns true if a bulk item failed because its stored script does not exist
+func missingScript(cause *ErrorCause) bool {
+	return cause != nil && cause.Type == "resource_not_found_exception" && strings.Contains(cause.Reason, "unable to find script")
+}
@@ -0,0 +1,47 @@
+package elk_test
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk"
+	"github.com/KarmaPenny/golib/elk/elktest"
+
+	"testing"
+)
+
+func storedUpdate(value string) *elk.Update {
+	update := elk.NewUpdate("/jobs/_doc/1")
+	update.SetField("status", value)
+	update.UseStoredScript()
+	return update
+}
+
+func TestStoredUpdateScripts(t *testing.T) {
+	server := elktest.NewServer()
+	defer server.Close()
+	server.Put("jobs", "1", Object{"status": "new"})
+	client := server.NewClient()
+
+	if results, err := client.Push(elk.BulkUpdate{"/jobs/_doc/1": storedUpdate("seen")}); err != nil || results.Errors {
+		t.Fatalf("push failed: %v %+v", err, results)
+	}
+
+	// a script deleted behind the client's back is stored again
+	id := storedUpdate("").ScriptId()
+	if _, err := client.DeleteScript(id); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := client.Push(elk.BulkUpdate{"/jobs/_doc/1": storedUpdate("done")}); err != nil || results.Errors {
+		t.Fatalf("push after the script was deleted failed: %v %+v", err, results)
+	}
+	if source, _ := server.Get("jobs", "1"); source["status"] != "done" {
+		t.Fatalf("expected status done, got %v", source["status"])
+	}
+
+	// cleanup removes the scripts from the cluster state
+	if err := client.DeleteUpdateScripts(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetScript(id); !elk.IsNotFound(err) {
+		t.Fatalf("expected the stored script to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package elk
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultPageSize is the number of documents fetched per request when the query does not set a size
+const DefaultPageSize = 1000
+
+// DefaultKeepAlive is how long the point in time or scroll context is kept alive between pages
+const DefaultKeepAlive = "1m"
+
+// SearchIterator lazily iterates over every document matching a query
+// Pages are fetched using a point in time and search_after with a tiebreaker appended to the sort. Clusters that do not support
+// point in time fall back to the scroll api. Both the elasticsearch and opensearch point in time apis are supported.
+type SearchIterator struct {
+	client *Client
+	ctx context.Context
+	index string
+	query Object
+	page_size int
+	keep_alive string
+
+	opensearch bool
+	started bool
+	done bool
+	closed bool
+	err error
+	pit_id string
+	scroll_id string
+	search_after Array
+	page []Document
+	page_index int
+	document *Document
+}
+
+// SearchIter returns an iterator over all documents in index that match query
+func (self *Client) SearchIter(ctx context.Context, index string, query interface{}) *SearchIterator {
+	iterator := &SearchIterator{
+		client: self,
+		ctx: ctx,
+		index: index,
+		keep_alive: DefaultKeepAlive,
+	}
+
+	// copy the query so that it can be modified for each page
+	iterator.query = Object{}
+	if query != nil {
+		data, err := json.Marshal(query)
+		if err != nil {
+			iterator.err = err
+			iterator.done = true
+			return iterator
+		}
+		if err = json.Unmarshal(data, &iterator.query); err != nil {
+			iterator.err = err
+			iterator.done = true
+			return iterator
+		}
+	}
+
+	// the size of the query determines the page size
+	iterator.page_size = DefaultPageSize
+	if size, ok := iterator.query["size"].(float64); ok && size > 0 {
+		iterator.page_size = int(size)
+	}
+	iterator.query["size"] = iterator.page_size
+	return iterator
+}
+
+// Next advances to the next document, returns false when there are no more documents or an error occurred
+func (self *SearchIterator) Next() bool {
+	for self.page_index >= len(self.page) {
+		if self.done || self.err != nil {
+			self.Close()
+			return false
+		}
+		self.fetch()
+	}
+	self.document = &self.page[self.page_index]
+	self.page_index++
+	return true
+}
+
+// Document returns the current document
+func (self *SearchIterator) Document() *Document {
+	return self.document
+}
+
+// Err returns the error that stopped the iteration, if any
+func (self *SearchIterator) Err() error {
+	return self.err
+}
+
+// Close releases the point in time or scroll context held by the iterator
+func (self *SearchIterator) Close() error {
+	if self.closed {
+		return nil
+	}
+	self.closed = true
+	self.done = true
+
+	// use a fresh context so resources are released even if ctx was canceled
+	ctx := context.Background()
+	results := Object{}
+	if self.pit_id != "" && self.opensearch {
+		return self.client.RequestWithContext(ctx, "DELETE", "/_search/point_in_time", Object{"pit_id": Array{self.pit_id}}, &results)
+	}
+	if self.pit_id != "" {
+		return self.client.RequestWithContext(ctx, "DELETE", "/_pit", Object{"id": self.pit_id}, &results)
+	}
+	if self.scroll_id != "" {
+		return self.client.RequestWithContext(ctx, "DELETE", "/_search/scroll", Object{"scroll_id": Array{self.scroll_id}}, &results)
+	}
+	return nil
+}
+
+// fetch loads the next page of documents
+func (self *SearchIterator) fetch() {
+	var results *SearchResults
+	if !self.started {
+		self.started = true
+		results, self.err = self.first()
+	} else if self.pit_id != "" {
+		results, self.err = self.nextPit()
+	} else {
+		results, self.err = self.nextScroll()
+	}
+	if self.err != nil {
+		return
+	}
+	if results.TimedOut {
+		took := time.Duration(results.Took) * time.Millisecond
+		self.err = errors.New(fmt.Sprintf("Search timed out after %s", took))
+		return
+	}
+
+	// point in time and scroll ids may change between requests
+	if results.PitId != "" {
+		self.pit_id = results.PitId
+	}
+	if results.ScrollId != "" {
+		self.scroll_id = results.ScrollId
+	}
+
+	self.page = results.Hits.Hits
+	self.page_index = 0
+	if len(self.page) < self.page_size {
+		self.done = true
+	}
+	if len(self.page) > 0 {
+		self.search_after = self.page[len(self.page)-1].Sort
+	}
+
+	// without sort values search_after would fetch the same page forever
+	if self.pit_id != "" && !self.done && len(self.search_after) == 0 {
+		self.err = errors.New("Search after point in time requires sort values but the last hit of the page has none")
+	}
+}
+
+// first opens a point in time and fetches the first page, falling back to scroll on older clusters
+func (self *SearchIterator) first() (*SearchResults, error) {
+	info, err := self.client.Info()
+	if err != nil {
+		return nil, err
+	}
+	self.opensearch = info.Version.IsOpenSearch()
+
+	// open a point in time if the cluster supports it
+	if info.Version.SupportsPit() {
+		pit := PitResults{}
+		url := fmt.Sprintf("/%s/_pit?keep_alive=%s", self.index, self.keep_alive)
+		if self.opensearch {
+			url = fmt.Sprintf("/%s/_search/point_in_time?keep_alive=%s", self.index, self.keep_alive)
+		}
+		err = self.client.RequestWithContext(self.ctx, "POST", url, nil, &pit)
+		if err == nil {
+			self.pit_id = pit.Id
+			if pit.PitId != "" {
+				self.pit_id = pit.PitId
+			}
+			return self.nextPit()
+		}
+
+		// fall back to scroll only if the point in time endpoint does not exist, any other error is returned as is
+		if !pitUnsupported(err) {
+			return nil, err
+		}
+		loggerOrNop(self.client.Logger).Warn("point in time not supported, falling back to scroll", "index", self.index, "error", err)
+	}
+
+	// point in time is not supported so use scroll instead
+	results := SearchResults{}
+	url := fmt.Sprintf("/%s/_search?scroll=%s", self.index, self.keep_alive)
+	err = self.client.RequestWithContext(self.ctx, "POST", url, self.query, &results)
+	return &results, err
+}
+
+// nextPit fetches the page after the last document using the point in time
+func (self *SearchIterator) nextPit() (*SearchResults, error) {
+	query := Object{}
+	for key := range self.query {
+		query[key] = self.query[key]
+	}
+	query["pit"] = Object{"id": self.pit_id, "keep_alive": self.keep_alive}
+	if self.opensearch {
+		query["sort"] = withTiebreaker(query["sort"], "_id")
+	} else {
+		query["sort"] = withTiebreaker(query["sort"], "_shard_doc")
+	}
+	if len(self.search_after) > 0 {
+		query["search_after"] = self.search_after
+	}
+	results := SearchResults{}
+	err := self.client.RequestWithContext(self.ctx, "POST", "/_search", query, &results)
+	return &results, err
+}
+
+// nextScroll fetches the next page of the scroll
+func (self *SearchIterator) nextScroll() (*SearchResults, error) {
+	results := SearchResults{}
+	body := Object{"scroll": self.keep_alive, "scroll_id": self.scroll_id}
+	err := self.client.RequestWithContext(self.ctx, "POST", "/_search/scroll", body, &results)
+	return &results, err
+}
+
+// hasSort returns true if sort is a non empty sort, a missing or null sort or an empty array is treated as no sort
+func hasSort(sort interface{}) bool {
+	switch typed := sort.(type) {
+		case nil:
+			return false
+		case []interface{}:
+			return len(typed) > 0
+		case string:
+			return typed != ""
+		case map[string]interface{}:
+			return len(typed) > 0
+	}
+	return true
+}
+
+// withTiebreaker returns sort with a unique tiebreaker field appended, without it search_after skips or repeats
+// documents that have the same sort values at the end of a page
+func withTiebreaker(sort interface{}, tiebreaker string) Array {
+	sorts := Array{}
+	if typed, ok := sort.([]interface{}); ok {
+		sorts = append(sorts, typed...)
+	} else if hasSort(sort) {
+		sorts = append(sorts, sort)
+	}
+	for _, field := range sorts {
+		switch typed := field.(type) {
+			case string:
+				if typed == tiebreaker {
+					return sorts
+				}
+			case map[string]interface{}:
+				if _, ok := typed[tiebreaker]; ok {
+					return sorts
+				}
+		}
+	}
+	return append(sorts, Object{tiebreaker: "asc"})
+}
+
+// pitUnsupported returns true if err is the response of a cluster that has no point in time endpoint
+func pitUnsupported(err error) bool {
+	response_error := &ResponseError{}
+	if !errors.As(err, &response_error) {
+		return false
+	}
+	switch response_error.StatusCode {
+		case 405, 501:
+			return true
+		case 400:
+			return strings.Contains(string(response_error.Body), "no handler found")
+	}
+	return false
+}
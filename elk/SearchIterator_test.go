@@ -0,0 +1,115 @@
+package elk_test
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk"
+	"github.com/KarmaPenny/golib/elk/elktest"
+
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestSearchIterNilSort(t *testing.T) {
+	for _, distribution := range []string{"", elk.DISTRIBUTION_OPENSEARCH} {
+		server := elktest.NewServer()
+		if distribution != "" {
+			server.Distribution = distribution
+			server.Version = "2.11.0"
+		}
+		for i := 0; i < 250; i++ {
+			server.Put("jobs", strconv.Itoa(i), Object{"n": i})
+		}
+
+		// a nil sort must get the default tiebreaker sort or search_after never advances
+		var order Array
+		iterator := server.NewClient().SearchIter(context.Background(), "jobs", Object{"size": 100, "sort": order})
+		seen := map[string]bool{}
+		for count := 0; iterator.Next(); count++ {
+			if count == 250 {
+				t.Fatalf("%s: iterator returned more documents than exist", server.Version)
+			}
+			seen[iterator.Document().Id] = true
+		}
+		if err := iterator.Err(); err != nil {
+			t.Fatalf("%s: %s", server.Version, err)
+		}
+		if len(seen) != 250 {
+			t.Fatalf("%s: expected 250 documents, got %d", server.Version, len(seen))
+		}
+		server.Close()
+	}
+}
+
+func TestSearchIterMissingIndex(t *testing.T) {
+	server := elktest.NewServer()
+	defer server.Close()
+
+	// a missing index is an error, not a reason to fall back to scroll
+	iterator := server.NewClient().SearchIter(context.Background(), "missing", nil)
+	if iterator.Next() {
+		t.Fatal("expected no documents")
+	}
+	if !elk.IsNotFound(iterator.Err()) {
+		t.Fatalf("expected not found error, got %v", iterator.Err())
+	}
+}
+
+// TestSearchIterTies pages through documents that tie on the sort value across page boundaries
+func TestSearchIterTies(t *testing.T) {
+	for _, distribution := range []string{"", elk.DISTRIBUTION_OPENSEARCH} {
+		server := elktest.NewServer()
+		if distribution != "" {
+			server.Distribution = distribution
+			server.Version = "2.11.0"
+		}
+		for i := 0; i < 25; i++ {
+			server.Put("jobs", strconv.Itoa(i), Object{"n": i / 5})
+		}
+		iterator := server.NewClient().SearchIter(context.Background(), "jobs", Object{"size": 4, "sort": Array{Object{"n": "asc"}}})
+		seen := map[string]bool{}
+		last := 0.0
+		for iterator.Next() {
+			document := iterator.Document()
+			if seen[document.Id] {
+				t.Fatalf("%s: document %s returned twice", server.Version, document.Id)
+			}
+			seen[document.Id] = true
+			if n, _ := document.Source["n"].(float64); n < last {
+				t.Fatalf("%s: document %s is out of order", server.Version, document.Id)
+			} else {
+				last = n
+			}
+		}
+		if err := iterator.Err(); err != nil {
+			t.Fatalf("%s: %s", server.Version, err)
+		}
+		if len(seen) != 25 {
+			t.Fatalf("%s: expected 25 documents, got %d", server.Version, len(seen))
+		}
+		server.Close()
+	}
+}
+
+// TestSearchIterBeforeShardDoc uses scroll on elasticsearch versions that have point in time but not _shard_doc
+func TestSearchIterBeforeShardDoc(t *testing.T) {
+	for _, version := range []string{"7.10.2", "7.11.2", "7.12.0"} {
+		server := elktest.NewServer()
+		server.Version = version
+		for i := 0; i < 15; i++ {
+			server.Put("jobs", strconv.Itoa(i), Object{"n": i})
+		}
+		iterator := server.NewClient().SearchIter(context.Background(), "jobs", Object{"size": 10})
+		count := 0
+		for iterator.Next() {
+			count++
+		}
+		if err := iterator.Err(); err != nil {
+			t.Fatalf("%s: %s", version, err)
+		}
+		if count != 15 {
+			t.Fatalf("%s: expected 15 documents, got %d", version, count)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,61 @@
+package elk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// SlogHook logs every request to a slog.Logger
+// Level field is the level of successful requests, failed requests are logged at error level
+// Bodies field adds the request and response bodies, passed through Redact if set. Without it errors are logged without the response body.
+type SlogHook struct {
+	Logger *slog.Logger
+	Level slog.Level
+	Bodies bool
+	Redact func([]byte) []byte
+}
+
+func (self *SlogHook) BeforeRequest(ctx context.Context, request *http.Request) context.Context {
+	return ctx
+}
+
+func (self *SlogHook) AfterRequest(ctx context.Context, info *RequestInfo) {
+	level := self.Level
+	attributes := []slog.Attr{
+		slog.String("method", info.Method),
+		slog.String("url", info.Url),
+		slog.Int("status", info.StatusCode),
+		slog.Duration("duration", info.Duration),
+		slog.Int("request_size", info.RequestSize),
+		slog.Int("response_size", info.ResponseSize),
+	}
+	if info.Err != nil {
+		level = slog.LevelError
+		attributes = append(attributes, slog.String("error", self.errorText(info.Err)))
+	}
+	if self.Bodies {
+		attributes = append(attributes, slog.String("request_body", self.redact(info.RequestBody)))
+		attributes = append(attributes, slog.String("response_body", self.redact(info.ResponseBody)))
+	}
+	self.Logger.LogAttrs(ctx, level, "elasticsearch request", attributes...)
+}
+
+// redact applies the Redact function to body if set
+func (self *SlogHook) redact(body []byte) string {
+	if self.Redact != nil && len(body) > 0 {
+		return string(self.Redact(body))
+	}
+	return string(body)
+}
+
+// errorText describes err, including the redacted response body only if bodies are logged
+func (self *SlogHook) errorText(err error) string {
+	response_error := &ResponseError{}
+	if !self.Bodies || !errors.As(err, &response_error) {
+		return errorSummary(err)
+	}
+	return fmt.Sprintf("StatusCode (%d): %s", response_error.StatusCode, self.redact(response_error.Body))
+}
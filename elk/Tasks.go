@@ -0,0 +1,101 @@
+package elk
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// TaskPollInterval is how often WaitForTask checks whether a task has completed
+const TaskPollInterval = time.Second
+
+// Task is a handle to a long running operation started with wait_for_completion=false
+type Task struct {
+	Id string `json:"task"`
+	client *Client
+}
+
+// Wait blocks until the task completes, calling progress with the task status after every poll
+func (self *Task) Wait(ctx context.Context, progress func(*ByQueryStatus)) (*TaskResults, error) {
+	return self.client.WaitForTask(ctx, self.Id, progress)
+}
+
+// Cancel asks elasticsearch to cancel the task
+func (self *Task) Cancel() error {
+	return self.client.CancelTask(self.Id)
+}
+
+// DeleteResult deletes the result of the completed task from the .tasks index
+func (self *Task) DeleteResult() error {
+	return self.client.DeleteTaskResult(self.Id)
+}
+
+// GetTask returns the status of a task and its response once completed
+func (self *Client) GetTask(ctx context.Context, id string) (*TaskResults, error) {
+	results := TaskResults{}
+	err := self.RequestWithContext(ctx, "GET", fmt.Sprintf("/_tasks/%s", id), nil, &results)
+	return &results, err
+}
+
+// CancelTask cancels a running task
+func (self *Client) CancelTask(id string) error {
+	results := Object{}
+	return self.Request("POST", fmt.Sprintf("/_tasks/%s/_cancel", id), nil, &results)
+}
+
+// DeleteTaskResult deletes the result that a task started with wait_for_completion=false stores in the .tasks index when it completes
+// Elasticsearch never deletes these results itself. A result that does not exist is not an error.
+func (self *Client) DeleteTaskResult(id string) error {
+	typeless, err := self.Typeless()
+	if err != nil {
+		return err
+	}
+	doc_type := "task"
+	if typeless {
+		doc_type = "_doc"
+	}
+	results := Object{}
+	err = self.Request("DELETE", fmt.Sprintf("/.tasks/%s/%s", doc_type, url.PathEscape(id)), nil, &results)
+	if IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// WaitForTask polls a task until it completes or ctx is done. The optional progress function is called with the status after every poll.
+func (self *Client) WaitForTask(ctx context.Context, id string, progress func(*ByQueryStatus)) (*TaskResults, error) {
+	for {
+		results, err := self.GetTask(ctx, id)
+		if err != nil {
+			return results, err
+		}
+
+		// report progress
+		if progress != nil {
+			status := ByQueryStatus{}
+			if len(results.Task.Status) > 0 && json.Unmarshal(results.Task.Status, &status) == nil {
+				progress(&status)
+			}
+		}
+
+		// return the result once complete
+		if results.Completed {
+			if results.Error != nil {
+				return results, errors.New(fmt.Sprintf("Task %s failed: %s: %s", id, results.Error.Type, results.Error.Reason))
+			}
+			return results, nil
+		}
+
+		// wait before polling again
+		select {
+			case <-ctx.Done():
+				return results, ctx.Err()
+			case <-time.After(TaskPollInterval):
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package elk_test
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk"
+	"github.com/KarmaPenny/golib/elk/elktest"
+
+	"context"
+	"testing"
+)
+
+func TestTaskResultDeleted(t *testing.T) {
+	server := elktest.NewServer()
+	defer server.Close()
+	server.Put("jobs", "1", Object{"status": "new"})
+	client := server.NewClient()
+
+	request := Object{"script": Object{"source": "ctx._source.status = 'seen'", "lang": "painless"}}
+	task, err := client.UpdateByQueryAsync("jobs", request, &elk.ByQueryOptions{Slices: "auto"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := task.Wait(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response, err := results.ByQueryResponse(); err != nil || response.Updated != 1 {
+		t.Fatalf("expected 1 updated document, got %+v %v", response, err)
+	}
+	if err = task.DeleteResult(); err != nil {
+		t.Fatal(err)
+	}
+	if stored := server.TaskResults(); len(stored) != 0 {
+		t.Fatalf("expected task results to be deleted, found %v", stored)
+	}
+
+	// deleting a result twice is not an error
+	if err = task.DeleteResult(); err != nil {
+		t.Fatal(err)
+	}
+}
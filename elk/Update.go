@@ -2,6 +2,10 @@ package elk
 
 import (
 	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk/painless"
+
+	"crypto/sha1"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -13,19 +17,190 @@ type Update struct {
 	path string
 	script strings.Builder
 	params Object
+	stored bool
+	conditional bool
+	seq_no int
+	primary_term int
+	variables int
+	err error
+	doc Object
+	doc_as_upsert bool
+	upsert Object
+	scripted_upsert bool
 }
 
+// NewUpdate returns an update of the document identified by path, either /index/type/id or the typeless /index/id
+// An invalid path is reported by Err.
 func NewUpdate(path string) *Update {
-	return &Update{path: path}
+	update := &Update{path: path}
+	if _, _, _, err := SplitPath(path); err != nil {
+		update.fail(err)
+	}
+	return update
 }
 
+// Action returns the bulk action of the update. Use TypelessAction to remove the _type for elasticsearch 7 and later.
 func (self *Update) Action() Object {
-	ids := strings.Split(self.path, "/")
-	return Object{"update": Object{"_index": ids[1], "_type": ids[2], "_id": ids[3]}}
+	// invalid paths are reported by Err
+	index, doc_type, id, _ := SplitPath(self.path)
+	action := Object{"update": Object{"_index": index, "_type": typeOrDefault(doc_type), "_id": id}}
+	if self.conditional {
+		action = ConditionalAction(action, self.seq_no, self.primary_term)
+	}
+	return action
+}
+
+// IfSequenceNumber makes the update fail with a version conflict if the document has changed since the given sequence number and primary term
+func (self *Update) IfSequenceNumber(seq_no int, primary_term int) {
+	self.conditional = true
+	self.seq_no = seq_no
+	self.primary_term = primary_term
+}
+
+// TypelessAction removes the _type from a bulk action for elasticsearch 7 and later
+func TypelessAction(action Object) Object {
+	for operation := range action {
+		if metadata, ok := action[operation].(Object); ok {
+			delete(metadata, "_type")
+		}
+	}
+	return action
+}
+
+// ConditionalAction adds if_seq_no and if_primary_term to a bulk action so it only applies if the document has not changed
+func ConditionalAction(action Object, seq_no int, primary_term int) Object {
+	for operation := range action {
+		if metadata, ok := action[operation].(Object); ok {
+			metadata["if_seq_no"] = seq_no
+			metadata["if_primary_term"] = primary_term
+		}
+	}
+	return action
 }
 
+// Source returns the bulk source of the update: the partial document if MergeDocument was used, otherwise the script, along with any upsert options
+// Updates without operations send an empty partial document instead of an empty script, or a script that changes nothing if UseScriptedUpsert is set.
 func (self *Update) Source() Object {
-	return Object{"script": Object{"source": self.script.String(), "lang": "painless", "params": self.params}}
+	source := Object{}
+	if self.doc != nil {
+		source["doc"] = self.doc
+		if self.doc_as_upsert {
+			source["doc_as_upsert"] = true
+		}
+	} else if self.script.Len() == 0 && self.scripted_upsert {
+		// a scripted upsert needs a script to run, this one leaves the document as it is
+		source["script"] = Object{"source": "ctx.op = ctx.op;", "lang": "painless"}
+	} else if self.script.Len() == 0 {
+		// elasticsearch requires a script or a partial document, an empty partial document leaves existing documents unchanged
+		source["doc"] = Object{}
+	} else if self.stored {
+		source["script"] = Object{"id": self.ScriptId(), "params": self.params}
+	} else {
+		source["script"] = Object{"source": self.script.String(), "lang": "painless", "params": self.params}
+	}
+	if self.upsert != nil {
+		source["upsert"] = self.upsert
+	}
+	if self.scripted_upsert {
+		source["scripted_upsert"] = true
+		if self.upsert == nil {
+			source["upsert"] = Object{}
+		}
+	}
+	return source
+}
+
+// MergeDocument makes the update merge a partial document into the existing source instead of running a script
+// Objects are merged recursively and all other values are replaced. Calling it again merges the new fields into the partial document.
+// A partial document can not be combined with script operations such as SetField.
+func (self *Update) MergeDocument(document Object) {
+	if self.doc == nil {
+		self.doc = Object{}
+	}
+	mergeObjects(self.doc, document)
+}
+
+// UseDocAsUpsert makes the update create missing documents from the partial document given to MergeDocument
+func (self *Update) UseDocAsUpsert() {
+	self.doc_as_upsert = true
+}
+
+// SetUpsert sets the document that is created if the document does not exist. The script only runs on documents that already exist unless UseScriptedUpsert is set.
+func (self *Update) SetUpsert(document Object) {
+	self.upsert = document
+}
+
+// UseScriptedUpsert makes the script also run when the document does not exist, starting from the upsert document or an empty document if no upsert is set
+func (self *Update) UseScriptedUpsert() {
+	self.scripted_upsert = true
+}
+
+// Script returns the painless source of the update
+func (self *Update) Script() string {
+	return self.script.String()
+}
+
+// ApplyTo applies the update to a local document source with the same semantics as running the update script or merging the partial document in elasticsearch
+// The document is left unchanged if the update fails.
+func (self *Update) ApplyTo(document Object) error {
+	if err := self.Err(); err != nil {
+		return err
+	}
+	if self.doc != nil {
+		doc, err := copyObject(self.doc)
+		if err == nil {
+			mergeObjects(document, doc)
+		}
+		return err
+	}
+	_, err := painless.Update(document, self.script.String(), self.params)
+	return err
+}
+
+// ApplyToMissing returns the document the update creates if the document does not exist. False is returned if the update would fail instead.
+func (self *Update) ApplyToMissing() (Object, bool, error) {
+	if err := self.Err(); err != nil {
+		return nil, false, err
+	}
+	switch {
+		case self.doc != nil && self.doc_as_upsert:
+			document, err := copyObject(self.doc)
+			return document, err == nil, err
+		case self.doc == nil && self.scripted_upsert:
+			document, err := copyObject(self.upsert)
+			if err == nil {
+				_, err = painless.Update(document, self.script.String(), self.params)
+			}
+			return document, err == nil, err
+		case self.upsert != nil:
+			document, err := copyObject(self.upsert)
+			return document, err == nil, err
+	}
+	return nil, false, nil
+}
+
+// Err returns the first error from an invalid path or an operation with invalid arguments, such as an empty field name, or an error if script operations are mixed with MergeDocument
+// Client.Push refuses to send updates with errors.
+func (self *Update) Err() error {
+	if self.err == nil && self.doc != nil && self.script.Len() > 0 {
+		return errors.New("Update can not both merge a document and run a script")
+	}
+	return self.err
+}
+
+// Params returns the parameters of the update script
+func (self *Update) Params() Object {
+	return self.params
+}
+
+// ScriptId returns the id the script is stored under when UseStoredScript is set. Updates with identical scripts share an id.
+func (self *Update) ScriptId() string {
+	return fmt.Sprintf("elk-update-%x", sha1.Sum([]byte(self.script.String())))
+}
+
+// UseStoredScript makes Client.Push store the script of the update and invoke it by id so it is not recompiled for every update
+func (self *Update) UseStoredScript() {
+	self.stored = true
 }
 
 // AddParameter adds a parameter to the update
@@ -38,87 +213,282 @@ func (self *Update) AddParameter(parameter interface{}) {
 
 // SetAnalysis sets the analysis of type analysis_type to analysis. If the analysis already exists it is overwritten.
 func (self *Update) SetAnalysis(analysis_type string, analysis *Analysis) {
+	if analysis_type == "" {
+		self.fail(errors.New("Analysis type must not be empty"))
+		return
+	}
+	analysis_type_param := self.parameter(analysis_type)
+	analysis_param := self.parameter(analysis)
+	index := fmt.Sprintf("index%d", self.variables)
+	self.variables++
+
 	// add script that creates or overwrites the analysis of type analysis_type
 	self.script.WriteString(strings.NewReplacer("\t", "", "\n", "").Replace(fmt.Sprintf(`
 		if (ctx._source.analysis == null) {
 			ctx._source.analysis = new ArrayList();
-			ctx._source.analysis.add(params.%d);
+			ctx._source.analysis.add(%s);
 		} else {
-			int index = -1;
-			for (int i = 0; i < ctx._source.analysis.length; ++i) {
-				if (ctx._source.analysis.type == '%s') {
-					index = i;
+			int %s = -1;
+			for (int i = 0; i < ctx._source.analysis.size(); ++i) {
+				if (ctx._source.analysis[i].type == %s) {
+					%s = i;
 				}
 			}
-			if (index == -1) {
-				ctx._source.analysis.add(params.%d);
+			if (%s == -1) {
+				ctx._source.analysis.add(%s);
 			} else {
-				ctx._source.analysis[index] = params.%d;
+				ctx._source.analysis[%s] = %s;
 			}
 		}
 		`,
-		len(self.params),
-		analysis_type,
-		len(self.params),
-		len(self.params),
+		analysis_param,
+		index,
+		analysis_type_param,
+		index,
+		index,
+		analysis_param,
+		index, analysis_param,
 	)))
-
-	// add analysis as parameter
-	self.AddParameter(analysis)
 }
 
 // CreateField sets the value of a field. If the field already exists the existing value is not replaced. To replace the existing value use SetField.
-func (self *Update) CreateField(field string, value string) {
+// Nested fields are named with dots and missing objects along the path are created.
+func (self *Update) CreateField(field string, value interface{}) {
+	target, ok := self.fieldOf(field, true)
+	if !ok {
+		return
+	}
+	value_param := self.parameter(value)
+
 	// add script that sets field value when field does not exist
 	self.script.WriteString(strings.NewReplacer("\t", "", "\n", "").Replace(fmt.Sprintf(`
-		if (ctx._source.%s == null) {
-			ctx._source.%s = params.%d;
+		if (%s == null) {
+			%s = %s;
 		}
 		`,
-		field,
-		field,
-		len(self.params),
+		target,
+		target, value_param,
 	)))
-
-	// add new parameter
-	self.AddParameter(value)
 }
 
 // SetField sets the value of a field. If the field already exists the existing value is replaced. To keep the existing value use CreateField.
-func (self *Update) SetField(field string, value string) {
+// Nested fields are named with dots and missing objects along the path are created.
+func (self *Update) SetField(field string, value interface{}) {
+	target, ok := self.fieldOf(field, true)
+	if !ok {
+		return
+	}
+	value_param := self.parameter(value)
+
 	// add script that sets field value
+	self.script.WriteString(fmt.Sprintf("%s = %s;", target, value_param))
+}
+
+// AppendField appends a value to an array field without creating duplicates. A new array containing the value is created if the field does not exist.
+func (self *Update) AppendField(field string, value interface{}) {
+	target, ok := self.fieldOf(field, true)
+	if !ok {
+		return
+	}
+	value_param := self.parameter(value)
+
+	// add script that appends a value to the array without creating duplicates
 	self.script.WriteString(strings.NewReplacer("\t", "", "\n", "").Replace(fmt.Sprintf(`
-		ctx._source.%s = params.%d;
+		if (%s == null) {
+			%s = new ArrayList();
+			%s.add(%s);
+		} else if (!%s.contains(%s)) {
+			%s.add(%s);
+		}
 		`,
-		field,
-		len(self.params),
+		target,
+		target,
+		target, value_param,
+		target, value_param,
+		target, value_param,
 	)))
+}
 
-	// add new parameter
-	self.AddParameter(value)
+// RemoveFromArray removes every occurrence of a value from an array field. Nothing happens if the field does not exist.
+func (self *Update) RemoveFromArray(field string, value interface{}) {
+	parent, name_param, ok := self.parentOf(field, false)
+	if !ok {
+		return
+	}
+	value_param := self.parameter(value)
+	target := fmt.Sprintf("%s[%s]", parent, name_param)
+
+	// add script that removes matching values from the end of the array first so indexes stay valid
+	self.script.WriteString(strings.NewReplacer("\t", "", "\n", "").Replace(fmt.Sprintf(`
+		if (%s instanceof Map && %s instanceof List) {
+			for (int i = %s.size() - 1; i >= 0; --i) {
+				if (%s[i] == %s) {
+					%s.remove(i);
+				}
+			}
+		}
+		`,
+		parent, target,
+		target,
+		target, value_param,
+		target,
+	)))
 }
 
-// AppendField appends a value to an array field without creating duplicates. A new array containing the value is created if the field does not exist.
-func (self *Update) AppendField(field string, value string) {
-	// add script that appends a value to the array without creating duplicates
+// RemoveField removes a field from the document. Nothing happens if the field does not exist.
+func (self *Update) RemoveField(field string) {
+	parent, name_param, ok := self.parentOf(field, false)
+	if !ok {
+		return
+	}
+
+	// add script that removes the field when its parent object exists
+	self.script.WriteString(fmt.Sprintf("if (%s instanceof Map) {%s.remove(%s);}", parent, parent, name_param))
+}
+
+// Increment adds delta to a numeric field. The field is set to delta if it does not exist. Use a negative delta to decrement.
+func (self *Update) Increment(field string, delta interface{}) {
+	target, ok := self.fieldOf(field, true)
+	if !ok {
+		return
+	}
+	delta_param := self.parameter(delta)
+
+	// add script that adds delta to the field value
+	self.script.WriteString(strings.NewReplacer("\t", "", "\n", "").Replace(fmt.Sprintf(`
+		if (%s == null) {
+			%s = %s;
+		} else {
+			%s += %s;
+		}
+		`,
+		target,
+		target, delta_param,
+		target, delta_param,
+	)))
+}
+
+// SetMax sets a field to value if the field does not exist or its current value is less than value
+func (self *Update) SetMax(field string, value interface{}) {
+	self.setBound(field, value, "<")
+}
+
+// SetMin sets a field to value if the field does not exist or its current value is greater than value
+func (self *Update) SetMin(field string, value interface{}) {
+	self.setBound(field, value, ">")
+}
+
+// setBound sets a field to value if the field does not exist or the current value compared to value with operator is true
+func (self *Update) setBound(field string, value interface{}, operator string) {
+	target, ok := self.fieldOf(field, true)
+	if !ok {
+		return
+	}
+	value_param := self.parameter(value)
+
+	// add script that replaces the field value when it is past the bound
 	self.script.WriteString(strings.NewReplacer("\t", "", "\n", "").Replace(fmt.Sprintf(`
-		if (ctx._source.%s == null) {
-			ctx._source.%s = new ArrayList();
-			ctx._source.%s.add(params.%d);
-		} else if (!ctx._source.%s.contains(params.%d)) {
-			ctx._source.%s.add(params.%d);
+		if (%s == null || %s %s %s) {
+			%s = %s;
 		}
 		`,
-		field,
-		field,
-		field,
-		len(self.params),
-		field,
-		len(self.params),
-		field,
-		len(self.params),
+		target, target, operator, value_param,
+		target, value_param,
 	)))
+}
+
+// UpdateIf applies the operations added by updates only when the value of field equals the given value
+// A missing field equals nil. Operations are added to this update inside a conditional block and can be nested. A nil updates is reported by Err.
+func (self *Update) UpdateIf(field string, equals interface{}, updates func(*Update)) {
+	if updates == nil {
+		self.fail(errors.New(fmt.Sprintf("UpdateIf of %q has no updates", field)))
+		return
+	}
+	parent, name_param, ok := self.parentOf(field, false)
+	if !ok {
+		return
+	}
+	value := fmt.Sprintf("%s[%s]", parent, name_param)
+	if parent != "ctx._source" {
+		value = fmt.Sprintf("(%s instanceof Map ? %s[%s] : null)", parent, parent, name_param)
+	}
+
+	// add script that opens the conditional block
+	self.script.WriteString(fmt.Sprintf("if (%s == %s) {", value, self.parameter(equals)))
+
+	// add the conditional operations and close the block
+	updates(self)
+	self.script.WriteString("}")
+}
+
+// fieldOf adds script that finds the object containing a field and returns the painless expression of the field
+func (self *Update) fieldOf(field string, create bool) (string, bool) {
+	parent, name_param, ok := self.parentOf(field, create)
+	return fmt.Sprintf("%s[%s]", parent, name_param), ok
+}
+
+// parentOf adds script that finds the object containing a field and returns the painless expression of that object and the parameter holding the name of the field in it
+// Nested fields are named with dots. Missing objects along the path are created if create is true, otherwise the expression is null when the path does not exist.
+// Field names are only passed to the script as parameters so they can not change the meaning of the script. False is returned if the field name is invalid.
+func (self *Update) parentOf(field string, create bool) (string, string, bool) {
+	names, err := SplitField(field)
+	if err != nil {
+		self.fail(err)
+		return "", "", false
+	}
+	if len(names) == 1 {
+		return "ctx._source", self.parameter(names[0]), true
+	}
 
-	// add new parameter
+	// walk the path with a variable that is unique within the script
+	parent := fmt.Sprintf("parent%d", self.variables)
+	self.variables++
+	self.script.WriteString(fmt.Sprintf("def %s = ctx._source;", parent))
+	for _, name := range names[:len(names)-1] {
+		name_param := self.parameter(name)
+		if create {
+			self.script.WriteString(fmt.Sprintf("if (%s[%s] == null) {%s[%s] = new HashMap();}%s = %s[%s];", parent, name_param, parent, name_param, parent, parent, name_param))
+		} else {
+			self.script.WriteString(fmt.Sprintf("%s = %s instanceof Map ? %s[%s] : null;", parent, parent, parent, name_param))
+		}
+	}
+	return parent, self.parameter(names[len(names)-1]), true
+}
+
+// SplitField splits a field name on dots into the names of the nested fields. An error is returned if the field or any of the names are empty.
+func SplitField(field string) ([]string, error) {
+	names := strings.Split(field, ".")
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			return names, errors.New(fmt.Sprintf("Invalid field name %q", field))
+		}
+	}
+	return names, nil
+}
+
+// parameter adds a parameter to the update and returns the painless expression that reads it
+func (self *Update) parameter(value interface{}) string {
+	reference := fmt.Sprintf("params.%d", len(self.params))
 	self.AddParameter(value)
+	return reference
+}
+
+// fail records the first error of the update. Operations with invalid arguments are not added to the script.
+func (self *Update) fail(err error) {
+	if self.err == nil {
+		self.err = err
+	}
+}
+
+// mergeObjects merges partial into document the way elasticsearch merges partial documents. Objects are merged recursively and all other values are replaced.
+func mergeObjects(document map[string]interface{}, partial map[string]interface{}) {
+	for field := range partial {
+		partial_object, partial_is_object := asMap(partial[field])
+		document_object, document_is_object := asMap(document[field])
+		if partial_is_object && document_is_object {
+			mergeObjects(document_object, partial_object)
+		} else {
+			document[field] = partial[field]
+		}
+	}
 }
@@ -0,0 +1,275 @@
+package elk_test
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk"
+	"github.com/KarmaPenny/golib/elk/elktest"
+
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpdatePaths(t *testing.T) {
+	typed := elk.NewUpdate("/jobs/doc/1")
+	typeless := elk.NewUpdate("/jobs/1")
+	for _, update := range []*elk.Update{typed, typeless} {
+		if err := update.Err(); err != nil {
+			t.Fatal(err)
+		}
+		expected := Object{"update": Object{"_index": "jobs", "_type": "doc", "_id": "1"}}
+		if action := update.Action(); !reflect.DeepEqual(action, expected) {
+			t.Fatalf("expected %v, got %v", expected, action)
+		}
+	}
+	for _, path := range []string{"", "/jobs", "/jobs//1", "/jobs/doc/1/extra"} {
+		if elk.NewUpdate(path).Err() == nil {
+			t.Fatalf("expected an error for path %q", path)
+		}
+	}
+}
+
+// TestSetAnalysis runs the SetAnalysis script through the interpreter to check it adds and replaces analysis by type
+func TestSetAnalysis(t *testing.T) {
+	document := Object{}
+	for _, analysis := range []*elk.Analysis{
+		&elk.Analysis{Type: "a", Version: "1"},
+		&elk.Analysis{Type: "b", Version: "1"},
+		&elk.Analysis{Type: "a", Version: "2"},
+	} {
+		update := elk.NewUpdate("/jobs/1")
+		update.SetAnalysis(analysis.Type, analysis)
+		if err := update.ApplyTo(document); err != nil {
+			t.Fatal(err)
+		}
+	}
+	analysis, _ := document["analysis"].([]interface{})
+	if len(analysis) != 2 {
+		t.Fatalf("expected 2 analysis, got %v", document["analysis"])
+	}
+	for i, expected := range [][]string{{"a", "2"}, {"b", "1"}} {
+		fields, _ := analysis[i].(map[string]interface{})
+		if fields["type"] != expected[0] || fields["version"] != expected[1] {
+			t.Errorf("expected analysis %d to be %v, got %v", i, expected, fields)
+		}
+	}
+}
+
+// hostileNames would change the meaning of the script if they were written into it instead of passed as parameters
+var hostileNames = []string{"x'; ctx._source.pwned = 1; '", `x"]=1;ctx['pwned']=1;//`}
+
+func TestHostileNamesAreParameters(t *testing.T) {
+	server := elktest.NewServer()
+	defer server.Close()
+	client := server.NewClient()
+
+	for _, name := range hostileNames {
+		update := elk.NewUpdate("/jobs/1")
+		update.SetField(name, "value")
+		update.SetAnalysis(name, &elk.Analysis{Type: name})
+		if err := update.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		// the name only reaches the script through params
+		if strings.Contains(update.Script(), "pwned") || strings.ContainsAny(update.Script(), `'"`) {
+			t.Fatalf("name leaked into script %s", update.Script())
+		}
+		in_params := false
+		for key := range update.Params() {
+			if update.Params()[key] == name {
+				in_params = true
+			}
+		}
+		if !in_params {
+			t.Fatalf("expected %q in params %v", name, update.Params())
+		}
+
+		// applied locally and by the fake server no field other than the named ones appears
+		document := Object{}
+		if err := update.ApplyTo(document); err != nil {
+			t.Fatal(err)
+		}
+		server.Put("jobs", "1", Object{})
+		if results, err := client.Push(elk.BulkUpdate{"/jobs/1": update}); err != nil || results.Errors {
+			t.Fatalf("push failed: %v %+v", err, results)
+		}
+		pushed, _ := server.Get("jobs", "1")
+		for _, source := range []Object{document, pushed} {
+			if _, ok := source["pwned"]; ok {
+				t.Fatalf("%q added a pwned field: %v", name, source)
+			}
+			if len(source) != 2 || source["analysis"] == nil {
+				t.Fatalf("%q added unexpected fields: %v", name, source)
+			}
+			analysis, _ := source["analysis"].([]interface{})
+			if len(analysis) != 1 {
+				t.Fatalf("%q: expected one analysis, got %v", name, source["analysis"])
+			}
+		}
+	}
+}
+
+func TestUpsertWithoutOperations(t *testing.T) {
+	server := elktest.NewServer()
+	defer server.Close()
+	client := server.NewClient()
+	server.Put("jobs", "existing", Object{"status": "old"})
+
+	upsert := elk.NewUpdate("/jobs/missing")
+	upsert.SetUpsert(Object{"status": "new"})
+	if _, ok := upsert.Source()["script"]; ok {
+		t.Fatalf("expected no script for an update without operations, got %v", upsert.Source())
+	}
+	existing := elk.NewUpdate("/jobs/existing")
+	existing.SetUpsert(Object{"status": "new"})
+	scripted := elk.NewUpdate("/jobs/scripted")
+	scripted.SetUpsert(Object{"status": "new"})
+	scripted.UseScriptedUpsert()
+	script, _ := scripted.Source()["script"].(Object)
+	if script["source"] == "" {
+		t.Fatalf("expected a script for a scripted upsert, got %v", scripted.Source())
+	}
+
+	results, err := client.Push(elk.BulkUpdate{"/jobs/missing": upsert, "/jobs/existing": existing, "/jobs/scripted": scripted})
+	if err != nil || results.Errors {
+		t.Fatalf("push failed: %v %+v", err, results)
+	}
+	for id, status := range map[string]string{"missing": "new", "existing": "old", "scripted": "new"} {
+		if source, _ := server.Get("jobs", id); source["status"] != status {
+			t.Errorf("expected %s to have status %s, got %v", id, status, source)
+		}
+	}
+}
+
+// operationBase is the document every operation test starts from
+var operationBase = Object{
+	"n": 1,
+	"s": "x",
+	"tags": Array{"a", "b", "a"},
+	"nested": Object{"n": 5, "tags": Array{1, "1", 2}},
+}
+
+// normalized returns document as decoded from json so numbers compare equal whatever type they were created with
+func normalized(t *testing.T, document interface{}) Object {
+	data, err := json.Marshal(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := Object{}
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+// withBase returns a copy of operationBase with fields replaced, nil values remove the field
+func withBase(t *testing.T, fields Object) Object {
+	document := normalized(t, operationBase)
+	for field, value := range normalized(t, fields) {
+		if value == nil {
+			delete(document, field)
+		} else {
+			document[field] = value
+		}
+	}
+	return document
+}
+
+// TestOperations applies each operation locally with ApplyTo and through _update on the fake server and expects the same document
+// A nil expected document means the script fails and the document is left unchanged.
+func TestOperations(t *testing.T) {
+	server := elktest.NewServer()
+	defer server.Close()
+	client := server.NewClient()
+
+	tests := []struct {
+		name string
+		operations func(*elk.Update)
+		expected Object
+	}{
+		{"remove field", func(u *elk.Update) { u.RemoveField("s") }, withBase(t, Object{"s": nil})},
+		{"remove nested field", func(u *elk.Update) { u.RemoveField("nested.n") }, withBase(t, Object{"nested": Object{"tags": Array{1, "1", 2}}})},
+		{"remove missing field", func(u *elk.Update) { u.RemoveField("missing.n") }, withBase(t, Object{})},
+		{"remove field of a string", func(u *elk.Update) { u.RemoveField("s.n") }, withBase(t, Object{})},
+		{"increment", func(u *elk.Update) { u.Increment("n", 2) }, withBase(t, Object{"n": 3})},
+		{"decrement", func(u *elk.Update) { u.Increment("n", -1) }, withBase(t, Object{"n": 0})},
+		{"increment by a fraction", func(u *elk.Update) { u.Increment("nested.n", 0.5) }, withBase(t, Object{"nested": Object{"n": 5.5, "tags": Array{1, "1", 2}}})},
+		{"increment missing path", func(u *elk.Update) { u.Increment("a.b.c", 1) }, withBase(t, Object{"a": Object{"b": Object{"c": 1}}})},
+		{"remove from array", func(u *elk.Update) { u.RemoveFromArray("tags", "a") }, withBase(t, Object{"tags": Array{"b"}})},
+		{"remove number from mixed array", func(u *elk.Update) { u.RemoveFromArray("nested.tags", 1) }, withBase(t, Object{"nested": Object{"n": 5, "tags": Array{"1", 2}}})},
+		{"remove from string", func(u *elk.Update) { u.RemoveFromArray("s", "x") }, withBase(t, Object{})},
+		{"remove from missing array", func(u *elk.Update) { u.RemoveFromArray("missing.tags", "a") }, withBase(t, Object{})},
+		{"max raises", func(u *elk.Update) { u.SetMax("n", 10) }, withBase(t, Object{"n": 10})},
+		{"max keeps", func(u *elk.Update) { u.SetMax("n", 0) }, withBase(t, Object{})},
+		{"max of a fraction", func(u *elk.Update) { u.SetMax("n", 1.5) }, withBase(t, Object{"n": 1.5})},
+		{"max of missing field", func(u *elk.Update) { u.SetMax("nested.max", 3) }, withBase(t, Object{"nested": Object{"n": 5, "max": 3, "tags": Array{1, "1", 2}}})},
+		{"min lowers", func(u *elk.Update) { u.SetMin("nested.n", 2) }, withBase(t, Object{"nested": Object{"n": 2, "tags": Array{1, "1", 2}}})},
+		{"min keeps", func(u *elk.Update) { u.SetMin("n", 5) }, withBase(t, Object{})},
+		{"max of a string and a number", func(u *elk.Update) { u.SetMax("s", 1) }, nil},
+		{"update if equal", func(u *elk.Update) {
+			u.UpdateIf("s", "x", func(u *elk.Update) { u.SetField("hit", true) })
+		}, withBase(t, Object{"hit": true})},
+		{"update if not equal", func(u *elk.Update) {
+			u.UpdateIf("s", "y", func(u *elk.Update) { u.SetField("hit", true) })
+		}, withBase(t, Object{})},
+		{"update if number is a string", func(u *elk.Update) {
+			u.UpdateIf("n", "1", func(u *elk.Update) { u.SetField("hit", true) })
+		}, withBase(t, Object{})},
+		{"update if nested", func(u *elk.Update) {
+			u.UpdateIf("nested.n", 5, func(u *elk.Update) {
+				u.Increment("nested.n", 1)
+				u.UpdateIf("s", "x", func(u *elk.Update) { u.RemoveField("tags") })
+			})
+		}, withBase(t, Object{"nested": Object{"n": 6, "tags": Array{1, "1", 2}}, "tags": nil})},
+		{"update if missing is nil", func(u *elk.Update) {
+			u.UpdateIf("missing.n", nil, func(u *elk.Update) { u.SetField("created", 1) })
+		}, withBase(t, Object{"created": 1})},
+	}
+	for _, test := range tests {
+		update := elk.NewUpdate("/jobs/1")
+		test.operations(update)
+		if err := update.Err(); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		// applied locally
+		document := normalized(t, operationBase)
+		err := update.ApplyTo(document)
+		if test.expected == nil {
+			if err == nil || !reflect.DeepEqual(normalized(t, document), normalized(t, operationBase)) {
+				t.Errorf("%s: expected ApplyTo to fail and leave the document unchanged, got %v %v", test.name, err, document)
+			}
+		} else if err != nil || !reflect.DeepEqual(normalized(t, document), test.expected) {
+			t.Errorf("%s: expected ApplyTo to give %v, got %v %v", test.name, test.expected, document, err)
+		}
+
+		// applied by the fake server
+		server.Put("jobs", "1", operationBase)
+		results, err := client.Push(elk.BulkUpdate{"/jobs/1": update})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pushed, _ := server.Get("jobs", "1")
+		if test.expected == nil {
+			if !results.Errors || !reflect.DeepEqual(normalized(t, pushed), normalized(t, operationBase)) {
+				t.Errorf("%s: expected _update to fail and leave the document unchanged, got %+v %v", test.name, results, pushed)
+			}
+		} else if results.Errors || !reflect.DeepEqual(normalized(t, pushed), test.expected) {
+			t.Errorf("%s: expected _update to give %v, got %v %+v", test.name, test.expected, pushed, results)
+		}
+	}
+}
+
+func TestUpdateIfWithoutUpdates(t *testing.T) {
+	update := elk.NewUpdate("/jobs/1")
+	update.UpdateIf("status", "new", nil)
+	if update.Err() == nil {
+		t.Fatal("expected an error for UpdateIf without updates")
+	}
+	if _, err := (&elk.Client{}).Push(elk.BulkUpdate{"/jobs/1": update}); err == nil {
+		t.Fatal("expected push to refuse the update")
+	}
+}
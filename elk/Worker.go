@@ -1,13 +1,20 @@
 package elk
 
 import (
-	"log"
 	"time"
 )
 
+// Worker type executes Task on jobs sent to it by a JobPipeline
+// Id field identifies the worker within its pipeline in log messages
+// Logger field receives structured log messages, nil discards them
+// Metrics field receives task durations, panics and running job counts labeled with TaskName, nil discards them
 type Worker struct {
 	Client *Client
+	Id int
+	Logger Logger
+	Metrics Metrics
 	Task func(*Document)
+	TaskName string
 
 	stop chan bool
 	stopped chan bool
@@ -44,18 +51,65 @@ func (self *Worker) run() {
 }
 
 func (self *Worker) execute_task(job *Document) {
-	// release lock on job when done
-	defer self.Client.Unlock(job.Path())
-
 	// load the source fields
-	job, err := self.Client.GetDocument(job.Path())
+	path := job.Path()
+	job, err := self.Client.GetDocument(path)
 	if err != nil {
-		log.Printf("[ERROR] failed to load source fields of %s: %s", job.Path(), err)
+		self.logError("failed to load source fields", path, err)
+		self.unlock(path)
 		return
 	}
 
-	// execute task on job
+	// release lock on job when done, a job that panicked is marked failed so that it is not run forever
+	if self.run_task(job) {
+		self.unlock(path)
+	} else {
+		self.fail(path)
+	}
+}
+
+// run_task executes the task on job and returns false if the task panicked
+func (self *Worker) run_task(job *Document) (completed bool) {
+	metrics := metricsOrNop(self.Metrics)
+	metrics.AddRunningJobs(self.TaskName, 1)
+	defer metrics.AddRunningJobs(self.TaskName, -1)
+	defer self.recover(job)
+	start := time.Now()
 	self.Task(job)
+	metrics.ObserveTask(self.TaskName, time.Since(start))
+	return true
+}
+
+// recover reports a panic in the task and lets the worker continue with the next job
+func (self *Worker) recover(job *Document) {
+	if r := recover(); r != nil {
+		self.logError("task panicked", job.Path(), r)
+		metricsOrNop(self.Metrics).IncTaskPanics(self.TaskName)
+	}
+}
+
+// fail locks the job identified by path forever so that no pipeline runs it again
+func (self *Worker) fail(path string) {
+	if err := self.Client.Fail(path); err != nil {
+		self.logError("failed to mark job failed", path, err)
+	}
+}
+
+// unlock releases the lock on the job identified by path
+func (self *Worker) unlock(path string) {
+	if err := self.Client.Unlock(path); err != nil {
+		self.logError("failed to unlock job", path, err)
+	}
+}
+
+// logError logs an error processing the job identified by path
+func (self *Worker) logError(msg string, path string, err interface{}) {
+	loggerOrNop(self.Logger).Error(msg,
+		"task", self.TaskName,
+		"worker_id", self.Id,
+		"path", path,
+		"error", err,
+	)
 }
 
 // finish marks the worker as stopped
@@ -0,0 +1,39 @@
+package elktest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time the fake server uses to resolve now in range queries
+// A clock is frozen at the time it was created and only moves when Set or Advance is called
+type Clock struct {
+	lock sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a clock frozen at now
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the current time of the clock
+func (self *Clock) Now() time.Time {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.now
+}
+
+// Set moves the clock to now
+func (self *Clock) Set(now time.Time) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.now = now
+}
+
+// Advance moves the clock forward by duration
+func (self *Clock) Advance(duration time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.now = self.now.Add(duration)
+}
@@ -0,0 +1,553 @@
+package elktest
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk/painless"
+
+	"fmt"
+	"net/url"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// condition is the optional if_seq_no and if_primary_term of a write
+type condition struct {
+	seq_no int
+	primary_term int
+}
+
+// documentEndpoint handles GET, HEAD, PUT, POST and DELETE of a single document
+func (self *Server) documentEndpoint(method string, index_name string, id string, parameters url.Values, body []byte) (int, interface{}) {
+	switch method {
+		case "GET", "HEAD":
+			index, ok := self.indices[index_name]
+			if !ok {
+				return 404, errorBody(indexNotFound(index_name))
+			}
+			document, ok := index.documents[id]
+			if !ok {
+				return 404, Object{"_index": index_name, "_type": self.docType(), "_id": id, "found": false}
+			}
+			response := self.documentResponse(document, "")
+			response["found"] = true
+			source := sourceFilter(parameters)
+			if source != nil {
+				if filtered := filterSource(document.source, source); filtered != nil {
+					response["_source"] = filtered
+				}
+			} else {
+				response["_source"] = document.source
+			}
+			return 200, response
+		case "PUT", "POST":
+			source, err := decode(body)
+			if err != nil {
+				return err.status, errorBody(err)
+			}
+			conditional, err := parseCondition(parameters.Get("if_seq_no"), parameters.Get("if_primary_term"))
+			if err != nil {
+				return err.status, errorBody(err)
+			}
+			response, status, err := self.indexDocument(index_name, id, source, parameters.Get("op_type") == "create", conditional)
+			if err != nil {
+				return err.status, errorBody(err)
+			}
+			return status, response
+		case "DELETE":
+			conditional, err := parseCondition(parameters.Get("if_seq_no"), parameters.Get("if_primary_term"))
+			if err != nil {
+				return err.status, errorBody(err)
+			}
+			response, status, err := self.deleteDocument(index_name, id, conditional)
+			if err != nil {
+				return err.status, errorBody(err)
+			}
+			return status, response
+	}
+	return unsupported(method, []string{index_name, "_doc", id})
+}
+
+// updateEndpoint handles a partial update of a document with a script or doc
+func (self *Server) updateEndpoint(index_name string, id string, parameters url.Values, body []byte) (int, interface{}) {
+	request, err := decode(body)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	conditional, err := parseCondition(parameters.Get("if_seq_no"), parameters.Get("if_primary_term"))
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	response, status, err := self.updateDocument(index_name, id, request, conditional)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	return status, response
+}
+
+// indexDocument creates or replaces a document
+func (self *Server) indexDocument(index_name string, id string, source map[string]interface{}, create bool, conditional *condition) (Object, int, *esError) {
+	index := self.ensureIndex(index_name)
+	existing := index.documents[id]
+	if create && existing != nil {
+		return nil, 0, &esError{
+			status: 409,
+			kind: "version_conflict_engine_exception",
+			reason: fmt.Sprintf("[%s]: version conflict, document already exists (current version [%d])", id, existing.version),
+			index: index_name,
+		}
+	}
+	if err := checkCondition(index_name, id, existing, conditional); err != nil {
+		return nil, 0, err
+	}
+	document := self.write(index, id, source)
+	if existing == nil {
+		return self.documentResponse(document, "created"), 201, nil
+	}
+	return self.documentResponse(document, "updated"), 200, nil
+}
+
+// deleteDocument deletes a document
+func (self *Server) deleteDocument(index_name string, id string, conditional *condition) (Object, int, *esError) {
+	index, ok := self.indices[index_name]
+	if !ok {
+		return nil, 0, indexNotFound(index_name)
+	}
+	existing := index.documents[id]
+	if err := checkCondition(index_name, id, existing, conditional); err != nil {
+		return nil, 0, err
+	}
+	if existing == nil {
+		return Object{"_index": index_name, "_type": self.docType(), "_id": id, "_version": 1, "result": "not_found", "_shards": shards()}, 404, nil
+	}
+	self.remove(index, existing)
+	return self.documentResponse(existing, "deleted"), 200, nil
+}
+
+// updateDocument applies the script or doc of an update request, creating the document from the upsert if it does not exist
+func (self *Server) updateDocument(index_name string, id string, request map[string]interface{}, conditional *condition) (Object, int, *esError) {
+	index := self.ensureIndex(index_name)
+	existing := index.documents[id]
+	if err := checkCondition(index_name, id, existing, conditional); err != nil {
+		return nil, 0, err
+	}
+	script, has_script := request["script"]
+	doc, has_doc := request["doc"].(map[string]interface{})
+	if !has_script && !has_doc {
+		return nil, 0, &esError{status: 400, kind: "action_request_validation_exception", reason: "Validation Failed: 1: script or doc is missing;"}
+	}
+
+	// create missing documents from the upsert
+	if existing == nil {
+		upsert, has_upsert := request["upsert"].(map[string]interface{})
+		switch {
+			case has_doc && request["doc_as_upsert"] == true:
+				return self.documentResponse(self.write(index, id, copyValue(doc).(map[string]interface{})), "created"), 201, nil
+			case has_script && request["scripted_upsert"] == true:
+				source := map[string]interface{}{}
+				if has_upsert {
+					source = copyValue(upsert).(map[string]interface{})
+				}
+				operation, updated, err := self.runUpdateScript(index_name, id, source, script, "create")
+				if err != nil {
+					return nil, 0, err
+				}
+				if operation != "create" {
+					return Object{"_index": index_name, "_type": self.docType(), "_id": id, "_version": 0, "result": "noop", "_shards": shards()}, 200, nil
+				}
+				return self.documentResponse(self.write(index, id, updated), "created"), 201, nil
+			case has_upsert:
+				return self.documentResponse(self.write(index, id, copyValue(upsert).(map[string]interface{})), "created"), 201, nil
+		}
+		return nil, 0, &esError{
+			status: 404,
+			kind: "document_missing_exception",
+			reason: fmt.Sprintf("[%s][%s]: document missing", self.docType(), id),
+			index: index_name,
+		}
+	}
+
+	// merge partial documents
+	if !has_script {
+		merged := copyValue(existing.source).(map[string]interface{})
+		mergeSource(merged, doc)
+		if request["detect_noop"] != false && reflect.DeepEqual(merged, existing.source) {
+			return self.documentResponse(existing, "noop"), 200, nil
+		}
+		return self.documentResponse(self.write(index, id, merged), "updated"), 200, nil
+	}
+
+	// run scripts on a copy so failed scripts leave the document unchanged
+	operation, updated, err := self.runUpdateScript(index_name, id, copyValue(existing.source).(map[string]interface{}), script, "index")
+	if err != nil {
+		return nil, 0, err
+	}
+	switch operation {
+		case "delete":
+			self.remove(index, existing)
+			return self.documentResponse(existing, "deleted"), 200, nil
+		case "none", "noop":
+			return self.documentResponse(existing, "noop"), 200, nil
+	}
+	return self.documentResponse(self.write(index, id, updated), "updated"), 200, nil
+}
+
+// runUpdateScript runs an update script against source and returns ctx.op and the updated source
+func (self *Server) runUpdateScript(index_name string, id string, source map[string]interface{}, script interface{}, operation string) (string, map[string]interface{}, *esError) {
+	script_source, params, err := self.resolveScript(script)
+	if err != nil {
+		return "", nil, err
+	}
+	ctx := map[string]interface{}{
+		"_source": source,
+		"_index": index_name,
+		"_id": id,
+		"op": operation,
+		"_now": float64(self.Clock.Now().UnixNano() / 1000000),
+	}
+	if run_err := painless.Execute(script_source, map[string]interface{}{"ctx": ctx, "params": params}); run_err != nil {
+		return "", nil, &esError{
+			status: 400,
+			kind: "illegal_argument_exception",
+			reason: "failed to execute script",
+			caused_by: &esError{kind: "script_exception", reason: run_err.Error()},
+		}
+	}
+	updated, ok := ctx["_source"].(map[string]interface{})
+	if !ok {
+		return "", nil, badRequest("ctx._source must be an object")
+	}
+	result, _ := ctx["op"].(string)
+	return result, updated, nil
+}
+
+// resolveScript returns the source and a copy of the params of an inline or stored painless script
+func (self *Server) resolveScript(script interface{}) (string, map[string]interface{}, *esError) {
+	params := map[string]interface{}{}
+	switch typed := script.(type) {
+		case string:
+			return typed, params, nil
+		case map[string]interface{}:
+			if value, ok := typed["params"].(map[string]interface{}); ok {
+				params = copyValue(value).(map[string]interface{})
+			}
+			if source, ok := typed["source"].(string); ok {
+				return source, params, nil
+			}
+			if id, ok := typed["id"].(string); ok {
+				stored, ok := self.scripts[id]
+				if !ok {
+					return "", nil, &esError{status: 404, kind: "resource_not_found_exception", reason: fmt.Sprintf("unable to find script [%s] in cluster state", id)}
+				}
+				source, ok := stored["source"].(string)
+				if !ok || stored["lang"] != "painless" {
+					return "", nil, badRequest(fmt.Sprintf("stored script [%s] is not a painless script", id))
+				}
+				return source, params, nil
+			}
+	}
+	return "", nil, badRequest("script must have a source or id")
+}
+
+// write creates or replaces a document, incrementing its version and sequence number
+func (self *Server) write(index *index, id string, source map[string]interface{}) *document {
+	index.seq_no++
+	existing, ok := index.documents[id]
+	if !ok {
+		self.next_order++
+		existing = &document{index: index.name, id: id, order: self.next_order, primary_term: 1}
+		index.documents[id] = existing
+	}
+	existing.source = source
+	existing.version++
+	existing.seq_no = index.seq_no
+	return existing
+}
+
+// remove deletes a document
+func (self *Server) remove(index *index, document *document) {
+	index.seq_no++
+	document.version++
+	document.seq_no = index.seq_no
+	delete(index.documents, document.id)
+}
+
+// documentResponse returns the metadata of a document as returned by write and get requests
+func (self *Server) documentResponse(document *document, result string) Object {
+	response := Object{
+		"_index": document.index,
+		"_type": self.docType(),
+		"_id": document.id,
+		"_version": document.version,
+		"_seq_no": document.seq_no,
+		"_primary_term": document.primary_term,
+	}
+	if result != "" {
+		response["result"] = result
+		response["_shards"] = shards()
+	}
+	return response
+}
+
+// parseCondition reads if_seq_no and if_primary_term, returning nil if neither is set
+func parseCondition(seq_no interface{}, primary_term interface{}) (*condition, *esError) {
+	if seq_no == nil || seq_no == "" {
+		return nil, nil
+	}
+	conditional := &condition{}
+	for _, pair := range []struct{value interface{}; target *int}{{seq_no, &conditional.seq_no}, {primary_term, &conditional.primary_term}} {
+		switch typed := pair.value.(type) {
+			case float64:
+				*pair.target = int(typed)
+			case string:
+				parsed, err := strconv.Atoi(typed)
+				if err != nil {
+					return nil, badRequest(fmt.Sprintf("invalid if_seq_no or if_primary_term [%s]", typed))
+				}
+				*pair.target = parsed
+			default:
+				return nil, badRequest("if_seq_no requires if_primary_term")
+		}
+	}
+	return conditional, nil
+}
+
+// checkCondition returns a version conflict if the document does not have the sequence number and primary term of the condition
+func checkCondition(index_name string, id string, existing *document, conditional *condition) *esError {
+	if conditional == nil {
+		return nil
+	}
+	required := fmt.Sprintf("required seqNo [%d], primary term [%d]", conditional.seq_no, conditional.primary_term)
+	if existing == nil {
+		return &esError{
+			status: 409,
+			kind: "version_conflict_engine_exception",
+			reason: fmt.Sprintf("[%s]: version conflict, %s but no document was found", id, required),
+			index: index_name,
+		}
+	}
+	if existing.seq_no != conditional.seq_no || existing.primary_term != conditional.primary_term {
+		return &esError{
+			status: 409,
+			kind: "version_conflict_engine_exception",
+			reason: fmt.Sprintf("[%s]: version conflict, %s. current document has seqNo [%d] and primary term [%d]", id, required, existing.seq_no, existing.primary_term),
+			index: index_name,
+		}
+	}
+	return nil
+}
+
+// mergeSource recursively merges a partial document into source
+func mergeSource(source map[string]interface{}, partial map[string]interface{}) {
+	for key := range partial {
+		child, child_is_object := partial[key].(map[string]interface{})
+		existing, existing_is_object := source[key].(map[string]interface{})
+		if child_is_object && existing_is_object {
+			mergeSource(existing, child)
+		} else {
+			source[key] = copyValue(partial[key])
+		}
+	}
+}
+
+// sourceFilter returns the _source filter of get request parameters or nil if the whole source is returned
+func sourceFilter(parameters url.Values) interface{} {
+	if parameters.Get("_source") == "false" {
+		return false
+	}
+	includes := parameters.Get("_source_includes")
+	excludes := parameters.Get("_source_excludes")
+	if includes == "" && excludes == "" {
+		return nil
+	}
+	filter := map[string]interface{}{}
+	if includes != "" {
+		filter["includes"] = splitList(includes)
+	}
+	if excludes != "" {
+		filter["excludes"] = splitList(excludes)
+	}
+	return filter
+}
+
+func splitList(value string) []interface{} {
+	items := []interface{}{}
+	for _, item := range strings.Split(value, ",") {
+		items = append(items, item)
+	}
+	return items
+}
+
+// filterSource applies a _source filter which may be a bool, a field pattern, an array of field patterns or an object of includes and excludes
+// A nil result means the source is omitted
+func filterSource(source map[string]interface{}, filter interface{}) map[string]interface{} {
+	includes := []string{}
+	excludes := []string{}
+	switch typed := filter.(type) {
+		case nil:
+			return source
+		case bool:
+			if !typed {
+				return nil
+			}
+			return source
+		case string:
+			includes = append(includes, typed)
+		case []interface{}:
+			includes = patterns(typed)
+		case map[string]interface{}:
+			includes = patterns(typed["includes"])
+			excludes = patterns(typed["excludes"])
+	}
+	return filterFields(source, "", includes, excludes)
+}
+
+func patterns(value interface{}) []string {
+	switch typed := value.(type) {
+		case string:
+			return []string{typed}
+		case []interface{}:
+			names := []string{}
+			for i := range typed {
+				if name, ok := typed[i].(string); ok {
+					names = append(names, name)
+				}
+			}
+			return names
+	}
+	return []string{}
+}
+
+func filterFields(source map[string]interface{}, prefix string, includes []string, excludes []string) map[string]interface{} {
+	filtered := map[string]interface{}{}
+	for key := range source {
+		field := prefix + key
+		if matchesAny(field, excludes) {
+			continue
+		}
+		if len(includes) == 0 || matchesAny(field, includes) {
+			if child, ok := source[key].(map[string]interface{}); ok && len(excludes) > 0 {
+				filtered[key] = filterFields(child, field + ".", nil, excludes)
+			} else {
+				filtered[key] = source[key]
+			}
+			continue
+		}
+
+		// include objects that contain included fields
+		if child, ok := source[key].(map[string]interface{}); ok {
+			for _, include := range includes {
+				if strings.HasPrefix(include, field + ".") {
+					filtered[key] = filterFields(child, field + ".", includes, excludes)
+					break
+				}
+			}
+		}
+	}
+	return filtered
+}
+
+func matchesAny(field string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, field); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// bulk executes index, create, update and delete actions
+func (self *Server) bulk(default_index string, body []byte) (int, interface{}) {
+	lines, err := decodeLines(body)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	items := Array{}
+	errors := false
+	for i := 0; i < len(lines); i++ {
+		for operation := range lines[i] {
+			metadata, _ := lines[i][operation].(map[string]interface{})
+			index_name, _ := metadata["_index"].(string)
+			if index_name == "" {
+				index_name = default_index
+			}
+			id, _ := metadata["_id"].(string)
+			conditional, err := parseCondition(metadata["if_seq_no"], metadata["if_primary_term"])
+
+			// every operation except delete is followed by a source line
+			source := map[string]interface{}{}
+			if operation != "delete" {
+				i++
+				if i >= len(lines) {
+					return 400, errorBody(badRequest("The bulk request must be terminated by a newline"))
+				}
+				source = lines[i]
+			}
+
+			var response Object
+			status := 200
+			if err == nil {
+				switch operation {
+					case "index", "create":
+						if id == "" {
+							self.next_id++
+							id = fmt.Sprintf("elktest-%d", self.next_id)
+						}
+						response, status, err = self.indexDocument(index_name, id, source, operation == "create", conditional)
+					case "update":
+						response, status, err = self.updateDocument(index_name, id, source, conditional)
+					case "delete":
+						response, status, err = self.deleteDocument(index_name, id, conditional)
+					default:
+						err = badRequest(fmt.Sprintf("Malformed action/metadata line [%d], expected one of [create, delete, index, update] but found [%s]", i + 1, operation))
+				}
+			}
+			if err != nil {
+				errors = true
+				response = Object{"_index": index_name, "_type": self.docType(), "_id": id, "status": err.status, "error": err.cause()}
+			} else {
+				response["status"] = status
+			}
+			items = append(items, Object{operation: response})
+		}
+	}
+	return 200, Object{"took": 1, "errors": errors, "items": items}
+}
+
+// multiGet returns several documents by index and id
+func (self *Server) multiGet(default_index string, body []byte) (int, interface{}) {
+	request, err := decode(body)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	docs, _ := request["docs"].([]interface{})
+	if ids, ok := request["ids"].([]interface{}); ok {
+		for i := range ids {
+			docs = append(docs, map[string]interface{}{"_id": ids[i]})
+		}
+	}
+	responses := Array{}
+	for i := range docs {
+		metadata, _ := docs[i].(map[string]interface{})
+		index_name, _ := metadata["_index"].(string)
+		if index_name == "" {
+			index_name = default_index
+		}
+		id, _ := metadata["_id"].(string)
+		response := Object{"_index": index_name, "_type": self.docType(), "_id": id, "found": false}
+		if index, ok := self.indices[index_name]; ok {
+			if document, ok := index.documents[id]; ok {
+				response = self.documentResponse(document, "")
+				response["found"] = true
+				if filtered := filterSource(document.source, metadata["_source"]); filtered != nil {
+					response["_source"] = filtered
+				}
+			}
+		} else {
+			response["error"] = indexNotFound(index_name).cause()
+		}
+		responses = append(responses, response)
+	}
+	return 200, Object{"docs": responses}
+}
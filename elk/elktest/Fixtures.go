@@ -0,0 +1,330 @@
+package elktest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Fixture is a recorded request and the response the cluster returned
+type Fixture struct {
+	Method string `json:"method"`
+	Path string `json:"path"`
+	RequestBody string `json:"request_body,omitempty"`
+	StatusCode int `json:"status_code"`
+	ResponseHeader http.Header `json:"response_header,omitempty"`
+	ResponseBody string `json:"response_body"`
+}
+
+// LoadFixtures reads the fixtures written by a Recorder
+func LoadFixtures(path string) ([]Fixture, error) {
+	fixtures := []Fixture{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fixtures, err
+	}
+	err = json.Unmarshal(data, &fixtures)
+	return fixtures, err
+}
+
+// SaveFixtures writes fixtures to path
+func SaveFixtures(path string, fixtures []Fixture) error {
+	data, err := json.MarshalIndent(fixtures, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Recorder is an http.RoundTripper for elk.Client.HttpClient that sends requests to a real cluster and records every
+// request and response. Save or Close writes them to a fixtures file that a Replayer can serve offline.
+// Transport field sends the requests, nil uses http.DefaultTransport
+// Redact field, such as elk.RedactFields, removes secrets from request and response bodies before they are saved
+type Recorder struct {
+	Path string
+	Transport http.RoundTripper
+	Redact func([]byte) []byte
+
+	lock sync.Mutex
+	fixtures []Fixture
+}
+
+// NewRecorder returns a recorder that writes fixtures to path
+func NewRecorder(path string, transport http.RoundTripper) *Recorder {
+	return &Recorder{Path: path, Transport: transport}
+}
+
+// RoundTrip sends the request and records it with its response
+func (self *Recorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	clone, request_body, err := readBody(request)
+	if err != nil {
+		return nil, err
+	}
+	transport := self.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	response, err := transport.RoundTrip(clone)
+	if err != nil {
+		return response, err
+	}
+	response.Request = request
+	response_body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return response, err
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(response_body))
+
+	// record the request
+	if self.Redact != nil {
+		if len(request_body) > 0 {
+			request_body = self.Redact(request_body)
+		}
+		if len(response_body) > 0 {
+			response_body = self.Redact(response_body)
+		}
+	}
+	// the content length changes when bodies are redacted
+	header := response.Header.Clone()
+	header.Del("Content-Length")
+	fixture := Fixture{
+		Method: request.Method,
+		Path: requestPath(request.URL),
+		RequestBody: string(request_body),
+		StatusCode: response.StatusCode,
+		ResponseHeader: header,
+		ResponseBody: string(response_body),
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.fixtures = append(self.fixtures, fixture)
+	return response, nil
+}
+
+// Save writes the fixtures recorded so far to Path
+func (self *Recorder) Save() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return SaveFixtures(self.Path, self.fixtures)
+}
+
+// Close writes the recorded fixtures to Path, call it once recording is done
+func (self *Recorder) Close() error {
+	return self.Save()
+}
+
+// Fixtures returns the fixtures recorded so far
+func (self *Recorder) Fixtures() []Fixture {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([]Fixture{}, self.fixtures...)
+}
+
+// Replayer is an http.RoundTripper for elk.Client.HttpClient that answers requests from recorded fixtures
+// Requests match a fixture with the same method, path, query parameters and json body regardless of key order or whitespace.
+// Identical requests are answered by their fixtures in the order they were recorded, the last one is repeated once all are used.
+// Strict field makes unmatched requests fail with an error, otherwise they are sent to Transport or answered with a 404 if Transport is nil
+// Ignore field names body fields that are left out of matching, such as timestamps that differ on every run or fields redacted while recording
+type Replayer struct {
+	Strict bool
+	Transport http.RoundTripper
+	Ignore []string
+
+	lock sync.Mutex
+	fixtures []Fixture
+	used []bool
+	unmatched []string
+}
+
+// NewReplayer returns a replayer that serves the fixtures in path
+func NewReplayer(path string, strict bool) (*Replayer, error) {
+	fixtures, err := LoadFixtures(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{Strict: strict, fixtures: fixtures, used: make([]bool, len(fixtures))}, nil
+}
+
+// RoundTrip returns the recorded response of the first unused fixture matching the request
+func (self *Replayer) RoundTrip(request *http.Request) (*http.Response, error) {
+	clone, request_body, err := readBody(request)
+	if err != nil {
+		return nil, err
+	}
+	path := requestPath(request.URL)
+	body := normalizeBody(request_body, self.Ignore)
+
+	self.lock.Lock()
+	matched := -1
+	for i := range self.fixtures {
+		if self.fixtures[i].Method != request.Method || self.fixtures[i].Path != path {
+			continue
+		}
+		if normalizeBody([]byte(self.fixtures[i].RequestBody), self.Ignore) != body {
+			continue
+		}
+		matched = i
+		if !self.used[i] {
+			break
+		}
+	}
+	if matched == -1 {
+		self.unmatched = append(self.unmatched, fmt.Sprintf("%s %s", request.Method, path))
+		self.lock.Unlock()
+		return self.unmatchedResponse(request, clone, path)
+	}
+	self.used[matched] = true
+	fixture := self.fixtures[matched]
+	self.lock.Unlock()
+
+	header := fixture.ResponseHeader.Clone()
+	if header == nil {
+		header = http.Header{"Content-Type": []string{"application/json"}}
+	}
+	return &http.Response{
+		Status: fmt.Sprintf("%d %s", fixture.StatusCode, http.StatusText(fixture.StatusCode)),
+		StatusCode: fixture.StatusCode,
+		Proto: "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: header,
+		Body: ioutil.NopCloser(strings.NewReader(fixture.ResponseBody)),
+		ContentLength: int64(len(fixture.ResponseBody)),
+		Request: request,
+	}, nil
+}
+
+// Unused returns the fixtures that have not answered a request, which usually means the code under test changed
+func (self *Replayer) Unused() []Fixture {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	unused := []Fixture{}
+	for i := range self.fixtures {
+		if !self.used[i] {
+			unused = append(unused, self.fixtures[i])
+		}
+	}
+	return unused
+}
+
+// Unmatched returns the method and path of every request that did not match a fixture
+func (self *Replayer) Unmatched() []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([]string{}, self.unmatched...)
+}
+
+// unmatchedResponse fails in strict mode, otherwise forwards the request to the transport or answers with a 404
+// Clone is the copy of request with its body restored that is sent to Transport.
+func (self *Replayer) unmatchedResponse(request *http.Request, clone *http.Request, path string) (*http.Response, error) {
+	if self.Strict {
+		return nil, errors.New(fmt.Sprintf("No fixture matches %s %s", request.Method, path))
+	}
+	if self.Transport != nil {
+		response, err := self.Transport.RoundTrip(clone)
+		if response != nil {
+			response.Request = request
+		}
+		return response, err
+	}
+	body := fmt.Sprintf(`{"error":{"type":"fixture_not_found","reason":"No fixture matches %s %s"},"status":404}`, request.Method, path)
+	return &http.Response{
+		Status: "404 Not Found",
+		StatusCode: 404,
+		Proto: "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body: ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request: request,
+	}, nil
+}
+
+// readBody reads the body of a request and returns it with a clone of the request that can still be sent, leaving request
+// unmodified as http.RoundTripper requires. The body is read from GetBody when it is set so the body of request is not consumed.
+func readBody(request *http.Request) (*http.Request, []byte, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return request, nil, nil
+	}
+	reader := request.Body
+	if request.GetBody != nil {
+		body_copy, err := request.GetBody()
+		if err != nil {
+			return nil, nil, err
+		}
+		request.Body.Close()
+		reader = body_copy
+	}
+	body, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	clone := request.Clone(request.Context())
+	clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return clone, body, nil
+}
+
+// requestPath returns the path and sorted query parameters of a url without the host so fixtures match any cluster address
+func requestPath(location *url.URL) string {
+	query := location.Query().Encode()
+	if query == "" {
+		return location.Path
+	}
+	return fmt.Sprintf("%s?%s", location.Path, query)
+}
+
+// normalizeBody re-encodes each json line of a body so key order and whitespace do not matter, dropping ignored fields
+// Lines that are not json are compared as they are.
+func normalizeBody(body []byte, ignore []string) string {
+	ignored := map[string]bool{}
+	for _, field := range ignore {
+		ignored[field] = true
+	}
+	lines := []string{}
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(line, &value); err != nil {
+			lines = append(lines, string(line))
+			continue
+		}
+		data, _ := json.Marshal(dropFields(value, ignored))
+		lines = append(lines, string(data))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dropFields removes ignored fields from a decoded json value at any depth
+func dropFields(value interface{}, ignored map[string]bool) interface{} {
+	switch typed := value.(type) {
+		case map[string]interface{}:
+			for key := range typed {
+				if ignored[key] {
+					delete(typed, key)
+				} else {
+					typed[key] = dropFields(typed[key], ignored)
+				}
+			}
+		case []interface{}:
+			for i := range typed {
+				typed[i] = dropFields(typed[i], ignored)
+			}
+	}
+	return value
+}
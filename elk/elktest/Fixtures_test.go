@@ -0,0 +1,112 @@
+package elktest_test
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk"
+	"github.com/KarmaPenny/golib/elk/elktest"
+
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// exercise sends the same requests whether the client talks to the fake server or replays fixtures
+func exercise(t *testing.T, client *elk.Client) (*elk.Document, []elk.Document) {
+	if _, err := client.Index("jobs", "1", Object{"status": "new"}); err != nil {
+		t.Fatal(err)
+	}
+	document, err := client.GetDocument("/jobs/_doc/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := client.Search("jobs", Object{"query": Object{"term": Object{"status": "new"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return document, results
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	server := elktest.NewServer()
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+
+	recorder := elktest.NewRecorder(path, server.Server.Client().Transport)
+	recorded_document, recorded_results := exercise(t, &elk.Client{BaseUrl: server.URL, HttpClient: &http.Client{Transport: recorder}})
+
+	// nothing is written until the recording is closed
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected fixtures to be written on close, stat returned %v", err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer, err := elktest.NewReplayer(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayer.Unused()) != len(recorder.Fixtures()) {
+		t.Fatalf("expected %d saved fixtures, got %d", len(recorder.Fixtures()), len(replayer.Unused()))
+	}
+	document, results := exercise(t, &elk.Client{BaseUrl: "http://fixtures", HttpClient: &http.Client{Transport: replayer}})
+	if document.Source["status"] != "new" || document.Id != recorded_document.Id || document.Version != recorded_document.Version {
+		t.Errorf("expected replayed document %+v, got %+v", recorded_document, document)
+	}
+	if len(results) != 1 || len(results) != len(recorded_results) {
+		t.Errorf("expected replayed hits %+v, got %+v", recorded_results, results)
+	}
+	if unmatched := replayer.Unmatched(); len(unmatched) > 0 {
+		t.Errorf("unexpected requests %v", unmatched)
+	}
+	if unused := replayer.Unused(); len(unused) > 0 {
+		t.Errorf("fixtures were not replayed %v", unused)
+	}
+}
+
+// TestRoundTripKeepsRequest records and replays a request without consuming or replacing the body of the caller's request
+func TestRoundTripKeepsRequest(t *testing.T) {
+	server := elktest.NewServer()
+	defer server.Close()
+	payload := `{"status":"new"}`
+	send := func(transport http.RoundTripper) {
+		request, err := http.NewRequest("PUT", server.URL + "/jobs/_doc/1", strings.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := request.Body
+		response, err := transport.RoundTrip(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if request.Body != body || response.Request != request {
+			t.Errorf("%T replaced the request", transport)
+		}
+		if unread, _ := ioutil.ReadAll(request.Body); string(unread) != payload {
+			t.Errorf("%T consumed the request body, %q is left", transport, unread)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+	recorder := elktest.NewRecorder(path, server.Server.Client().Transport)
+	send(recorder)
+	if fixtures := recorder.Fixtures(); len(fixtures) != 1 || fixtures[0].RequestBody != payload {
+		t.Fatalf("expected the request body to be recorded, got %+v", fixtures)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer, err := elktest.NewReplayer(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(replayer)
+	if unmatched := replayer.Unmatched(); len(unmatched) > 0 {
+		t.Errorf("unexpected requests %v", unmatched)
+	}
+}
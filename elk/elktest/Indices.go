@@ -0,0 +1,160 @@
+package elktest
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// indexEndpoint checks whether an index exists, creates, returns or deletes it
+func (self *Server) indexEndpoint(method string, index_name string, body []byte) (int, interface{}) {
+	switch method {
+		case "HEAD":
+			if _, ok := self.indices[index_name]; ok {
+				return 200, nil
+			}
+			return 404, nil
+		case "PUT":
+			if _, ok := self.indices[index_name]; ok {
+				err := &esError{status: 400, kind: "resource_already_exists_exception", reason: fmt.Sprintf("index [%s] already exists", index_name), index: index_name}
+				return err.status, errorBody(err)
+			}
+			request, err := decode(body)
+			if err != nil {
+				return err.status, errorBody(err)
+			}
+			index := self.ensureIndex(index_name)
+			if settings, ok := request["settings"].(map[string]interface{}); ok {
+				flattenSettings(index.settings, "", settings)
+			}
+			if mappings, ok := request["mappings"].(map[string]interface{}); ok {
+				mergeSource(index.mappings, mappings)
+			}
+			return 200, Object{"acknowledged": true, "shards_acknowledged": true, "index": index_name}
+		case "GET":
+			indices, err := self.resolveIndices(index_name)
+			if err != nil {
+				return err.status, errorBody(err)
+			}
+			response := Object{}
+			for _, index := range indices {
+				response[index.name] = Object{"aliases": Object{}, "mappings": index.mappings, "settings": nestSettings(index.settings)}
+			}
+			return 200, response
+		case "DELETE":
+			indices, err := self.resolveIndices(index_name)
+			if err != nil {
+				return err.status, errorBody(err)
+			}
+			for _, index := range indices {
+				delete(self.indices, index.name)
+			}
+			return 200, acknowledged()
+	}
+	return unsupported(method, []string{index_name})
+}
+
+// settingsEndpoint returns or updates index settings. Settings are stored flat, such as index.refresh_interval.
+func (self *Server) settingsEndpoint(method string, index_name string, name string, parameters url.Values, body []byte) (int, interface{}) {
+	indices, err := self.resolveIndices(index_name)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	switch method {
+		case "GET":
+			response := Object{}
+			for _, index := range indices {
+				settings := Object{}
+				for key := range index.settings {
+					if name == "" || matchesAny(key, strings.Split(name, ",")) {
+						settings[key] = index.settings[key]
+					}
+				}
+				if parameters.Get("flat_settings") == "true" {
+					response[index.name] = Object{"settings": settings}
+				} else {
+					response[index.name] = Object{"settings": nestSettings(settings)}
+				}
+			}
+			return 200, response
+		case "PUT":
+			request, err := decode(body)
+			if err != nil {
+				return err.status, errorBody(err)
+			}
+			if settings, ok := request["settings"].(map[string]interface{}); ok {
+				request = settings
+			}
+			for _, index := range indices {
+				flattenSettings(index.settings, "", request)
+			}
+			return 200, acknowledged()
+	}
+	return unsupported(method, []string{index_name, "_settings"})
+}
+
+// mappingEndpoint returns or adds to the mappings of an index
+func (self *Server) mappingEndpoint(method string, index_name string, body []byte) (int, interface{}) {
+	indices, err := self.resolveIndices(index_name)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	switch method {
+		case "GET":
+			response := Object{}
+			for _, index := range indices {
+				response[index.name] = Object{"mappings": index.mappings}
+			}
+			return 200, response
+		case "PUT", "POST":
+			request, err := decode(body)
+			if err != nil {
+				return err.status, errorBody(err)
+			}
+			for _, index := range indices {
+				mergeSource(index.mappings, request)
+			}
+			return 200, acknowledged()
+	}
+	return unsupported(method, []string{index_name, "_mapping"})
+}
+
+// flattenSettings adds nested settings to flat settings, prefixing names with index. if they do not already have it
+func flattenSettings(flat Object, prefix string, settings map[string]interface{}) {
+	for key := range settings {
+		name := prefix + key
+		if child, ok := settings[key].(map[string]interface{}); ok {
+			flattenSettings(flat, name + ".", child)
+			continue
+		}
+		if !strings.HasPrefix(name, "index.") {
+			name = "index." + name
+		}
+		if settings[key] == nil {
+			delete(flat, name)
+		} else {
+			flat[name] = fmt.Sprintf("%v", settings[key])
+		}
+	}
+}
+
+// nestSettings converts flat settings to nested objects
+func nestSettings(flat Object) Object {
+	nested := Object{}
+	for key := range flat {
+		parts := strings.Split(key, ".")
+		current := nested
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := current[part].(Object)
+			if !ok {
+				child = Object{}
+				current[part] = child
+			}
+			current = child
+		}
+		current[parts[len(parts)-1]] = flat[key]
+	}
+	return nested
+}
@@ -0,0 +1,77 @@
+package elktest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// renderTemplate renders the subset of mustache used by search templates: {{name}}, {{{name}}} and {{#toJson}}name{{/toJson}}
+// Names may use dots to reach into objects. String values are json escaped without quotes and other values are inserted as json.
+func renderTemplate(source string, params map[string]interface{}) (string, error) {
+	output := strings.Builder{}
+	for {
+		start := strings.Index(source, "{{")
+		if start == -1 {
+			output.WriteString(source)
+			return output.String(), nil
+		}
+		output.WriteString(source[:start])
+		source = source[start:]
+
+		// tags are closed by }}} when opened by {{{
+		closing := "}}"
+		if strings.HasPrefix(source, "{{{") {
+			closing = "}}}"
+		}
+		end := strings.Index(source, closing)
+		if end == -1 {
+			return "", errors.New("unclosed mustache tag")
+		}
+		tag := strings.TrimSpace(strings.Trim(source[:end], "{"))
+		source = source[end+len(closing):]
+
+		switch {
+			case tag == "#toJson":
+				end = strings.Index(source, "{{/toJson}}")
+				if end == -1 {
+					return "", errors.New("unclosed toJson section")
+				}
+				data, err := json.Marshal(lookupParam(params, strings.TrimSpace(source[:end])))
+				if err != nil {
+					return "", err
+				}
+				output.Write(data)
+				source = source[end+len("{{/toJson}}"):]
+			case strings.HasPrefix(tag, "#") || strings.HasPrefix(tag, "^") || strings.HasPrefix(tag, "/"):
+				return "", errors.New(fmt.Sprintf("unsupported mustache section {{%s}}", tag))
+			case strings.HasPrefix(tag, "!"):
+			default:
+				value := lookupParam(params, tag)
+				if text, ok := value.(string); ok {
+					data, _ := json.Marshal(text)
+					output.Write(data[1:len(data)-1])
+				} else if value != nil {
+					data, err := json.Marshal(value)
+					if err != nil {
+						return "", err
+					}
+					output.Write(data)
+				}
+		}
+	}
+}
+
+// lookupParam returns the value of a dotted parameter name
+func lookupParam(params map[string]interface{}, name string) interface{} {
+	var value interface{} = params
+	for _, part := range strings.Split(name, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+	return value
+}
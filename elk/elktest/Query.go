@@ -0,0 +1,487 @@
+package elktest
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateMath matches now with an optional offset such as now-1h and an optional rounding such as /d
+var dateMath = regexp.MustCompile(`^now(([+-]\d+[yMwdhHms])*)(/[yMwdhHms])?$`)
+var dateOffset = regexp.MustCompile(`([+-])(\d+)([yMwdhHms])`)
+
+// matches returns true if the document matches the query
+func (self *Server) matches(document *document, query map[string]interface{}) (bool, error) {
+	if len(query) == 0 {
+		return true, nil
+	}
+	if len(query) != 1 {
+		return false, errors.New("query must have exactly one clause")
+	}
+	for kind := range query {
+		body, ok := query[kind].(map[string]interface{})
+		if !ok {
+			return false, errors.New(fmt.Sprintf("[%s] query malformed", kind))
+		}
+		switch kind {
+			case "match_all":
+				return true, nil
+			case "match_none":
+				return false, nil
+			case "bool":
+				return self.matchesBool(document, body)
+			case "ids":
+				values, _ := body["values"].([]interface{})
+				for i := range values {
+					if values[i] == document.id {
+						return true, nil
+					}
+				}
+				return false, nil
+			case "exists":
+				field, _ := body["field"].(string)
+				return len(document.values(field)) > 0, nil
+			case "term", "match", "match_phrase":
+				field, value, err := fieldValue(body, "value", "query")
+				if err != nil {
+					return false, err
+				}
+				return anyValue(document.values(field), func(candidate interface{}) bool {
+					return termEqual(candidate, value)
+				}), nil
+			case "terms":
+				for field := range body {
+					values, ok := body[field].([]interface{})
+					if !ok {
+						return false, errors.New("[terms] query requires an array of values")
+					}
+					return anyValue(document.values(field), func(candidate interface{}) bool {
+						for i := range values {
+							if termEqual(candidate, values[i]) {
+								return true
+							}
+						}
+						return false
+					}), nil
+				}
+				return false, nil
+			case "prefix":
+				field, value, err := fieldValue(body, "value")
+				if err != nil {
+					return false, err
+				}
+				return anyValue(document.values(field), func(candidate interface{}) bool {
+					return strings.HasPrefix(fmt.Sprintf("%v", candidate), fmt.Sprintf("%v", value))
+				}), nil
+			case "wildcard":
+				field, value, err := fieldValue(body, "value", "wildcard")
+				if err != nil {
+					return false, err
+				}
+				return anyValue(document.values(field), func(candidate interface{}) bool {
+					matched, _ := path.Match(fmt.Sprintf("%v", value), fmt.Sprintf("%v", candidate))
+					return matched
+				}), nil
+			case "range":
+				return self.matchesRange(document, body)
+		}
+		return false, errors.New(fmt.Sprintf("unknown query [%s]", kind))
+	}
+	return false, nil
+}
+
+// matchesBool evaluates a bool query. Should clauses are required only when there are no must or filter clauses or minimum_should_match is set.
+func (self *Server) matchesBool(document *document, body map[string]interface{}) (bool, error) {
+	for _, occur := range []string{"must", "filter"} {
+		for _, clause := range clauses(body[occur]) {
+			matched, err := self.matches(document, clause)
+			if err != nil || !matched {
+				return false, err
+			}
+		}
+	}
+	for _, clause := range clauses(body["must_not"]) {
+		matched, err := self.matches(document, clause)
+		if err != nil || matched {
+			return false, err
+		}
+	}
+	should := clauses(body["should"])
+	minimum := 0
+	if len(should) > 0 && len(clauses(body["must"])) == 0 && len(clauses(body["filter"])) == 0 {
+		minimum = 1
+	}
+	switch value := body["minimum_should_match"].(type) {
+		case float64:
+			minimum = int(value)
+		case string:
+			if parsed, err := strconv.Atoi(value); err == nil {
+				minimum = parsed
+			}
+	}
+	matched := 0
+	for _, clause := range should {
+		ok, err := self.matches(document, clause)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			matched++
+		}
+	}
+	return matched >= minimum, nil
+}
+
+// matchesRange evaluates a range query against numbers, epoch millis, dates and date math relative to the clock
+func (self *Server) matchesRange(document *document, body map[string]interface{}) (bool, error) {
+	for field := range body {
+		bounds, ok := body[field].(map[string]interface{})
+		if !ok {
+			return false, errors.New("[range] query malformed")
+		}
+		return anyValue(document.values(field), func(candidate interface{}) bool {
+			for operator, bound := range bounds {
+				if operator == "format" || operator == "time_zone" {
+					continue
+				}
+				comparison, ok := self.compareRange(candidate, bound)
+				if !ok {
+					return false
+				}
+				switch operator {
+					case "gt":
+						ok = comparison > 0
+					case "gte":
+						ok = comparison >= 0
+					case "lt":
+						ok = comparison < 0
+					case "lte":
+						ok = comparison <= 0
+				}
+				if !ok {
+					return false
+				}
+			}
+			return true
+		}), nil
+	}
+	return false, nil
+}
+
+// compareRange compares a field value to a range bound, returning false if they can not be compared
+func (self *Server) compareRange(value interface{}, bound interface{}) (int, bool) {
+	bound_number, bound_ok := self.rangeNumber(bound)
+	value_number, value_ok := self.rangeNumber(value)
+	if bound_ok && value_ok {
+		return compareFloats(value_number, bound_number), true
+	}
+	value_string, value_is_string := value.(string)
+	bound_string, bound_is_string := bound.(string)
+	if value_is_string && bound_is_string {
+		return strings.Compare(value_string, bound_string), true
+	}
+	return 0, false
+}
+
+// rangeNumber converts numbers, numeric strings, dates and date math to a number. Dates are converted to epoch millis.
+func (self *Server) rangeNumber(value interface{}) (float64, bool) {
+	switch typed := value.(type) {
+		case float64:
+			return typed, true
+		case int64:
+			return float64(typed), true
+		case string:
+			if number, err := strconv.ParseFloat(typed, 64); err == nil {
+				return number, true
+			}
+			if date, ok := self.parseDate(typed); ok {
+				return float64(date.UnixNano() / int64(time.Millisecond)), true
+			}
+	}
+	return 0, false
+}
+
+// parseDate parses RFC3339 dates, yyyy-MM-dd dates and date math relative to the clock
+func (self *Server) parseDate(value string) (time.Time, bool) {
+	if match := dateMath.FindStringSubmatch(value); match != nil {
+		date := self.Clock.Now().UTC()
+		for _, offset := range dateOffset.FindAllStringSubmatch(match[1], -1) {
+			amount, _ := strconv.Atoi(offset[2])
+			if offset[1] == "-" {
+				amount = -amount
+			}
+			date = addUnit(date, amount, offset[3])
+		}
+		if match[3] != "" {
+			date = roundUnit(date, match[3][1:])
+		}
+		return date, true
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func addUnit(date time.Time, amount int, unit string) time.Time {
+	switch unit {
+		case "y":
+			return date.AddDate(amount, 0, 0)
+		case "M":
+			return date.AddDate(0, amount, 0)
+		case "w":
+			return date.AddDate(0, 0, 7 * amount)
+		case "d":
+			return date.AddDate(0, 0, amount)
+		case "h", "H":
+			return date.Add(time.Duration(amount) * time.Hour)
+		case "m":
+			return date.Add(time.Duration(amount) * time.Minute)
+	}
+	return date.Add(time.Duration(amount) * time.Second)
+}
+
+func roundUnit(date time.Time, unit string) time.Time {
+	switch unit {
+		case "y":
+			return time.Date(date.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		case "M":
+			return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+		case "w":
+			day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+			return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		case "d":
+			return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+		case "h", "H":
+			return date.Truncate(time.Hour)
+		case "m":
+			return date.Truncate(time.Minute)
+	}
+	return date.Truncate(time.Second)
+}
+
+// clauses returns the queries of a bool occurrence, which may be a single query or an array of queries
+func clauses(value interface{}) []map[string]interface{} {
+	switch typed := value.(type) {
+		case map[string]interface{}:
+			return []map[string]interface{}{typed}
+		case []interface{}:
+			queries := []map[string]interface{}{}
+			for i := range typed {
+				if query, ok := typed[i].(map[string]interface{}); ok {
+					queries = append(queries, query)
+				}
+			}
+			return queries
+	}
+	return nil
+}
+
+// fieldValue returns the field and value of a leaf query in either the short form {field: value} or the long form {field: {value: value}}
+func fieldValue(body map[string]interface{}, names ...string) (string, interface{}, error) {
+	for field := range body {
+		if options, ok := body[field].(map[string]interface{}); ok {
+			for _, name := range names {
+				if value, ok := options[name]; ok {
+					return field, value, nil
+				}
+			}
+			return field, nil, errors.New(fmt.Sprintf("query on [%s] is missing a value", field))
+		}
+		return field, body[field], nil
+	}
+	return "", nil, errors.New("query is missing a field")
+}
+
+// anyValue returns true if any of the values satisfy matches
+func anyValue(values []interface{}, matches func(interface{}) bool) bool {
+	for i := range values {
+		if matches(values[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// termEqual compares values the way a keyword or numeric field would, so 1 matches "1"
+func termEqual(value interface{}, term interface{}) bool {
+	if value == term {
+		return true
+	}
+	return fmt.Sprintf("%v", value) == fmt.Sprintf("%v", term)
+}
+
+// inSlice returns true if the document belongs to slice id of max slices
+func inSlice(document *document, slice map[string]interface{}) bool {
+	if slice == nil {
+		return true
+	}
+	id, _ := slice["id"].(float64)
+	max, _ := slice["max"].(float64)
+	if max <= 1 {
+		return true
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(document.id))
+	return int(hash.Sum32() % uint32(max)) == int(id)
+}
+
+// sortField is a single field of a search sort
+type sortField struct {
+	field string
+	descending bool
+	missing interface{}
+}
+
+// parseSort reads the sort of a search request. Each sort may be a field name, {field: order} or {field: {order: order, missing: missing}}.
+func parseSort(value interface{}) ([]sortField, error) {
+	items, ok := value.([]interface{})
+	if !ok && value != nil {
+		items = []interface{}{value}
+	}
+	fields := []sortField{}
+	for i := range items {
+		switch typed := items[i].(type) {
+			case string:
+				fields = append(fields, sortField{field: typed, descending: typed == "_score"})
+			case map[string]interface{}:
+				for field := range typed {
+					sort_field := sortField{field: field}
+					switch options := typed[field].(type) {
+						case string:
+							sort_field.descending = options == "desc"
+						case map[string]interface{}:
+							sort_field.descending = options["order"] == "desc"
+							sort_field.missing = options["missing"]
+						default:
+							return fields, errors.New(fmt.Sprintf("invalid sort on [%s]", field))
+					}
+					fields = append(fields, sort_field)
+				}
+			default:
+				return fields, errors.New("invalid sort")
+		}
+	}
+	return fields, nil
+}
+
+// sortValues returns the values of the sort fields of a document as returned in the sort field of a hit
+func sortValues(document *document, fields []sortField) []interface{} {
+	values := []interface{}{}
+	for _, field := range fields {
+		switch field.field {
+			case "_id":
+				values = append(values, document.id)
+			case "_doc", "_shard_doc":
+				values = append(values, float64(document.order))
+			case "_score":
+				values = append(values, float64(1))
+			default:
+				candidates := document.values(field.field)
+				if len(candidates) == 0 {
+					values = append(values, field.missing)
+					continue
+				}
+				// use the smallest value for ascending sorts and the largest for descending sorts like elasticsearch
+				value := candidates[0]
+				for i := 1; i < len(candidates); i++ {
+					if (compareSortValues(candidates[i], value) < 0) != field.descending {
+						value = candidates[i]
+					}
+				}
+				values = append(values, value)
+		}
+	}
+	return values
+}
+
+// compareSortValues orders numbers before strings and null values last
+func compareSortValues(left interface{}, right interface{}) int {
+	if left == nil || right == nil {
+		switch {
+			case left == nil && right == nil:
+				return 0
+			case left == nil:
+				return 1
+		}
+		return -1
+	}
+	left_number, left_ok := sortNumber(left)
+	right_number, right_ok := sortNumber(right)
+	switch {
+		case left_ok && right_ok:
+			return compareFloats(left_number, right_number)
+		case left_ok:
+			return -1
+		case right_ok:
+			return 1
+	}
+	return strings.Compare(fmt.Sprintf("%v", left), fmt.Sprintf("%v", right))
+}
+
+func sortNumber(value interface{}) (float64, bool) {
+	switch typed := value.(type) {
+		case float64:
+			return typed, true
+		case int64:
+			return float64(typed), true
+		case bool:
+			if typed {
+				return 1, true
+			}
+			return 0, true
+	}
+	return 0, false
+}
+
+// compareSorted compares two lists of sort values using the order of each sort field
+func compareSorted(left []interface{}, right []interface{}, fields []sortField) int {
+	for i := range fields {
+		if i >= len(left) || i >= len(right) {
+			break
+		}
+		comparison := compareSortValues(left[i], right[i])
+		if fields[i].descending && left[i] != nil && right[i] != nil {
+			comparison = -comparison
+		}
+		if comparison != 0 {
+			return comparison
+		}
+	}
+	return 0
+}
+
+// hit is a matching document and its sort values
+type hit struct {
+	document *document
+	sort []interface{}
+}
+
+// sortHits sorts hits by the sort fields, falling back to the order the documents were indexed in
+func sortHits(hits []hit, fields []sortField) {
+	sort.SliceStable(hits, func(i int, j int) bool {
+		comparison := compareSorted(hits[i].sort, hits[j].sort, fields)
+		if comparison != 0 {
+			return comparison < 0
+		}
+		return hits[i].document.order < hits[j].document.order
+	})
+}
+
+func compareFloats(left float64, right float64) int {
+	if left < right {
+		return -1
+	}
+	if left > right {
+		return 1
+	}
+	return 0
+}
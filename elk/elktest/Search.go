@@ -0,0 +1,496 @@
+package elktest
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk/painless"
+
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// scroll is the remaining hits of a scroll search
+type scroll struct {
+	hits []hit
+	size int
+	request map[string]interface{}
+}
+
+// searchEndpoint searches the indices in the url or the indices of the point in time in the body
+func (self *Server) searchEndpoint(index_name string, parameters url.Values, body []byte) (int, interface{}) {
+	request, err := decode(body)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	response, err := self.search(index_name, request, parameters.Get("scroll") != "")
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	return 200, response
+}
+
+// search executes a search request and returns the response, optionally opening a scroll over the remaining hits
+func (self *Server) search(index_name string, request map[string]interface{}, open_scroll bool) (Object, *esError) {
+	// a point in time determines the indices searched
+	pit_id := ""
+	if pit, ok := request["pit"].(map[string]interface{}); ok {
+		pit_id, _ = pit["id"].(string)
+		names, ok := self.pits[pit_id]
+		if !ok {
+			return nil, &esError{status: 404, kind: "search_context_missing_exception", reason: fmt.Sprintf("No search context found for id [%s]", pit_id)}
+		}
+		if index_name != "" {
+			return nil, badRequest("[indices] cannot be used with point in time")
+		}
+		index_name = ""
+		for i, name := range names {
+			if i > 0 {
+				index_name += ","
+			}
+			index_name += name
+		}
+		if index_name == "" {
+			index_name = "_none_"
+		}
+	}
+	indices, err := self.resolveIndices(index_name)
+	if err != nil {
+		return nil, err
+	}
+
+	hits, fields, err := self.findHits(indices, request)
+	if err != nil {
+		return nil, err
+	}
+	total := len(hits)
+	aggregations, err := aggregate(hits, request["aggregations"], request["aggs"])
+	if err != nil {
+		return nil, err
+	}
+
+	// skip hits before search_after and page through the rest
+	if after, ok := request["search_after"].([]interface{}); ok {
+		if len(fields) == 0 {
+			return nil, badRequest("search_after requires a sort")
+		}
+		remaining := []hit{}
+		for i := range hits {
+			if compareSorted(hits[i].sort, after, fields) > 0 {
+				remaining = append(remaining, hits[i])
+			}
+		}
+		hits = remaining
+	}
+	from := intValue(request["from"], 0)
+	size := intValue(request["size"], 10)
+	if from > len(hits) {
+		from = len(hits)
+	}
+	hits = hits[from:]
+	page := hits
+	if size < len(page) {
+		page = page[:size]
+	}
+
+	response := Object{
+		"took": 1,
+		"timed_out": false,
+		"_shards": shards(),
+		"hits": Object{
+			"total": Object{"value": total, "relation": "eq"},
+			"max_score": nil,
+			"hits": self.hitsResponse(page, fields, request),
+		},
+	}
+	if aggregations != nil {
+		response["aggregations"] = aggregations
+	}
+	if pit_id != "" {
+		response["pit_id"] = pit_id
+	}
+	if open_scroll {
+		self.next_id++
+		scroll_id := fmt.Sprintf("elktest-scroll-%d", self.next_id)
+		self.scrolls[scroll_id] = &scroll{hits: hits[len(page):], size: size, request: request}
+		response["_scroll_id"] = scroll_id
+	}
+	return response, nil
+}
+
+// findHits returns the sorted documents matching the query and slice of a search request and the sort fields
+func (self *Server) findHits(indices []*index, request map[string]interface{}) ([]hit, []sortField, *esError) {
+	query, _ := request["query"].(map[string]interface{})
+	slice, _ := request["slice"].(map[string]interface{})
+	fields, sort_err := parseSort(request["sort"])
+	if sort_err != nil {
+		return nil, nil, badRequest(sort_err.Error())
+	}
+
+	// _shard_doc was added in elasticsearch 7.12 and opensearch does not have it
+	version := self.versionInfo()
+	for _, field := range fields {
+		if field.field == "_shard_doc" && (version.IsOpenSearch() || !version.AtLeast(7, 12)) {
+			return nil, nil, badRequest("No mapping found for [_shard_doc] in order to sort on")
+		}
+	}
+	hits := []hit{}
+	for _, index := range indices {
+		for _, document := range index.documents {
+			if !inSlice(document, slice) {
+				continue
+			}
+			matched, err := self.matches(document, query)
+			if err != nil {
+				return nil, nil, &esError{status: 400, kind: "parsing_exception", reason: err.Error()}
+			}
+			if matched {
+				hits = append(hits, hit{document: document, sort: sortValues(document, fields)})
+			}
+		}
+	}
+	sortHits(hits, fields)
+	return hits, fields, nil
+}
+
+// hitsResponse returns the hits of a search response
+func (self *Server) hitsResponse(hits []hit, fields []sortField, request map[string]interface{}) Array {
+	response := Array{}
+	for _, hit := range hits {
+		item := Object{
+			"_index": hit.document.index,
+			"_type": self.docType(),
+			"_id": hit.document.id,
+			"_score": 1,
+		}
+		if filtered := filterSource(hit.document.source, request["_source"]); filtered != nil {
+			item["_source"] = copyValue(filtered)
+		}
+		if len(fields) > 0 {
+			item["sort"] = hit.sort
+			item["_score"] = nil
+		}
+		if request["version"] == true {
+			item["_version"] = hit.document.version
+		}
+		if request["seq_no_primary_term"] == true {
+			item["_seq_no"] = hit.document.seq_no
+			item["_primary_term"] = hit.document.primary_term
+		}
+		response = append(response, item)
+	}
+	return response
+}
+
+// count returns the number of documents matching the query
+func (self *Server) count(index_name string, body []byte) (int, interface{}) {
+	request, err := decode(body)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	indices, err := self.resolveIndices(index_name)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	hits, _, err := self.findHits(indices, Object{"query": request["query"]})
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	return 200, Object{"count": len(hits), "_shards": shards()}
+}
+
+// openPit opens a point in time. Searches with the point in time see changes made after it was opened.
+func (self *Server) openPit(index_name string, opensearch bool) (int, interface{}) {
+	indices, err := self.resolveIndices(index_name)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	names := []string{}
+	for _, index := range indices {
+		names = append(names, index.name)
+	}
+	self.next_id++
+	pit_id := fmt.Sprintf("elktest-pit-%d", self.next_id)
+	self.pits[pit_id] = names
+	if opensearch {
+		return 200, Object{"pit_id": pit_id, "_shards": shards(), "creation_time": self.Clock.Now().UnixNano() / 1000000}
+	}
+	return 200, Object{"id": pit_id}
+}
+
+// closePit closes a point in time using the elasticsearch or opensearch request format
+func (self *Server) closePit(body []byte, opensearch bool) (int, interface{}) {
+	request, err := decode(body)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	if opensearch {
+		ids, _ := request["pit_id"].([]interface{})
+		pits := Array{}
+		for i := range ids {
+			id, _ := ids[i].(string)
+			_, ok := self.pits[id]
+			delete(self.pits, id)
+			pits = append(pits, Object{"pit_id": id, "successful": ok})
+		}
+		return 200, Object{"pits": pits}
+	}
+	id, _ := request["id"].(string)
+	if _, ok := self.pits[id]; !ok {
+		return 404, Object{"succeeded": true, "num_freed": 0}
+	}
+	delete(self.pits, id)
+	return 200, Object{"succeeded": true, "num_freed": 1}
+}
+
+// scrollEndpoint returns the next page of a scroll or clears scrolls
+func (self *Server) scrollEndpoint(method string, body []byte) (int, interface{}) {
+	request, err := decode(body)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	if method == "DELETE" {
+		ids := patterns(request["scroll_id"])
+		freed := 0
+		for _, id := range ids {
+			if _, ok := self.scrolls[id]; ok {
+				freed++
+			}
+			delete(self.scrolls, id)
+		}
+		return 200, Object{"succeeded": true, "num_freed": freed}
+	}
+	id, _ := request["scroll_id"].(string)
+	context, ok := self.scrolls[id]
+	if !ok {
+		err := &esError{status: 404, kind: "search_context_missing_exception", reason: fmt.Sprintf("No search context found for id [%s]", id)}
+		return err.status, errorBody(err)
+	}
+	page := context.hits
+	if context.size < len(page) {
+		page = page[:context.size]
+	}
+	context.hits = context.hits[len(page):]
+	fields, _ := parseSort(context.request["sort"])
+	return 200, Object{
+		"_scroll_id": id,
+		"took": 1,
+		"timed_out": false,
+		"_shards": shards(),
+		"hits": Object{
+			"total": Object{"value": len(page) + len(context.hits), "relation": "eq"},
+			"max_score": nil,
+			"hits": self.hitsResponse(page, fields, context.request),
+		},
+	}
+}
+
+// multiSearch executes the searches or search templates of an msearch request, reporting failures per search
+func (self *Server) multiSearch(default_index string, body []byte, templates bool) (int, interface{}) {
+	lines, err := decodeLines(body)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	if len(lines) % 2 != 0 {
+		return 400, errorBody(badRequest("msearch requires a header and a body for each search"))
+	}
+	responses := Array{}
+	for i := 0; i < len(lines); i += 2 {
+		index_name := default_index
+		if value := patterns(lines[i]["index"]); len(value) > 0 {
+			index_name = ""
+			for j := range value {
+				if j > 0 {
+					index_name += ","
+				}
+				index_name += value[j]
+			}
+		}
+		request := lines[i+1]
+		if templates {
+			request, err = self.renderRequest(request)
+		}
+		var response Object
+		if err == nil {
+			response, err = self.search(index_name, request, false)
+		}
+		if err != nil {
+			responses = append(responses, Object{"error": err.cause(), "status": err.status})
+			err = nil
+			continue
+		}
+		response["status"] = 200
+		responses = append(responses, response)
+	}
+	return 200, Object{"took": 1, "responses": responses}
+}
+
+// searchTemplate renders a stored or inline search template and executes the search
+func (self *Server) searchTemplate(index_name string, body []byte) (int, interface{}) {
+	request, err := decode(body)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	if request, err = self.renderRequest(request); err != nil {
+		return err.status, errorBody(err)
+	}
+	response, err := self.search(index_name, request, false)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	return 200, response
+}
+
+// renderEndpoint renders a stored or inline search template without executing it
+func (self *Server) renderEndpoint(body []byte) (int, interface{}) {
+	request, err := decode(body)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	rendered, err := self.renderRequest(request)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	return 200, Object{"template_output": rendered}
+}
+
+// renderRequest renders the search template of a request with an id or source and params
+func (self *Server) renderRequest(request map[string]interface{}) (map[string]interface{}, *esError) {
+	source := request["source"]
+	if id, ok := request["id"].(string); ok {
+		stored, ok := self.scripts[id]
+		if !ok {
+			return nil, &esError{status: 404, kind: "resource_not_found_exception", reason: fmt.Sprintf("unable to find script [%s] in cluster state", id)}
+		}
+		if stored["lang"] != "mustache" {
+			return nil, badRequest(fmt.Sprintf("stored script [%s] is not a search template", id))
+		}
+		source = stored["source"]
+	}
+	template, ok := source.(string)
+	if !ok {
+		data, err := json.Marshal(source)
+		if err != nil || source == nil {
+			return nil, badRequest("search template must have a source or id")
+		}
+		template = string(data)
+	}
+	params, _ := request["params"].(map[string]interface{})
+	rendered, render_err := renderTemplate(template, params)
+	if render_err != nil {
+		return nil, &esError{status: 400, kind: "general_script_exception", reason: render_err.Error()}
+	}
+	output, err := decode([]byte(rendered))
+	if err != nil {
+		return nil, &esError{status: 400, kind: "parsing_exception", reason: fmt.Sprintf("rendered template is not valid json: %s", err.reason)}
+	}
+	return output, nil
+}
+
+// scriptEndpoint stores, returns and deletes painless scripts and mustache search templates
+func (self *Server) scriptEndpoint(method string, id string, body []byte) (int, interface{}) {
+	switch method {
+		case "PUT", "POST":
+			request, err := decode(body)
+			if err != nil {
+				return err.status, errorBody(err)
+			}
+			script, ok := request["script"].(map[string]interface{})
+			if !ok || script["source"] == nil {
+				return 400, errorBody(badRequest("must specify a script with a source"))
+			}
+			if script["lang"] == "painless" {
+				source, _ := script["source"].(string)
+				if _, parse_err := painless.Compile(source); parse_err != nil {
+					compile_err := &esError{status: 400, kind: "script_exception", reason: "compile error", caused_by: &esError{kind: "illegal_argument_exception", reason: parse_err.Error()}}
+					return 400, errorBody(compile_err)
+				}
+			}
+			self.scripts[id] = Object{"lang": script["lang"], "source": script["source"]}
+			return 200, acknowledged()
+		case "GET":
+			script, ok := self.scripts[id]
+			if !ok {
+				return 404, Object{"_id": id, "found": false}
+			}
+			return 200, Object{"_id": id, "found": true, "script": script}
+		case "DELETE":
+			if _, ok := self.scripts[id]; !ok {
+				err := &esError{status: 404, kind: "resource_not_found_exception", reason: fmt.Sprintf("stored script [%s] does not exist and cannot be deleted", id)}
+				return err.status, errorBody(err)
+			}
+			delete(self.scripts, id)
+			return 200, acknowledged()
+	}
+	return unsupported(method, []string{"_scripts", id})
+}
+
+// aggregate computes the terms aggregations of a search request
+func aggregate(hits []hit, aggregations ...interface{}) (Object, *esError) {
+	var results Object
+	for _, requested := range aggregations {
+		named, ok := requested.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if results == nil {
+			results = Object{}
+		}
+		for name := range named {
+			aggregation, _ := named[name].(map[string]interface{})
+			terms, ok := aggregation["terms"].(map[string]interface{})
+			if !ok {
+				return nil, badRequest(fmt.Sprintf("elktest only supports terms aggregations, [%s] is not one", name))
+			}
+			results[name] = termsAggregation(hits, terms)
+		}
+	}
+	return results, nil
+}
+
+// termsAggregation counts documents by each value of a field, ordered by count and then by key
+func termsAggregation(hits []hit, terms map[string]interface{}) Object {
+	field, _ := terms["field"].(string)
+	counts := map[string]int{}
+	keys := map[string]interface{}{}
+	for _, hit := range hits {
+		seen := map[string]bool{}
+		for _, value := range hit.document.values(field) {
+			key := fmt.Sprintf("%v", value)
+			if !seen[key] {
+				seen[key] = true
+				counts[key]++
+				keys[key] = value
+			}
+		}
+	}
+	names := []string{}
+	for key := range counts {
+		names = append(names, key)
+	}
+	sort.Slice(names, func(i int, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	size := intValue(terms["size"], 10)
+	other := 0
+	buckets := Array{}
+	for i, key := range names {
+		if i >= size {
+			other += counts[key]
+			continue
+		}
+		buckets = append(buckets, Object{"key": keys[key], "doc_count": counts[key]})
+	}
+	return Object{"doc_count_error_upper_bound": 0, "sum_other_doc_count": other, "buckets": buckets}
+}
+
+// intValue returns a json number as an int or fallback if it is not a number
+func intValue(value interface{}, fallback int) int {
+	if number, ok := value.(float64); ok {
+		return int(number)
+	}
+	return fallback
+}
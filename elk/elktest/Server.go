@@ -0,0 +1,544 @@
+// Package elktest provides an in memory fake of the elasticsearch endpoints used by the elk package so that
+// code built on elk.Client, such as JobPipeline, can be tested without a cluster.
+package elktest
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultVersion is the elasticsearch version reported by the fake server
+const DefaultVersion = "7.17.0"
+
+// Server is an httptest server that fakes an elasticsearch cluster
+// Clock field resolves now in range queries and ctx._now in scripts so lock_until and expires_at semantics are deterministic
+// Version field is the version reported by GET /, versions before 7 use the doc type and versions before 7.12 use scroll instead of point in time
+// Distribution field is reported by GET /, set it to elk.DISTRIBUTION_OPENSEARCH to fake opensearch
+// Health field is the status reported by _cluster/health, empty is green. Waiting for a better status times out immediately.
+// HoldTasks field keeps requests with wait_for_completion=false running without doing anything until they are cancelled
+// Supported endpoints are documents, _update, _bulk, _mget, _search, _count, point in time, scroll, _msearch, search templates,
+// _update_by_query, _delete_by_query, _tasks, _scripts, index creation, _settings and _mapping. Other requests fail with a 400.
+// Search supports the bool, term, terms, match, range, exists, ids, prefix and wildcard queries, sort, search_after, slice and terms aggregations.
+// Scripts support the subset of painless generated by elk.Update and JobPipeline. Changes are visible to searches immediately.
+type Server struct {
+	*httptest.Server
+	Clock *Clock
+	Version string
+	Distribution string
+	Health string
+	HoldTasks bool
+
+	lock sync.Mutex
+	indices map[string]*index
+	scripts map[string]Object
+	pits map[string][]string
+	scrolls map[string]*scroll
+	tasks map[string]Object
+	next_id int
+	next_order int
+}
+
+// index is a fake index
+type index struct {
+	name string
+	documents map[string]*document
+	settings Object
+	mappings Object
+	seq_no int
+}
+
+// document is a fake document
+type document struct {
+	index string
+	id string
+	source map[string]interface{}
+	version int
+	seq_no int
+	primary_term int
+	order int
+}
+
+// esError is an elasticsearch error response
+type esError struct {
+	status int
+	kind string
+	reason string
+	index string
+	caused_by *esError
+}
+
+// NewServer starts a fake elasticsearch server with a clock frozen at the current time. Call Close when done.
+func NewServer() *Server {
+	server := &Server{
+		Clock: NewClock(time.Now()),
+		Version: DefaultVersion,
+		indices: map[string]*index{},
+		scripts: map[string]Object{},
+		pits: map[string][]string{},
+		scrolls: map[string]*scroll{},
+		tasks: map[string]Object{},
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serve))
+	return server
+}
+
+// clusterHealth reports the Health of the cluster, responding with a 408 if wait_for_status is better than Health
+func (self *Server) clusterHealth(parameters url.Values) (int, interface{}) {
+	health := self.Health
+	if health == "" {
+		health = elk.HEALTH_GREEN
+	}
+	ranks := map[string]int{elk.HEALTH_RED: 1, elk.HEALTH_YELLOW: 2, elk.HEALTH_GREEN: 3}
+	wait_for_status := parameters.Get("wait_for_status")
+	timed_out := wait_for_status != "" && ranks[wait_for_status] > ranks[health]
+	response := Object{"cluster_name": "elktest", "status": health, "timed_out": timed_out, "number_of_nodes": 1, "number_of_data_nodes": 1}
+	if timed_out {
+		return 408, response
+	}
+	return 200, response
+}
+
+// NewClient returns an elk client that sends requests to the server
+func (self *Server) NewClient() *elk.Client {
+	return &elk.Client{BaseUrl: self.URL, HttpClient: self.Server.Client()}
+}
+
+// Put indexes a document directly, creating the index if it does not exist
+func (self *Server) Put(index_name string, id string, source interface{}) error {
+	data, err := json.Marshal(source)
+	if err != nil {
+		return err
+	}
+	decoded := map[string]interface{}{}
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.write(self.ensureIndex(index_name), id, decoded)
+	return nil
+}
+
+// Get returns a copy of the source of a document and whether it exists
+func (self *Server) Get(index_name string, id string) (Object, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if index, ok := self.indices[index_name]; ok {
+		if document, ok := index.documents[id]; ok {
+			return Object(copyValue(document.source).(map[string]interface{})), true
+		}
+	}
+	return nil, false
+}
+
+// Documents returns a copy of the source of every document in an index by id
+func (self *Server) Documents(index_name string) map[string]Object {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	documents := map[string]Object{}
+	if index, ok := self.indices[index_name]; ok {
+		for id := range index.documents {
+			documents[id] = Object(copyValue(index.documents[id].source).(map[string]interface{}))
+		}
+	}
+	return documents
+}
+
+// SetSetting sets an index setting such as index.refresh_interval, creating the index if it does not exist
+func (self *Server) SetSetting(index_name string, name string, value interface{}) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.ensureIndex(index_name).settings[name] = value
+}
+
+// serve routes a request to the handler of its endpoint
+func (self *Server) serve(writer http.ResponseWriter, request *http.Request) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		self.respond(writer, request, 400, errorBody(&esError{status: 400, kind: "parse_exception", reason: err.Error()}))
+		return
+	}
+	parameters := request.URL.Query()
+	parts := []string{}
+	if trimmed := strings.Trim(request.URL.Path, "/"); trimmed != "" {
+		parts = strings.Split(trimmed, "/")
+	}
+
+	status, response := self.route(request.Method, parts, parameters, body)
+	self.respond(writer, request, status, response)
+}
+
+// route calls the handler of the endpoint and returns the status code and response body
+func (self *Server) route(method string, parts []string, parameters url.Values, body []byte) (int, interface{}) {
+	// cluster endpoints
+	if len(parts) == 0 {
+		if method == "GET" || method == "HEAD" {
+			return 200, self.info()
+		}
+		return unsupported(method, parts)
+	}
+	if strings.HasPrefix(parts[0], "_") {
+		switch {
+			case parts[0] == "_bulk" && len(parts) == 1:
+				return self.bulk("", body)
+			case parts[0] == "_mget" && len(parts) == 1:
+				return self.multiGet("", body)
+			case parts[0] == "_msearch" && len(parts) == 1:
+				return self.multiSearch("", body, false)
+			case parts[0] == "_msearch" && len(parts) == 2 && parts[1] == "template":
+				return self.multiSearch("", body, true)
+			case parts[0] == "_search" && len(parts) == 1:
+				return self.searchEndpoint("", parameters, body)
+			case parts[0] == "_search" && len(parts) == 2 && parts[1] == "scroll":
+				return self.scrollEndpoint(method, body)
+			case parts[0] == "_search" && len(parts) == 2 && parts[1] == "point_in_time" && method == "DELETE":
+				return self.closePit(body, true)
+			case parts[0] == "_search" && len(parts) == 2 && parts[1] == "template":
+				return self.searchTemplate("", body)
+			case parts[0] == "_pit" && len(parts) == 1 && method == "DELETE":
+				return self.closePit(body, false)
+			case parts[0] == "_tasks" && len(parts) == 2 && method == "GET":
+				return self.getTask(parts[1])
+			case parts[0] == "_tasks" && len(parts) == 3 && parts[2] == "_cancel":
+				return self.cancelTask(parts[1])
+			case parts[0] == "_scripts" && len(parts) == 2:
+				return self.scriptEndpoint(method, parts[1], body)
+			case parts[0] == "_render" && len(parts) == 2 && parts[1] == "template":
+				return self.renderEndpoint(body)
+			case parts[0] == "_cluster" && len(parts) == 2 && parts[1] == "health":
+				return self.clusterHealth(parameters)
+			case parts[0] == "_refresh":
+				return 200, Object{"_shards": shards()}
+		}
+		return unsupported(method, parts)
+	}
+
+	// task results are stored in the .tasks index
+	if parts[0] == ".tasks" && len(parts) == 3 && method == "DELETE" {
+		return self.deleteTaskResult(parts[2])
+	}
+
+	// index endpoints
+	index_name := parts[0]
+	if len(parts) == 1 {
+		return self.indexEndpoint(method, index_name, body)
+	}
+	switch parts[1] {
+		case "_search":
+			switch {
+				case len(parts) == 2:
+					return self.searchEndpoint(index_name, parameters, body)
+				case len(parts) == 3 && parts[2] == "point_in_time" && method == "POST":
+					return self.openPit(index_name, true)
+				case len(parts) == 3 && parts[2] == "template":
+					return self.searchTemplate(index_name, body)
+			}
+		case "_pit":
+			if len(parts) == 2 && method == "POST" {
+				return self.openPit(index_name, false)
+			}
+		case "_count":
+			if len(parts) == 2 {
+				return self.count(index_name, body)
+			}
+		case "_msearch":
+			if len(parts) == 2 {
+				return self.multiSearch(index_name, body, false)
+			}
+			if len(parts) == 3 && parts[2] == "template" {
+				return self.multiSearch(index_name, body, true)
+			}
+		case "_bulk":
+			if len(parts) == 2 {
+				return self.bulk(index_name, body)
+			}
+		case "_mget":
+			if len(parts) == 2 {
+				return self.multiGet(index_name, body)
+			}
+		case "_update_by_query", "_delete_by_query":
+			if len(parts) == 2 && method == "POST" {
+				return self.byQuery(index_name, parts[1] == "_delete_by_query", parameters, body)
+			}
+		case "_settings":
+			if len(parts) <= 3 {
+				return self.settingsEndpoint(method, index_name, strings.Join(parts[2:], ""), parameters, body)
+			}
+		case "_mapping":
+			if len(parts) <= 3 {
+				return self.mappingEndpoint(method, index_name, body)
+			}
+		case "_refresh":
+			return 200, Object{"_shards": shards()}
+		case "_update":
+			if len(parts) == 3 && method == "POST" {
+				return self.updateEndpoint(index_name, parts[2], parameters, body)
+			}
+		case "_create":
+			if len(parts) == 3 && (method == "PUT" || method == "POST") {
+				parameters.Set("op_type", "create")
+				return self.documentEndpoint("PUT", index_name, parts[2], parameters, body)
+			}
+		default:
+			// documents are addressed by _doc or by a mapping type on elasticsearch 6
+			if strings.HasPrefix(parts[1], "_") && parts[1] != "_doc" {
+				break
+			}
+			switch {
+				case len(parts) == 2 && method == "POST":
+					self.next_id++
+					return self.documentEndpoint("PUT", index_name, fmt.Sprintf("elktest-%d", self.next_id), parameters, body)
+				case len(parts) == 3:
+					return self.documentEndpoint(method, index_name, parts[2], parameters, body)
+				case len(parts) == 4 && parts[3] == "_update" && method == "POST":
+					return self.updateEndpoint(index_name, parts[2], parameters, body)
+			}
+	}
+	return unsupported(method, parts)
+}
+
+// respond writes the status code and json body of a response, HEAD responses have no body
+func (self *Server) respond(writer http.ResponseWriter, request *http.Request, status int, response interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if request.Method == "HEAD" || response == nil {
+		return
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		data, _ = json.Marshal(errorBody(&esError{status: 500, kind: "exception", reason: err.Error()}))
+	}
+	writer.Write(data)
+}
+
+// info returns the response of GET /
+func (self *Server) info() Object {
+	version := Object{"number": self.Version, "build_flavor": "default", "lucene_version": "8.11.1"}
+	if self.Distribution != "" {
+		version["distribution"] = self.Distribution
+	}
+	return Object{
+		"name": "elktest",
+		"cluster_name": "elktest",
+		"cluster_uuid": "elktest",
+		"version": version,
+		"tagline": "You Know, for Search",
+	}
+}
+
+// versionInfo returns the version the server reports
+func (self *Server) versionInfo() *elk.VersionInfo {
+	return &elk.VersionInfo{Number: self.Version, Distribution: self.Distribution}
+}
+
+// docType returns the type reported in responses, doc on elasticsearch 6 and _doc on later versions
+func (self *Server) docType() string {
+	if self.versionInfo().Typeless() {
+		return "_doc"
+	}
+	return "doc"
+}
+
+// ensureIndex returns the index with name, creating it if it does not exist
+func (self *Server) ensureIndex(name string) *index {
+	if existing, ok := self.indices[name]; ok {
+		return existing
+	}
+	created := &index{
+		name: name,
+		documents: map[string]*document{},
+		settings: Object{
+			"index.number_of_shards": "1",
+			"index.number_of_replicas": "1",
+			"index.provided_name": name,
+			"index.uuid": fmt.Sprintf("elktest-%s", name),
+			"index.creation_date": elk.Timestamp(self.Clock.Now()),
+		},
+		mappings: Object{},
+	}
+	self.indices[name] = created
+	return created
+}
+
+// resolveIndices returns the indices matching a comma separated list of names and wildcard patterns sorted by name
+// An index_not_found_exception is returned if a name without wildcards does not exist
+func (self *Server) resolveIndices(pattern string) ([]*index, *esError) {
+	matched := map[string]*index{}
+	for _, name := range strings.Split(pattern, ",") {
+		if name == "_all" || name == "" {
+			name = "*"
+		}
+		if !strings.ContainsAny(name, "*?") {
+			existing, ok := self.indices[name]
+			if !ok {
+				return nil, indexNotFound(name)
+			}
+			matched[name] = existing
+			continue
+		}
+		for existing := range self.indices {
+			if ok, _ := path.Match(name, existing); ok {
+				matched[existing] = self.indices[existing]
+			}
+		}
+	}
+	names := []string{}
+	for name := range matched {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	indices := []*index{}
+	for _, name := range names {
+		indices = append(indices, matched[name])
+	}
+	return indices, nil
+}
+
+// values returns the values of a dotted field, flattening arrays. The _id and _index fields refer to the metadata of the document.
+func (self *document) values(field string) []interface{} {
+	switch field {
+		case "_id":
+			return []interface{}{self.id}
+		case "_index":
+			return []interface{}{self.index}
+	}
+	values := []interface{}{self.source}
+	for _, part := range strings.Split(field, ".") {
+		next := []interface{}{}
+		for _, value := range values {
+			if object, ok := value.(map[string]interface{}); ok {
+				if child, ok := object[part]; ok && child != nil {
+					next = append(next, flatten(child)...)
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// flatten returns the items of nested arrays or the value itself
+func flatten(value interface{}) []interface{} {
+	items, ok := value.([]interface{})
+	if !ok {
+		return []interface{}{value}
+	}
+	flattened := []interface{}{}
+	for i := range items {
+		if items[i] != nil {
+			flattened = append(flattened, flatten(items[i])...)
+		}
+	}
+	return flattened
+}
+
+// copyValue deep copies json maps and arrays
+func copyValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+		case map[string]interface{}:
+			copied := make(map[string]interface{}, len(typed))
+			for key := range typed {
+				copied[key] = copyValue(typed[key])
+			}
+			return copied
+		case []interface{}:
+			copied := make([]interface{}, len(typed))
+			for i := range typed {
+				copied[i] = copyValue(typed[i])
+			}
+			return copied
+	}
+	return value
+}
+
+// decode unmarshals a json request body, an empty body decodes to an empty object
+func decode(body []byte) (map[string]interface{}, *esError) {
+	decoded := map[string]interface{}{}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return decoded, nil
+	}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return nil, &esError{status: 400, kind: "parse_exception", reason: err.Error()}
+	}
+	return decoded, nil
+}
+
+// decodeLines unmarshals the json objects of an ndjson request body
+func decodeLines(body []byte) ([]map[string]interface{}, *esError) {
+	lines := []map[string]interface{}{}
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		decoded, err := decode(line)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, decoded)
+	}
+	return lines, nil
+}
+
+// shards returns the _shards section of a successful response
+func shards() Object {
+	return Object{"total": 1, "successful": 1, "skipped": 0, "failed": 0}
+}
+
+// acknowledged returns the response of a successful index level request
+func acknowledged() Object {
+	return Object{"acknowledged": true}
+}
+
+// Error returns the reason of the error
+func (self *esError) Error() string {
+	return fmt.Sprintf("%s: %s", self.kind, self.reason)
+}
+
+// cause returns the error object used in error responses and bulk items
+func (self *esError) cause() Object {
+	cause := Object{"type": self.kind, "reason": self.reason}
+	if self.index != "" {
+		cause["index"] = self.index
+	}
+	if self.caused_by != nil {
+		cause["caused_by"] = self.caused_by.cause()
+	}
+	return cause
+}
+
+// errorBody returns the body of an error response
+func errorBody(err *esError) Object {
+	cause := err.cause()
+	root := Object{"type": err.kind, "reason": err.reason}
+	cause["root_cause"] = Array{root}
+	return Object{"error": cause, "status": err.status}
+}
+
+func unsupported(method string, parts []string) (int, interface{}) {
+	reason := fmt.Sprintf("elktest does not support %s /%s", method, strings.Join(parts, "/"))
+	return 400, errorBody(&esError{status: 400, kind: "illegal_argument_exception", reason: reason})
+}
+
+func indexNotFound(name string) *esError {
+	return &esError{status: 404, kind: "index_not_found_exception", reason: fmt.Sprintf("no such index [%s]", name), index: name}
+}
+
+func badRequest(reason string) *esError {
+	return &esError{status: 400, kind: "illegal_argument_exception", reason: reason}
+}
@@ -0,0 +1,187 @@
+package elktest
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// byQuery runs an update by query or delete by query. Requests with wait_for_completion=false run immediately and are stored as completed tasks
+// unless HoldTasks is set, in which case they are stored as running tasks that never run.
+func (self *Server) byQuery(index_name string, delete_matches bool, parameters url.Values, body []byte) (int, interface{}) {
+	request, err := decode(body)
+	if err != nil {
+		return err.status, errorBody(err)
+	}
+	held := self.HoldTasks && parameters.Get("wait_for_completion") == "false"
+	response := Object{}
+	if !held {
+		response, err = self.runByQuery(index_name, delete_matches, request)
+	}
+	if parameters.Get("wait_for_completion") != "false" {
+		if err != nil {
+			return err.status, errorBody(err)
+		}
+		return 200, response
+	}
+
+	// store the outcome as a completed task
+	action := "indices:data/write/update/byquery"
+	if delete_matches {
+		action = "indices:data/write/delete/byquery"
+	}
+	self.next_id++
+	task_id := fmt.Sprintf("elktest:%d", self.next_id)
+	task := Object{
+		"node": "elktest",
+		"id": self.next_id,
+		"type": "transport",
+		"action": action,
+		"description": fmt.Sprintf("%s [%s]", action, index_name),
+		"start_time_in_millis": self.Clock.Now().UnixNano() / 1000000,
+		"running_time_in_nanos": 0,
+		"cancellable": true,
+	}
+	stored := Object{"completed": !held, "task": task}
+	if held {
+		task["status"] = taskStatus(byQueryResponse(0, 0, 0, 0))
+	} else if err != nil {
+		stored["error"] = err.cause()
+	} else {
+		task["status"] = taskStatus(response)
+		stored["response"] = response
+	}
+	self.tasks[task_id] = stored
+	return 200, Object{"task": task_id}
+}
+
+// runByQuery updates or deletes every document matching the query and slice of the request
+func (self *Server) runByQuery(index_name string, delete_matches bool, request map[string]interface{}) (Object, *esError) {
+	indices, err := self.resolveIndices(index_name)
+	if err != nil {
+		return nil, err
+	}
+	search := Object{"query": request["query"], "slice": request["slice"]}
+	hits, _, err := self.findHits(indices, search)
+	if err != nil {
+		return nil, err
+	}
+	if max_docs := intValue(request["max_docs"], -1); max_docs >= 0 && max_docs < len(hits) {
+		hits = hits[:max_docs]
+	}
+
+	updated, deleted, noops := 0, 0, 0
+	for _, hit := range hits {
+		index := self.indices[hit.document.index]
+		if delete_matches {
+			self.remove(index, hit.document)
+			deleted++
+			continue
+		}
+		script, ok := request["script"]
+		if !ok {
+			self.write(index, hit.document.id, hit.document.source)
+			updated++
+			continue
+		}
+		operation, source, err := self.runUpdateScript(index.name, hit.document.id, copyValue(hit.document.source).(map[string]interface{}), script, "index")
+		if err != nil {
+			return nil, err
+		}
+		switch operation {
+			case "delete":
+				self.remove(index, hit.document)
+				deleted++
+			case "none", "noop":
+				noops++
+			default:
+				self.write(index, hit.document.id, source)
+				updated++
+		}
+	}
+
+	return byQueryResponse(len(hits), updated, deleted, noops), nil
+}
+
+// byQueryResponse returns the response of a by query request that matched total documents
+func byQueryResponse(total int, updated int, deleted int, noops int) Object {
+	batches := 0
+	if total > 0 {
+		batches = 1
+	}
+	return Object{
+		"took": 1,
+		"timed_out": false,
+		"total": total,
+		"updated": updated,
+		"created": 0,
+		"deleted": deleted,
+		"batches": batches,
+		"version_conflicts": 0,
+		"noops": noops,
+		"retries": Object{"bulk": 0, "search": 0},
+		"throttled_millis": 0,
+		"requests_per_second": -1,
+		"throttled_until_millis": 0,
+		"failures": Array{},
+	}
+}
+
+// taskStatus returns the status of a completed by query task
+func taskStatus(response Object) Object {
+	status := Object{}
+	for _, key := range []string{"total", "updated", "created", "deleted", "batches", "version_conflicts", "noops", "retries", "throttled_millis", "requests_per_second", "throttled_until_millis"} {
+		status[key] = response[key]
+	}
+	return status
+}
+
+// getTask returns a stored task
+func (self *Server) getTask(id string) (int, interface{}) {
+	task, ok := self.tasks[id]
+	if !ok {
+		err := &esError{status: 404, kind: "resource_not_found_exception", reason: fmt.Sprintf("task [%s] isn't running and hasn't stored its results", id)}
+		return err.status, errorBody(err)
+	}
+	return 200, task
+}
+
+// cancelTask cancels a held task, which completes it and stores its result the way a cancelled by query task does
+func (self *Server) cancelTask(id string) (int, interface{}) {
+	stored, ok := self.tasks[id]
+	if !ok {
+		err := &esError{status: 404, kind: "resource_not_found_exception", reason: fmt.Sprintf("task [%s] is not found", id)}
+		return err.status, errorBody(err)
+	}
+	if stored["completed"] == false {
+		response := byQueryResponse(0, 0, 0, 0)
+		response["canceled"] = "by user request"
+		stored["completed"] = true
+		stored["response"] = response
+	}
+	return 200, Object{"nodes": Object{}}
+}
+
+// deleteTaskResult removes a stored task result the way deleting its document from the .tasks index does
+// Running tasks have not stored a result yet so there is nothing to delete.
+func (self *Server) deleteTaskResult(id string) (int, interface{}) {
+	if stored, ok := self.tasks[id]; !ok || stored["completed"] == false {
+		return 404, Object{"_index": ".tasks", "_id": id, "result": "not_found", "_shards": shards()}
+	}
+	delete(self.tasks, id)
+	return 200, Object{"_index": ".tasks", "_id": id, "result": "deleted", "_shards": shards()}
+}
+
+// TaskResults returns the ids of the task results stored by requests with wait_for_completion=false that have not been deleted
+func (self *Server) TaskResults() []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	ids := []string{}
+	for id := range self.tasks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,943 @@
+package painless
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type scope struct {
+	variables map[string]interface{}
+	parent *scope
+}
+
+func (self *scope) lookup(name string) (*scope, bool) {
+	for current := self; current != nil; current = current.parent {
+		if _, ok := current.variables[name]; ok {
+			return current, true
+		}
+	}
+	return nil, false
+}
+
+type environment struct {
+	scope *scope
+	returned bool
+	return_value interface{}
+	iterations int
+}
+
+func (self *environment) push() {
+	self.scope = &scope{variables: map[string]interface{}{}, parent: self.scope}
+}
+
+func (self *environment) pop() {
+	self.scope = self.scope.parent
+}
+
+func (self *environment) loop() error {
+	self.iterations++
+	if self.iterations > maxLoopIterations {
+		return errors.New("too many loop iterations")
+	}
+	return nil
+}
+
+type statement interface {
+	exec(env *environment) (interface{}, error)
+}
+
+type expression interface {
+	eval(env *environment) (interface{}, error)
+}
+
+type assignable interface {
+	expression
+	assign(env *environment, value interface{}) error
+}
+
+type blockStatement struct {
+	statements []statement
+}
+
+func (self *blockStatement) exec(env *environment) (interface{}, error) {
+	env.push()
+	defer env.pop()
+	for i := range self.statements {
+		if _, err := self.statements[i].exec(env); err != nil {
+			return nil, err
+		}
+		if env.returned {
+			break
+		}
+	}
+	return nil, nil
+}
+
+type declareStatement struct {
+	name string
+	value expression
+}
+
+func (self *declareStatement) exec(env *environment) (interface{}, error) {
+	var value interface{}
+	if self.value != nil {
+		var err error
+		if value, err = self.value.eval(env); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := env.scope.lookup(self.name); ok {
+		return nil, errors.New(fmt.Sprintf("variable [%s] is already defined", self.name))
+	}
+	env.scope.variables[self.name] = value
+	return nil, nil
+}
+
+type expressionStatement struct {
+	value expression
+}
+
+func (self *expressionStatement) exec(env *environment) (interface{}, error) {
+	return self.value.eval(env)
+}
+
+type returnStatement struct {
+	value expression
+}
+
+func (self *returnStatement) exec(env *environment) (interface{}, error) {
+	env.returned = true
+	if self.value != nil {
+		value, err := self.value.eval(env)
+		env.return_value = value
+		return value, err
+	}
+	return nil, nil
+}
+
+type ifStatement struct {
+	condition expression
+	then statement
+	otherwise statement
+}
+
+func (self *ifStatement) exec(env *environment) (interface{}, error) {
+	condition, err := evalBool(env, self.condition)
+	if err != nil {
+		return nil, err
+	}
+	if condition {
+		return self.then.exec(env)
+	}
+	if self.otherwise != nil {
+		return self.otherwise.exec(env)
+	}
+	return nil, nil
+}
+
+type forStatement struct {
+	initialize statement
+	condition expression
+	update expression
+	body statement
+}
+
+func (self *forStatement) exec(env *environment) (interface{}, error) {
+	env.push()
+	defer env.pop()
+	if self.initialize != nil {
+		if _, err := self.initialize.exec(env); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		if err := env.loop(); err != nil {
+			return nil, err
+		}
+		if self.condition != nil {
+			condition, err := evalBool(env, self.condition)
+			if err != nil {
+				return nil, err
+			}
+			if !condition {
+				return nil, nil
+			}
+		}
+		if _, err := self.body.exec(env); err != nil {
+			return nil, err
+		}
+		if env.returned {
+			return nil, nil
+		}
+		if self.update != nil {
+			if _, err := self.update.eval(env); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+type forEachStatement struct {
+	name string
+	collection expression
+	body statement
+}
+
+func (self *forEachStatement) exec(env *environment) (interface{}, error) {
+	collection, err := self.collection.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	items := []interface{}{}
+	switch typed := collection.(type) {
+		case *list:
+			items = append(items, typed.items...)
+		case map[string]interface{}:
+			for key := range typed {
+				items = append(items, key)
+			}
+		default:
+			return nil, errors.New(fmt.Sprintf("cannot iterate over %s", typeName(collection)))
+	}
+	for _, item := range items {
+		if err := env.loop(); err != nil {
+			return nil, err
+		}
+		env.push()
+		env.scope.variables[self.name] = item
+		_, err := self.body.exec(env)
+		env.pop()
+		if err != nil {
+			return nil, err
+		}
+		if env.returned {
+			break
+		}
+	}
+	return nil, nil
+}
+
+type literalExpression struct {
+	value interface{}
+}
+
+func (self *literalExpression) eval(env *environment) (interface{}, error) {
+	return self.value, nil
+}
+
+type variableExpression struct {
+	name string
+}
+
+func (self *variableExpression) eval(env *environment) (interface{}, error) {
+	if self.name == "Math" {
+		return mathClass{}, nil
+	}
+	scope, ok := env.scope.lookup(self.name)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("cannot resolve symbol [%s]", self.name))
+	}
+	return scope.variables[self.name], nil
+}
+
+func (self *variableExpression) assign(env *environment, value interface{}) error {
+	scope, ok := env.scope.lookup(self.name)
+	if !ok {
+		return errors.New(fmt.Sprintf("cannot resolve symbol [%s]", self.name))
+	}
+	scope.variables[self.name] = value
+	return nil
+}
+
+type memberExpression struct {
+	target expression
+	key expression
+	brackets bool
+	null_safe bool
+}
+
+func (self *memberExpression) eval(env *environment) (interface{}, error) {
+	target, err := self.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil && self.null_safe {
+		return nil, nil
+	}
+	key, err := self.key.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch typed := target.(type) {
+		case map[string]interface{}:
+			name, ok := key.(string)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("map key must be a string, not %s", typeName(key)))
+			}
+			return typed[name], nil
+		case *list:
+			index, err := listIndex(typed, key)
+			if err != nil {
+				return nil, err
+			}
+			return typed.items[index], nil
+		case nil:
+			return nil, errors.New(fmt.Sprintf("null pointer exception accessing [%v]", key))
+		default:
+			return nil, errors.New(fmt.Sprintf("dynamic getter [%s, %v] not found", typeName(target), key))
+	}
+}
+
+func (self *memberExpression) assign(env *environment, value interface{}) error {
+	target, err := self.target.eval(env)
+	if err != nil {
+		return err
+	}
+	key, err := self.key.eval(env)
+	if err != nil {
+		return err
+	}
+	switch typed := target.(type) {
+		case map[string]interface{}:
+			name, ok := key.(string)
+			if !ok {
+				return errors.New(fmt.Sprintf("map key must be a string, not %s", typeName(key)))
+			}
+			typed[name] = value
+			return nil
+		case *list:
+			index, err := listIndex(typed, key)
+			if err != nil {
+				return err
+			}
+			typed.items[index] = value
+			return nil
+		case nil:
+			return errors.New(fmt.Sprintf("null pointer exception assigning [%v]", key))
+		default:
+			return errors.New(fmt.Sprintf("dynamic setter [%s, %v] not found", typeName(target), key))
+	}
+}
+
+// listIndex converts key to a valid index of items
+func listIndex(items *list, key interface{}) (int, error) {
+	var index int64
+	switch typed := key.(type) {
+		case int64:
+			index = typed
+		case string:
+			parsed, err := strconv.ParseInt(typed, 10, 64)
+			if err != nil {
+				return 0, errors.New(fmt.Sprintf("dynamic getter [ArrayList, %s] not found", typed))
+			}
+			index = parsed
+		default:
+			return 0, errors.New(fmt.Sprintf("list index must be an integer, not %s", typeName(key)))
+	}
+	if index < 0 || index >= int64(len(items.items)) {
+		return 0, errors.New(fmt.Sprintf("index out of bounds: %d, length %d", index, len(items.items)))
+	}
+	return int(index), nil
+}
+
+type assignExpression struct {
+	target assignable
+	operator string
+	value expression
+}
+
+func (self *assignExpression) eval(env *environment) (interface{}, error) {
+	value, err := self.value.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if self.operator != "" {
+		current, err := self.target.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = arithmetic(self.operator, current, value); err != nil {
+			return nil, err
+		}
+	}
+	return value, self.target.assign(env, value)
+}
+
+type incrementExpression struct {
+	target assignable
+	delta int64
+	prefix bool
+}
+
+func (self *incrementExpression) eval(env *environment) (interface{}, error) {
+	current, err := self.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := arithmetic("+", current, self.delta)
+	if err != nil {
+		return nil, err
+	}
+	if err = self.target.assign(env, updated); err != nil {
+		return nil, err
+	}
+	if self.prefix {
+		return updated, nil
+	}
+	return current, nil
+}
+
+type ternaryExpression struct {
+	condition expression
+	then expression
+	otherwise expression
+}
+
+func (self *ternaryExpression) eval(env *environment) (interface{}, error) {
+	condition, err := evalBool(env, self.condition)
+	if err != nil {
+		return nil, err
+	}
+	if condition {
+		return self.then.eval(env)
+	}
+	return self.otherwise.eval(env)
+}
+
+type unaryExpression struct {
+	operator string
+	value expression
+}
+
+func (self *unaryExpression) eval(env *environment) (interface{}, error) {
+	if self.operator == "!" {
+		value, err := evalBool(env, self.value)
+		return !value, err
+	}
+	value, err := self.value.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if self.operator == "-" {
+		return arithmetic("-", int64(0), value)
+	}
+	return arithmetic("+", int64(0), value)
+}
+
+type binaryExpression struct {
+	operator string
+	left expression
+	right expression
+}
+
+func (self *binaryExpression) eval(env *environment) (interface{}, error) {
+	// short circuit boolean operators
+	if self.operator == "&&" || self.operator == "||" {
+		left, err := evalBool(env, self.left)
+		if err != nil {
+			return nil, err
+		}
+		if (self.operator == "&&") != left {
+			return left, nil
+		}
+		return evalBool(env, self.right)
+	}
+
+	left, err := self.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := self.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch self.operator {
+		case "==":
+			return valuesEqual(left, right), nil
+		case "!=":
+			return !valuesEqual(left, right), nil
+		case "<", ">", "<=", ">=":
+			return compareValues(self.operator, left, right)
+		default:
+			return arithmetic(self.operator, left, right)
+	}
+}
+
+type instanceofExpression struct {
+	value expression
+	type_name string
+}
+
+func (self *instanceofExpression) eval(env *environment) (interface{}, error) {
+	value, err := self.value.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch self.type_name {
+		case "List", "ArrayList", "Collection":
+			_, ok := value.(*list)
+			return ok, nil
+		case "Map", "HashMap":
+			_, ok := value.(map[string]interface{})
+			return ok, nil
+		case "String":
+			_, ok := value.(string)
+			return ok, nil
+		case "Number":
+			_, is_int := value.(int64)
+			_, is_float := value.(float64)
+			return is_int || is_float, nil
+		case "Boolean":
+			_, ok := value.(bool)
+			return ok, nil
+		case "Object", "def":
+			return value != nil, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown type [%s]", self.type_name))
+}
+
+type newExpression struct {
+	type_name string
+	arguments []expression
+}
+
+func (self *newExpression) eval(env *environment) (interface{}, error) {
+	arguments, err := evalAll(env, self.arguments)
+	if err != nil {
+		return nil, err
+	}
+	switch self.type_name {
+		case "ArrayList":
+			created := &list{items: []interface{}{}}
+			if len(arguments) == 1 {
+				if source, ok := arguments[0].(*list); ok {
+					created.items = append(created.items, source.items...)
+				}
+			}
+			return created, nil
+		case "HashMap":
+			created := map[string]interface{}{}
+			if len(arguments) == 1 {
+				if source, ok := arguments[0].(map[string]interface{}); ok {
+					for key := range source {
+						created[key] = source[key]
+					}
+				}
+			}
+			return created, nil
+	}
+	return nil, errors.New(fmt.Sprintf("cannot create unknown type [%s]", self.type_name))
+}
+
+type listLiteral struct {
+	items []expression
+}
+
+func (self *listLiteral) eval(env *environment) (interface{}, error) {
+	items, err := evalAll(env, self.items)
+	return &list{items: items}, err
+}
+
+type mapLiteral struct {
+	keys []expression
+	values []expression
+}
+
+func (self *mapLiteral) eval(env *environment) (interface{}, error) {
+	created := map[string]interface{}{}
+	for i := range self.keys {
+		key, err := self.keys[i].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		value, err := self.values[i].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		created[fmt.Sprintf("%v", key)] = value
+	}
+	return created, nil
+}
+
+type callExpression struct {
+	target expression
+	method string
+	arguments []expression
+	null_safe bool
+}
+
+func (self *callExpression) eval(env *environment) (interface{}, error) {
+	target, err := self.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil && self.null_safe {
+		return nil, nil
+	}
+	arguments, err := evalAll(env, self.arguments)
+	if err != nil {
+		return nil, err
+	}
+	switch typed := target.(type) {
+		case *list:
+			return callList(typed, self.method, arguments)
+		case map[string]interface{}:
+			return callMap(typed, self.method, arguments)
+		case string:
+			return callString(typed, self.method, arguments)
+		case mathClass:
+			return callMath(self.method, arguments)
+		case nil:
+			return nil, errors.New(fmt.Sprintf("null pointer exception calling [%s]", self.method))
+	}
+	if self.method == "equals" && len(arguments) == 1 {
+		return valuesEqual(target, arguments[0]), nil
+	}
+	if self.method == "toString" && len(arguments) == 0 {
+		return fmt.Sprintf("%v", target), nil
+	}
+	return nil, methodNotFound(target, self.method, arguments)
+}
+
+type mathClass struct{}
+
+func methodNotFound(target interface{}, method string, arguments []interface{}) error {
+	return errors.New(fmt.Sprintf("dynamic method [%s, %s/%d] not found", typeName(target), method, len(arguments)))
+}
+
+func callList(target *list, method string, arguments []interface{}) (interface{}, error) {
+	switch {
+		case method == "add" && len(arguments) == 1:
+			target.items = append(target.items, arguments[0])
+			return true, nil
+		case method == "add" && len(arguments) == 2:
+			index, ok := arguments[0].(int64)
+			if !ok || index < 0 || index > int64(len(target.items)) {
+				return nil, errors.New(fmt.Sprintf("index out of bounds: %v", arguments[0]))
+			}
+			target.items = append(target.items[:index], append([]interface{}{arguments[1]}, target.items[index:]...)...)
+			return nil, nil
+		case method == "addAll" && len(arguments) == 1:
+			source, ok := arguments[0].(*list)
+			if !ok {
+				return nil, methodNotFound(target, method, arguments)
+			}
+			target.items = append(target.items, source.items...)
+			return true, nil
+		case method == "contains" && len(arguments) == 1:
+			for i := range target.items {
+				if valuesEqual(target.items[i], arguments[0]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case method == "indexOf" && len(arguments) == 1:
+			for i := range target.items {
+				if valuesEqual(target.items[i], arguments[0]) {
+					return int64(i), nil
+				}
+			}
+			return int64(-1), nil
+		case method == "get" && len(arguments) == 1:
+			index, err := listIndex(target, arguments[0])
+			if err != nil {
+				return nil, err
+			}
+			return target.items[index], nil
+		case method == "set" && len(arguments) == 2:
+			index, err := listIndex(target, arguments[0])
+			if err != nil {
+				return nil, err
+			}
+			previous := target.items[index]
+			target.items[index] = arguments[1]
+			return previous, nil
+		case method == "remove" && len(arguments) == 1:
+			index, err := listIndex(target, arguments[0])
+			if err != nil {
+				return nil, err
+			}
+			removed := target.items[index]
+			target.items = append(target.items[:index], target.items[index+1:]...)
+			return removed, nil
+		case method == "size" && len(arguments) == 0:
+			return int64(len(target.items)), nil
+		case method == "isEmpty" && len(arguments) == 0:
+			return len(target.items) == 0, nil
+		case method == "clear" && len(arguments) == 0:
+			target.items = []interface{}{}
+			return nil, nil
+		case method == "equals" && len(arguments) == 1:
+			return valuesEqual(target, arguments[0]), nil
+	}
+	return nil, methodNotFound(target, method, arguments)
+}
+
+func callMap(target map[string]interface{}, method string, arguments []interface{}) (interface{}, error) {
+	key := ""
+	if len(arguments) > 0 {
+		key = fmt.Sprintf("%v", arguments[0])
+	}
+	switch {
+		case method == "get" && len(arguments) == 1:
+			return target[key], nil
+		case method == "getOrDefault" && len(arguments) == 2:
+			if value, ok := target[key]; ok {
+				return value, nil
+			}
+			return arguments[1], nil
+		case method == "put" && len(arguments) == 2:
+			previous := target[key]
+			target[key] = arguments[1]
+			return previous, nil
+		case method == "containsKey" && len(arguments) == 1:
+			_, ok := target[key]
+			return ok, nil
+		case method == "remove" && len(arguments) == 1:
+			previous := target[key]
+			delete(target, key)
+			return previous, nil
+		case method == "size" && len(arguments) == 0:
+			return int64(len(target)), nil
+		case method == "isEmpty" && len(arguments) == 0:
+			return len(target) == 0, nil
+		case method == "keySet" && len(arguments) == 0:
+			keys := &list{items: []interface{}{}}
+			for key := range target {
+				keys.items = append(keys.items, key)
+			}
+			return keys, nil
+		case method == "equals" && len(arguments) == 1:
+			return valuesEqual(target, arguments[0]), nil
+	}
+	return nil, methodNotFound(target, method, arguments)
+}
+
+func callString(target string, method string, arguments []interface{}) (interface{}, error) {
+	argument := ""
+	if len(arguments) > 0 {
+		argument = fmt.Sprintf("%v", arguments[0])
+	}
+	switch {
+		case method == "length" && len(arguments) == 0:
+			return int64(len(target)), nil
+		case method == "equals" && len(arguments) == 1:
+			return valuesEqual(target, arguments[0]), nil
+		case method == "contains" && len(arguments) == 1:
+			return strings.Contains(target, argument), nil
+		case method == "startsWith" && len(arguments) == 1:
+			return strings.HasPrefix(target, argument), nil
+		case method == "endsWith" && len(arguments) == 1:
+			return strings.HasSuffix(target, argument), nil
+		case method == "toLowerCase" && len(arguments) == 0:
+			return strings.ToLower(target), nil
+		case method == "toUpperCase" && len(arguments) == 0:
+			return strings.ToUpper(target), nil
+		case method == "isEmpty" && len(arguments) == 0:
+			return len(target) == 0, nil
+		case method == "toString" && len(arguments) == 0:
+			return target, nil
+	}
+	return nil, methodNotFound(target, method, arguments)
+}
+
+func callMath(method string, arguments []interface{}) (interface{}, error) {
+	if len(arguments) != 2 || (method != "max" && method != "min") {
+		return nil, methodNotFound(mathClass{}, method, arguments)
+	}
+	less, err := compareValues("<", arguments[0], arguments[1])
+	if err != nil {
+		return nil, err
+	}
+	if less.(bool) == (method == "min") {
+		return arguments[0], nil
+	}
+	return arguments[1], nil
+}
+
+func evalAll(env *environment, expressions []expression) ([]interface{}, error) {
+	values := []interface{}{}
+	for i := range expressions {
+		value, err := expressions[i].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func evalBool(env *environment, value expression) (bool, error) {
+	result, err := value.eval(env)
+	if err != nil {
+		return false, err
+	}
+	condition, ok := result.(bool)
+	if !ok {
+		return false, errors.New(fmt.Sprintf("cannot cast %s to boolean", typeName(result)))
+	}
+	return condition, nil
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+		case nil:
+			return "null"
+		case *list:
+			return "java.util.ArrayList"
+		case map[string]interface{}:
+			return "java.util.HashMap"
+		case string:
+			return "java.lang.String"
+		case int64:
+			return "java.lang.Long"
+		case float64:
+			return "java.lang.Double"
+		case bool:
+			return "java.lang.Boolean"
+		case mathClass:
+			return "Math"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// number converts a numeric value to float64
+func number(value interface{}) (float64, bool) {
+	switch typed := value.(type) {
+		case int64:
+			return float64(typed), true
+		case float64:
+			return typed, true
+	}
+	return 0, false
+}
+
+func valuesEqual(left interface{}, right interface{}) bool {
+	if left_number, ok := number(left); ok {
+		right_number, ok := number(right)
+		return ok && left_number == right_number
+	}
+	switch typed := left.(type) {
+		case *list:
+			other, ok := right.(*list)
+			if !ok || len(typed.items) != len(other.items) {
+				return false
+			}
+			for i := range typed.items {
+				if !valuesEqual(typed.items[i], other.items[i]) {
+					return false
+				}
+			}
+			return true
+		case map[string]interface{}:
+			other, ok := right.(map[string]interface{})
+			if !ok || len(typed) != len(other) {
+				return false
+			}
+			for key := range typed {
+				if value, ok := other[key]; !ok || !valuesEqual(typed[key], value) {
+					return false
+				}
+			}
+			return true
+	}
+	return left == right
+}
+
+func compareValues(operator string, left interface{}, right interface{}) (interface{}, error) {
+	var comparison int
+	left_number, left_ok := number(left)
+	right_number, right_ok := number(right)
+	left_string, left_is_string := left.(string)
+	right_string, right_is_string := right.(string)
+	switch {
+		case left_ok && right_ok:
+			comparison = compareFloats(left_number, right_number)
+		case left_is_string && right_is_string:
+			comparison = strings.Compare(left_string, right_string)
+		default:
+			return nil, errors.New(fmt.Sprintf("cannot compare %s and %s", typeName(left), typeName(right)))
+	}
+	switch operator {
+		case "<":
+			return comparison < 0, nil
+		case ">":
+			return comparison > 0, nil
+		case "<=":
+			return comparison <= 0, nil
+		default:
+			return comparison >= 0, nil
+	}
+}
+
+func compareFloats(left float64, right float64) int {
+	if left < right {
+		return -1
+	}
+	if left > right {
+		return 1
+	}
+	return 0
+}
+
+func arithmetic(operator string, left interface{}, right interface{}) (interface{}, error) {
+	// string concatenation
+	if operator == "+" {
+		left_string, left_is_string := left.(string)
+		right_string, right_is_string := right.(string)
+		if left_is_string || right_is_string {
+			if !left_is_string {
+				left_string = fmt.Sprintf("%v", left)
+			}
+			if !right_is_string {
+				right_string = fmt.Sprintf("%v", right)
+			}
+			return left_string + right_string, nil
+		}
+	}
+
+	// integer arithmetic
+	left_int, left_is_int := left.(int64)
+	right_int, right_is_int := right.(int64)
+	if left_is_int && right_is_int {
+		switch operator {
+			case "+":
+				return left_int + right_int, nil
+			case "-":
+				return left_int - right_int, nil
+			case "*":
+				return left_int * right_int, nil
+			case "/", "%":
+				if right_int == 0 {
+					return nil, errors.New("arithmetic exception: / by zero")
+				}
+				if operator == "/" {
+					return left_int / right_int, nil
+				}
+				return left_int % right_int, nil
+		}
+	}
+
+	// floating point arithmetic
+	left_number, left_ok := number(left)
+	right_number, right_ok := number(right)
+	if !left_ok || !right_ok {
+		return nil, errors.New(fmt.Sprintf("cannot apply [%s] to %s and %s", operator, typeName(left), typeName(right)))
+	}
+	switch operator {
+		case "+":
+			return left_number + right_number, nil
+		case "-":
+			return left_number - right_number, nil
+		case "*":
+			return left_number * right_number, nil
+		case "/":
+			return left_number / right_number, nil
+		case "%":
+			return math.Mod(left_number, right_number), nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown operator [%s]", operator))
+}
@@ -0,0 +1,208 @@
+// Package painless interprets the subset of the painless scripting language used by elk update scripts so that
+// updates can be applied to documents without elasticsearch, for example to test them or preview their changes.
+// Supported are variable declarations, assignments, if, for, for each, while and return statements, arithmetic,
+// comparison and boolean operators, field and bracket access on ctx and params, list and map literals, new ArrayList
+// and HashMap, and the common methods of lists, maps and strings such as add, contains, size, containsKey and remove.
+package painless
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// maxLoopIterations stops scripts that never terminate
+const maxLoopIterations = 1000000
+
+// Script is a parsed painless script that can be executed many times
+type Script struct {
+	source string
+	statements []statement
+}
+
+// list is the mutable representation of a json array while a script runs
+type list struct {
+	items []interface{}
+}
+
+// Compile parses a painless script, returning an error if it uses syntax outside the supported subset
+func Compile(source string) (*Script, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := parser{tokens: tokens}
+	script := &Script{source: source}
+	for !parser.done() {
+		statement, err := parser.statement()
+		if err != nil {
+			return nil, err
+		}
+		script.statements = append(script.statements, statement)
+	}
+	return script, nil
+}
+
+// Execute runs a painless script with the given variables, usually ctx and params
+func Execute(source string, variables map[string]interface{}) error {
+	script, err := Compile(source)
+	if err != nil {
+		return err
+	}
+	return script.Execute(variables)
+}
+
+// Source returns the source of the script
+func (self *Script) Source() string {
+	return self.source
+}
+
+// Execute runs the script with the given variables. Maps are modified in place and arrays are converted so scripts can
+// modify them and converted back afterwards. Variables are left partially converted if the script fails so callers
+// should pass copies they can discard.
+func (self *Script) Execute(variables map[string]interface{}) error {
+	scope := &scope{variables: map[string]interface{}{}}
+	for name := range variables {
+		scope.variables[name] = toScript(variables[name])
+	}
+	env := &environment{scope: scope}
+	for i := range self.statements {
+		if _, err := self.statements[i].exec(env); err != nil {
+			return err
+		}
+		if env.returned {
+			break
+		}
+	}
+
+	// convert lists back to arrays, maps were converted in place
+	for name := range variables {
+		fromScript(scope.variables[name])
+	}
+	return nil
+}
+
+// Update runs an update script against document the way elasticsearch runs the script of an update request, with the
+// document as ctx._source and params encoded as json. The document is only modified if the script succeeds.
+// The returned operation is ctx.op, which is index unless the script sets it to none or delete.
+func Update(document Object, source string, params Object) (string, error) {
+	copied, err := jsonValue(document)
+	if err != nil {
+		return "", err
+	}
+	encoded_params, err := jsonValue(params)
+	if err != nil {
+		return "", err
+	}
+	if encoded_params == nil {
+		encoded_params = map[string]interface{}{}
+	}
+	ctx := map[string]interface{}{
+		"_source": copied,
+		"op": "index",
+		"_now": time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	if err = Execute(source, map[string]interface{}{"ctx": ctx, "params": encoded_params}); err != nil {
+		return "", err
+	}
+	updated, ok := ctx["_source"].(map[string]interface{})
+	if !ok {
+		return "", errors.New("ctx._source must be a map")
+	}
+	for key := range document {
+		delete(document, key)
+	}
+	for key := range updated {
+		document[key] = updated[key]
+	}
+	operation, _ := ctx["op"].(string)
+	return operation, nil
+}
+
+// jsonValue encodes a value as json and decodes it again so it has the types elasticsearch would see
+func jsonValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	err = json.Unmarshal(data, &decoded)
+	return decoded, err
+}
+
+// toScript converts go values to script values. Maps are converted in place.
+func toScript(value interface{}) interface{} {
+	switch typed := value.(type) {
+		case nil, bool, string, int64, float64, *list:
+			return value
+		case map[string]interface{}:
+			for key := range typed {
+				typed[key] = toScript(typed[key])
+			}
+			return typed
+		case Object:
+			return toScript(map[string]interface{}(typed))
+		case []interface{}:
+			items := make([]interface{}, len(typed))
+			for i := range typed {
+				items[i] = toScript(typed[i])
+			}
+			return &list{items: items}
+	}
+
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return reflected.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return int64(reflected.Uint())
+		case reflect.Float32, reflect.Float64:
+			return reflected.Float()
+		case reflect.String:
+			return reflected.String()
+		case reflect.Bool:
+			return reflected.Bool()
+		case reflect.Slice, reflect.Array:
+			items := make([]interface{}, reflected.Len())
+			for i := range items {
+				items[i] = toScript(reflected.Index(i).Interface())
+			}
+			return &list{items: items}
+		case reflect.Map:
+			converted := map[string]interface{}{}
+			iterator := reflected.MapRange()
+			for iterator.Next() {
+				converted[fmt.Sprintf("%v", iterator.Key().Interface())] = toScript(iterator.Value().Interface())
+			}
+			return converted
+	}
+
+	// structs and pointers are converted the way elasticsearch would receive them
+	decoded, err := jsonValue(value)
+	if err != nil {
+		return value
+	}
+	return toScript(decoded)
+}
+
+// fromScript converts script values back to json values. Maps are converted in place.
+func fromScript(value interface{}) interface{} {
+	switch typed := value.(type) {
+		case map[string]interface{}:
+			for key := range typed {
+				typed[key] = fromScript(typed[key])
+			}
+			return typed
+		case *list:
+			items := make([]interface{}, len(typed.items))
+			for i := range typed.items {
+				items[i] = fromScript(typed.items[i])
+			}
+			return items
+	}
+	return value
+}
@@ -0,0 +1,105 @@
+package painless_test
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+	"github.com/KarmaPenny/golib/elk/painless"
+
+	"reflect"
+	"testing"
+)
+
+// evaluate runs an expression and returns the value it assigns to ctx.result
+func evaluate(t *testing.T, expression string) interface{} {
+	ctx := map[string]interface{}{}
+	params := map[string]interface{}{"n": 2.5, "name": "doc", "items": []interface{}{"a", "b"}}
+	if err := painless.Execute("ctx.result = " + expression + ";", map[string]interface{}{"ctx": ctx, "params": params}); err != nil {
+		t.Fatalf("%s: %s", expression, err)
+	}
+	return ctx["result"]
+}
+
+func TestOperators(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected interface{}
+	}{
+		{"1 + 2 * 3", int64(7)},
+		{"(1 + 2) * 3", int64(9)},
+		{"7 / 2", int64(3)},
+		{"7 % 3", int64(1)},
+		{"7.0 / 2", 3.5},
+		{"params.n * 2", 5.0},
+		{"-params.n", -2.5},
+		{"10 - 4 - 3", int64(3)},
+		{"'id-' + 1", "id-1"},
+		{"params.name + '-' + params.n", "doc-2.5"},
+		{"1 == 1.0", true},
+		{"'a' != 'b'", true},
+		{"2 < 3 && 3 <= 3", true},
+		{"2 > 3 || 3 >= 4", false},
+		{"!(1 > 2)", true},
+		{"params.missing == null", true},
+		{"params.missing != null && params.missing.size() > 0", false},
+		{"params.n > 2 ? 'big' : 'small'", "big"},
+		{"params.items instanceof List", true},
+		{"params.name instanceof String", true},
+		{"params.items.size()", int64(2)},
+		{"params.items[1]", "b"},
+		{"params.items.contains('a')", true},
+	}
+	for _, test := range tests {
+		if result := evaluate(t, test.expression); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.expression, test.expected, result)
+		}
+	}
+}
+
+func TestAssignmentOperators(t *testing.T) {
+	document := Object{"count": 1, "tags": []interface{}{"a"}}
+	source := "int i = 0; i++; ++i; i += 3; i -= 1; i *= 4; i /= 2; ctx._source.count += i; ctx._source.tags.add('b'); ctx._source.total = i--; ctx._source.after = i"
+	if _, err := painless.Update(document, source, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := Object{"count": 9.0, "tags": []interface{}{"a", "b"}, "total": int64(8), "after": int64(7)}
+	if !reflect.DeepEqual(document, expected) {
+		t.Fatalf("expected %v, got %v", expected, document)
+	}
+}
+
+func TestDivideByZero(t *testing.T) {
+	if err := painless.Execute("int x = 1 / 0;", map[string]interface{}{}); err == nil {
+		t.Fatal("expected integer division by zero to fail")
+	}
+}
+
+// TestListOfObjects covers the .size() calls and analysis[i].type access the analysis scripts depend on
+func TestListOfObjects(t *testing.T) {
+	document := Object{"analysis": []interface{}{Object{"type": "a", "n": 1}, Object{"type": "b", "n": 2}}}
+	source := "int found = -1; for (int i = 0; i < ctx._source.analysis.size(); ++i) { if (ctx._source.analysis[i].type == params.type) { found = i; } } ctx._source.found = found; ctx._source.analysis[found] = params.replacement"
+	params := Object{"type": "b", "replacement": Object{"type": "b", "n": 3}}
+	if _, err := painless.Update(document, source, params); err != nil {
+		t.Fatal(err)
+	}
+	expected := Object{
+		"analysis": []interface{}{map[string]interface{}{"type": "a", "n": 1.0}, map[string]interface{}{"type": "b", "n": 3.0}},
+		"found": int64(1),
+	}
+	if !reflect.DeepEqual(document, expected) {
+		t.Fatalf("expected %v, got %v", expected, document)
+	}
+
+	// painless has no length field on lists
+	if _, err := painless.Update(document, "ctx._source.n = ctx._source.analysis.length", nil); err == nil {
+		t.Fatal("expected length on a list to fail")
+	}
+}
+
+func TestUnsupportedSyntax(t *testing.T) {
+	for _, source := range []string{"ctx._source.x = ", "def f() { return 1; }", "int i = 0; int i = 1;"} {
+		if _, err := painless.Compile(source); err == nil {
+			if err = painless.Execute(source, map[string]interface{}{"ctx": map[string]interface{}{}}); err == nil {
+				t.Errorf("expected %q to fail", source)
+			}
+		}
+	}
+}
@@ -0,0 +1,569 @@
+package painless
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type token struct {
+	kind string
+	text string
+	position int
+}
+
+var operators = []string{"?.", "==", "!=", "<=", ">=", "&&", "||", "++", "--", "+=", "-=", "*=", "/=", "(", ")", "{", "}", "[", "]", ";", ",", ".", "<", ">", "=", "!", "+", "-", "*", "/", "%", "?", ":"}
+
+// tokenize splits painless source into identifiers, numbers, strings and operators
+func tokenize(source string) ([]token, error) {
+	tokens := []token{}
+	i := 0
+	for i < len(source) {
+		c := rune(source[i])
+		switch {
+			case unicode.IsSpace(c):
+				i++
+			case unicode.IsLetter(c) || c == '_':
+				start := i
+				for i < len(source) && (unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i])) || source[i] == '_') {
+					i++
+				}
+				tokens = append(tokens, token{kind: "ident", text: source[start:i], position: start})
+			case unicode.IsDigit(c):
+				start := i
+				kind := "int"
+				for i < len(source) && (unicode.IsDigit(rune(source[i])) || (source[i] == '.' && kind == "int" && i + 1 < len(source) && unicode.IsDigit(rune(source[i+1])) && !afterDot(tokens))) {
+					if source[i] == '.' {
+						kind = "float"
+					}
+					i++
+				}
+				tokens = append(tokens, token{kind: kind, text: source[start:i], position: start})
+			case c == '\'' || c == '"':
+				start := i
+				text := strings.Builder{}
+				i++
+				for i < len(source) && rune(source[i]) != c {
+					if source[i] == '\\' && i + 1 < len(source) {
+						i++
+					}
+					text.WriteByte(source[i])
+					i++
+				}
+				if i >= len(source) {
+					return tokens, errors.New(fmt.Sprintf("unterminated string at %d", start))
+				}
+				i++
+				tokens = append(tokens, token{kind: "string", text: text.String(), position: start})
+			default:
+				matched := false
+				for _, operator := range operators {
+					if strings.HasPrefix(source[i:], operator) {
+						tokens = append(tokens, token{kind: "op", text: operator, position: i})
+						i += len(operator)
+						matched = true
+						break
+					}
+				}
+				if !matched {
+					return tokens, errors.New(fmt.Sprintf("unexpected character %q at %d", c, i))
+				}
+		}
+	}
+	return tokens, nil
+}
+
+// afterDot returns true if the last token is a dot so that params.0 is not read as a float
+func afterDot(tokens []token) bool {
+	return len(tokens) > 0 && tokens[len(tokens)-1].kind == "op" && (tokens[len(tokens)-1].text == "." || tokens[len(tokens)-1].text == "?.")
+}
+
+var typeNames = map[string]bool{
+	"def": true, "int": true, "long": true, "double": true, "float": true, "boolean": true, "String": true,
+	"Object": true, "List": true, "Map": true, "ArrayList": true, "HashMap": true, "var": true,
+}
+
+type parser struct {
+	tokens []token
+	position int
+}
+
+func (self *parser) done() bool {
+	return self.position >= len(self.tokens)
+}
+
+func (self *parser) peek(offset int) token {
+	if self.position + offset >= len(self.tokens) {
+		return token{kind: "eof"}
+	}
+	return self.tokens[self.position + offset]
+}
+
+func (self *parser) is(text string) bool {
+	next := self.peek(0)
+	return (next.kind == "op" || next.kind == "ident") && next.text == text
+}
+
+func (self *parser) accept(text string) bool {
+	if self.is(text) {
+		self.position++
+		return true
+	}
+	return false
+}
+
+func (self *parser) expect(text string) error {
+	if !self.accept(text) {
+		return self.unexpected(text)
+	}
+	return nil
+}
+
+// end expects the semicolon that ends a statement, which is optional for the last statement of the script
+func (self *parser) end() error {
+	if self.done() {
+		return nil
+	}
+	return self.expect(";")
+}
+
+func (self *parser) unexpected(expected string) error {
+	next := self.peek(0)
+	if next.kind == "eof" {
+		return errors.New(fmt.Sprintf("expected %s but reached end of script", expected))
+	}
+	return errors.New(fmt.Sprintf("expected %s but found %q at %d", expected, next.text, next.position))
+}
+
+func (self *parser) identifier() (string, error) {
+	next := self.peek(0)
+	if next.kind != "ident" {
+		return "", self.unexpected("identifier")
+	}
+	self.position++
+	return next.text, nil
+}
+
+func (self *parser) statement() (statement, error) {
+	switch {
+		case self.accept("{"):
+			return self.block()
+		case self.accept("if"):
+			return self.ifStatement()
+		case self.accept("for"):
+			return self.forStatement()
+		case self.accept("while"):
+			if err := self.expect("("); err != nil {
+				return nil, err
+			}
+			condition, err := self.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err = self.expect(")"); err != nil {
+				return nil, err
+			}
+			body, err := self.statement()
+			return &forStatement{condition: condition, body: body}, err
+		case self.accept("return"):
+			if self.accept(";") {
+				return &returnStatement{}, nil
+			}
+			value, err := self.expression()
+			if err != nil {
+				return nil, err
+			}
+			return &returnStatement{value: value}, self.end()
+		case self.accept(";"):
+			return &blockStatement{}, nil
+		case self.isDeclaration():
+			declaration, err := self.declaration()
+			if err != nil {
+				return nil, err
+			}
+			return declaration, self.end()
+		default:
+			value, err := self.expression()
+			if err != nil {
+				return nil, err
+			}
+			return &expressionStatement{value: value}, self.end()
+	}
+}
+
+func (self *parser) isDeclaration() bool {
+	return self.peek(0).kind == "ident" && typeNames[self.peek(0).text] && self.peek(1).kind == "ident"
+}
+
+func (self *parser) declaration() (*declareStatement, error) {
+	self.position++
+	name, err := self.identifier()
+	if err != nil {
+		return nil, err
+	}
+	declaration := &declareStatement{name: name}
+	if self.accept("=") {
+		declaration.value, err = self.expression()
+	}
+	return declaration, err
+}
+
+func (self *parser) block() (statement, error) {
+	block := &blockStatement{}
+	for !self.accept("}") {
+		if self.done() {
+			return nil, self.unexpected("}")
+		}
+		statement, err := self.statement()
+		if err != nil {
+			return nil, err
+		}
+		block.statements = append(block.statements, statement)
+	}
+	return block, nil
+}
+
+func (self *parser) ifStatement() (statement, error) {
+	if err := self.expect("("); err != nil {
+		return nil, err
+	}
+	condition, err := self.expression()
+	if err != nil {
+		return nil, err
+	}
+	if err = self.expect(")"); err != nil {
+		return nil, err
+	}
+	then, err := self.statement()
+	if err != nil {
+		return nil, err
+	}
+	statement := &ifStatement{condition: condition, then: then}
+	if self.accept("else") {
+		statement.otherwise, err = self.statement()
+	}
+	return statement, err
+}
+
+func (self *parser) forStatement() (statement, error) {
+	if err := self.expect("("); err != nil {
+		return nil, err
+	}
+
+	// enhanced for loop over a list or map keys
+	if self.peek(0).kind == "ident" && typeNames[self.peek(0).text] && self.peek(1).kind == "ident" && self.peek(2).text == ":" {
+		self.position++
+		name, _ := self.identifier()
+		self.position++
+		collection, err := self.expression()
+		if err != nil {
+			return nil, err
+		}
+		if err = self.expect(")"); err != nil {
+			return nil, err
+		}
+		body, err := self.statement()
+		return &forEachStatement{name: name, collection: collection, body: body}, err
+	}
+
+	// classic for loop
+	loop := &forStatement{}
+	var err error
+	if self.isDeclaration() {
+		loop.initialize, err = self.declaration()
+	} else if !self.is(";") {
+		var value expression
+		value, err = self.expression()
+		loop.initialize = &expressionStatement{value: value}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = self.expect(";"); err != nil {
+		return nil, err
+	}
+	if !self.is(";") {
+		if loop.condition, err = self.expression(); err != nil {
+			return nil, err
+		}
+	}
+	if err = self.expect(";"); err != nil {
+		return nil, err
+	}
+	if !self.is(")") {
+		if loop.update, err = self.expression(); err != nil {
+			return nil, err
+		}
+	}
+	if err = self.expect(")"); err != nil {
+		return nil, err
+	}
+	loop.body, err = self.statement()
+	return loop, err
+}
+
+func (self *parser) expression() (expression, error) {
+	target, err := self.ternary()
+	if err != nil {
+		return nil, err
+	}
+	for _, operator := range []string{"=", "+=", "-=", "*=", "/="} {
+		if self.accept(operator) {
+			assignable, ok := target.(assignable)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("cannot assign to expression before %s", operator))
+			}
+			value, err := self.expression()
+			if err != nil {
+				return nil, err
+			}
+			return &assignExpression{target: assignable, operator: strings.TrimSuffix(operator, "="), value: value}, nil
+		}
+	}
+	return target, nil
+}
+
+func (self *parser) ternary() (expression, error) {
+	condition, err := self.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !self.accept("?") {
+		return condition, nil
+	}
+	then, err := self.expression()
+	if err != nil {
+		return nil, err
+	}
+	if err = self.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := self.expression()
+	return &ternaryExpression{condition: condition, then: then, otherwise: otherwise}, err
+}
+
+// precedence lists binary operators from lowest to highest precedence
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", ">", "<=", ">=", "instanceof"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (self *parser) binary(level int) (expression, error) {
+	if level >= len(precedence) {
+		return self.unary()
+	}
+	left, err := self.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		matched := ""
+		for _, operator := range precedence[level] {
+			if self.is(operator) {
+				matched = operator
+				break
+			}
+		}
+		if matched == "" {
+			return left, nil
+		}
+		self.position++
+		if matched == "instanceof" {
+			type_name, err := self.identifier()
+			if err != nil {
+				return nil, err
+			}
+			left = &instanceofExpression{value: left, type_name: type_name}
+			continue
+		}
+		right, err := self.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpression{operator: matched, left: left, right: right}
+	}
+}
+
+func (self *parser) unary() (expression, error) {
+	for _, operator := range []string{"!", "-", "+"} {
+		if self.accept(operator) {
+			value, err := self.unary()
+			return &unaryExpression{operator: operator, value: value}, err
+		}
+	}
+	for _, operator := range []string{"++", "--"} {
+		if self.accept(operator) {
+			value, err := self.unary()
+			if err != nil {
+				return nil, err
+			}
+			target, ok := value.(assignable)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("cannot apply %s to expression", operator))
+			}
+			return &incrementExpression{target: target, delta: delta(operator), prefix: true}, nil
+		}
+	}
+	return self.postfix()
+}
+
+func delta(operator string) int64 {
+	if operator == "++" {
+		return 1
+	}
+	return -1
+}
+
+func (self *parser) postfix() (expression, error) {
+	value, err := self.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+			case self.is(".") || self.is("?."):
+				null_safe := self.peek(0).text == "?."
+				self.position++
+				next := self.peek(0)
+				if next.kind != "ident" && next.kind != "int" {
+					return nil, self.unexpected("field or method name")
+				}
+				self.position++
+				if next.kind == "ident" && self.accept("(") {
+					arguments, err := self.arguments()
+					if err != nil {
+						return nil, err
+					}
+					value = &callExpression{target: value, method: next.text, arguments: arguments, null_safe: null_safe}
+				} else {
+					value = &memberExpression{target: value, key: &literalExpression{value: next.text}, null_safe: null_safe}
+				}
+			case self.accept("["):
+				key, err := self.expression()
+				if err != nil {
+					return nil, err
+				}
+				if err = self.expect("]"); err != nil {
+					return nil, err
+				}
+				value = &memberExpression{target: value, key: key, brackets: true}
+			case self.is("++") || self.is("--"):
+				operator := self.peek(0).text
+				self.position++
+				target, ok := value.(assignable)
+				if !ok {
+					return nil, errors.New(fmt.Sprintf("cannot apply %s to expression", operator))
+				}
+				value = &incrementExpression{target: target, delta: delta(operator)}
+			default:
+				return value, nil
+		}
+	}
+}
+
+func (self *parser) arguments() ([]expression, error) {
+	arguments := []expression{}
+	for !self.accept(")") {
+		if len(arguments) > 0 {
+			if err := self.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		argument, err := self.expression()
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, argument)
+	}
+	return arguments, nil
+}
+
+func (self *parser) primary() (expression, error) {
+	next := self.peek(0)
+	switch next.kind {
+		case "int":
+			self.position++
+			value, err := strconv.ParseInt(next.text, 10, 64)
+			return &literalExpression{value: value}, err
+		case "float":
+			self.position++
+			value, err := strconv.ParseFloat(next.text, 64)
+			return &literalExpression{value: value}, err
+		case "string":
+			self.position++
+			return &literalExpression{value: next.text}, nil
+		case "ident":
+			self.position++
+			switch next.text {
+				case "null":
+					return &literalExpression{value: nil}, nil
+				case "true":
+					return &literalExpression{value: true}, nil
+				case "false":
+					return &literalExpression{value: false}, nil
+				case "new":
+					type_name, err := self.identifier()
+					if err != nil {
+						return nil, err
+					}
+					if err = self.expect("("); err != nil {
+						return nil, err
+					}
+					arguments, err := self.arguments()
+					return &newExpression{type_name: type_name, arguments: arguments}, err
+			}
+			return &variableExpression{name: next.text}, nil
+		case "op":
+			if self.accept("(") {
+				value, err := self.expression()
+				if err != nil {
+					return nil, err
+				}
+				return value, self.expect(")")
+			}
+			if self.accept("[") {
+				return self.collectionLiteral()
+			}
+	}
+	return nil, self.unexpected("expression")
+}
+
+// collectionLiteral parses [a, b] list literals and [k: v] or [:] map literals after the opening bracket
+func (self *parser) collectionLiteral() (expression, error) {
+	if self.accept(":") {
+		return &mapLiteral{}, self.expect("]")
+	}
+	items := []expression{}
+	keys := []expression{}
+	for !self.accept("]") {
+		if len(items) > 0 {
+			if err := self.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		item, err := self.expression()
+		if err != nil {
+			return nil, err
+		}
+		if self.accept(":") {
+			keys = append(keys, item)
+			if item, err = self.expression(); err != nil {
+				return nil, err
+			}
+		}
+		items = append(items, item)
+	}
+	if len(keys) > 0 {
+		if len(keys) != len(items) {
+			return nil, errors.New("map literal mixes keys and values")
+		}
+		return &mapLiteral{keys: keys, values: items}, nil
+	}
+	return &listLiteral{items: items}, nil
+}
@@ -0,0 +1,189 @@
+package query
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+)
+
+// Aggregation is implemented by every aggregation and returns it as elasticsearch json
+type Aggregation interface {
+	Source() Object
+}
+
+// aggregationSources converts named aggregations to an aggs clause
+func aggregationSources(aggregations map[string]Aggregation) Object {
+	aggs := Object{}
+	for name := range aggregations {
+		aggs[name] = aggregations[name].Source()
+	}
+	return aggs
+}
+
+// subAggregations holds the aggregations computed within each bucket of a bucket aggregation
+type subAggregations map[string]Aggregation
+
+func (self *subAggregations) add(name string, aggregation Aggregation) {
+	if *self == nil {
+		*self = subAggregations{}
+	}
+	(*self)[name] = aggregation
+}
+
+func (self subAggregations) source(aggregation Object) Object {
+	if len(self) > 0 {
+		aggregation["aggs"] = aggregationSources(self)
+	}
+	return aggregation
+}
+
+// BucketAggregation is an aggregation that places documents into buckets which may have sub aggregations
+type BucketAggregation struct {
+	kind string
+	params Object
+	aggregations subAggregations
+}
+
+// TermsAgg buckets documents by each unique value of field
+func TermsAgg(field string) *BucketAggregation {
+	return &BucketAggregation{kind: "terms", params: Object{"field": field}}
+}
+
+// DateHistogramAgg buckets documents by date field using a calendar interval such as 1d
+func DateHistogramAgg(field string, interval string) *BucketAggregation {
+	return &BucketAggregation{kind: "date_histogram", params: Object{"field": field, "calendar_interval": interval}}
+}
+
+// HistogramAgg buckets documents by numeric field into buckets of width interval
+func HistogramAgg(field string, interval float64) *BucketAggregation {
+	return &BucketAggregation{kind: "histogram", params: Object{"field": field, "interval": interval}}
+}
+
+// NestedAgg computes its sub aggregations over the nested objects at path
+func NestedAgg(path string) *BucketAggregation {
+	return &BucketAggregation{kind: "nested", params: Object{"path": path}}
+}
+
+// Size sets the number of buckets returned
+func (self *BucketAggregation) Size(size int) *BucketAggregation {
+	self.params["size"] = size
+	return self
+}
+
+// Param sets any other parameter of the aggregation such as format or min_doc_count
+func (self *BucketAggregation) Param(name string, value interface{}) *BucketAggregation {
+	self.params[name] = value
+	return self
+}
+
+// SubAggregation adds a named aggregation computed within each bucket
+func (self *BucketAggregation) SubAggregation(name string, aggregation Aggregation) *BucketAggregation {
+	self.aggregations.add(name, aggregation)
+	return self
+}
+
+func (self *BucketAggregation) Source() Object {
+	return self.aggregations.source(Object{self.kind: self.params})
+}
+
+type RangeAggregation struct {
+	field string
+	ranges Array
+	aggregations subAggregations
+}
+
+// RangeAgg buckets documents by numeric or date field into the ranges added with Range
+func RangeAgg(field string) *RangeAggregation {
+	return &RangeAggregation{field: field, ranges: Array{}}
+}
+
+// Range adds a bucket named key for values from (inclusive) up to to (exclusive). Use nil for an open bound.
+func (self *RangeAggregation) Range(key string, from interface{}, to interface{}) *RangeAggregation {
+	bucket := Object{"key": key}
+	if from != nil {
+		bucket["from"] = from
+	}
+	if to != nil {
+		bucket["to"] = to
+	}
+	self.ranges = append(self.ranges, bucket)
+	return self
+}
+
+// SubAggregation adds a named aggregation computed within each bucket
+func (self *RangeAggregation) SubAggregation(name string, aggregation Aggregation) *RangeAggregation {
+	self.aggregations.add(name, aggregation)
+	return self
+}
+
+func (self *RangeAggregation) Source() Object {
+	return self.aggregations.source(Object{"range": Object{"field": self.field, "ranges": self.ranges}})
+}
+
+type FiltersAggregation struct {
+	filters map[string]Query
+	aggregations subAggregations
+}
+
+// FiltersAgg places documents in a bucket for each filter added with Filter
+func FiltersAgg() *FiltersAggregation {
+	return &FiltersAggregation{filters: map[string]Query{}}
+}
+
+// Filter adds a bucket named key containing the documents that match query
+func (self *FiltersAggregation) Filter(key string, query Query) *FiltersAggregation {
+	self.filters[key] = query
+	return self
+}
+
+// SubAggregation adds a named aggregation computed within each bucket
+func (self *FiltersAggregation) SubAggregation(name string, aggregation Aggregation) *FiltersAggregation {
+	self.aggregations.add(name, aggregation)
+	return self
+}
+
+func (self *FiltersAggregation) Source() Object {
+	filters := Object{}
+	for key := range self.filters {
+		filters[key] = self.filters[key].Source()
+	}
+	return self.aggregations.source(Object{"filters": Object{"filters": filters}})
+}
+
+// MetricAggregation is an aggregation that computes a value over a field
+type MetricAggregation struct {
+	kind string
+	params Object
+}
+
+// StatsAgg computes count, min, max, avg and sum of field
+func StatsAgg(field string) *MetricAggregation {
+	return &MetricAggregation{kind: "stats", params: Object{"field": field}}
+}
+
+// CardinalityAgg computes the approximate number of unique values of field
+func CardinalityAgg(field string) *MetricAggregation {
+	return &MetricAggregation{kind: "cardinality", params: Object{"field": field}}
+}
+
+// PercentilesAgg computes the given percentiles of field. The elasticsearch defaults are used when no percents are given.
+func PercentilesAgg(field string, percents ...float64) *MetricAggregation {
+	params := Object{"field": field}
+	if len(percents) > 0 {
+		params["percents"] = percents
+	}
+	return &MetricAggregation{kind: "percentiles", params: params}
+}
+
+// TopHitsAgg returns the top size documents of each bucket
+func TopHitsAgg(size int) *MetricAggregation {
+	return &MetricAggregation{kind: "top_hits", params: Object{"size": size}}
+}
+
+// Param sets any other parameter of the aggregation such as sort or _source for top hits
+func (self *MetricAggregation) Param(name string, value interface{}) *MetricAggregation {
+	self.params[name] = value
+	return self
+}
+
+func (self *MetricAggregation) Source() Object {
+	return Object{self.kind: self.params}
+}
@@ -0,0 +1,254 @@
+package query
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+)
+
+// Query is implemented by every query clause and returns the clause as elasticsearch json
+type Query interface {
+	Source() Object
+}
+
+// Raw wraps a handwritten query clause so it can be combined with built queries
+type Raw Object
+
+func (self Raw) Source() Object {
+	return Object(self)
+}
+
+// sources converts a list of queries to an array of query clauses
+func sources(queries []Query) Array {
+	clauses := Array{}
+	for i := range queries {
+		if queries[i] == nil {
+			clauses = append(clauses, nil)
+		} else {
+			clauses = append(clauses, queries[i].Source())
+		}
+	}
+	return clauses
+}
+
+type BoolQuery struct {
+	must []Query
+	filter []Query
+	should []Query
+	must_not []Query
+	minimum_should_match interface{}
+}
+
+// Bool returns a query that combines other queries with must, filter, should and must_not clauses
+func Bool() *BoolQuery {
+	return &BoolQuery{}
+}
+
+// Must adds queries that must match and contribute to the score
+func (self *BoolQuery) Must(queries ...Query) *BoolQuery {
+	self.must = append(self.must, queries...)
+	return self
+}
+
+// Filter adds queries that must match without contributing to the score
+func (self *BoolQuery) Filter(queries ...Query) *BoolQuery {
+	self.filter = append(self.filter, queries...)
+	return self
+}
+
+// Should adds queries that should match
+func (self *BoolQuery) Should(queries ...Query) *BoolQuery {
+	self.should = append(self.should, queries...)
+	return self
+}
+
+// MustNot adds queries that must not match
+func (self *BoolQuery) MustNot(queries ...Query) *BoolQuery {
+	self.must_not = append(self.must_not, queries...)
+	return self
+}
+
+// MinimumShouldMatch sets the number or percentage of should clauses that must match
+func (self *BoolQuery) MinimumShouldMatch(minimum interface{}) *BoolQuery {
+	self.minimum_should_match = minimum
+	return self
+}
+
+func (self *BoolQuery) Source() Object {
+	clauses := Object{}
+	if len(self.must) > 0 {
+		clauses["must"] = sources(self.must)
+	}
+	if len(self.filter) > 0 {
+		clauses["filter"] = sources(self.filter)
+	}
+	if len(self.should) > 0 {
+		clauses["should"] = sources(self.should)
+	}
+	if len(self.must_not) == 1 {
+		clauses["must_not"] = sources(self.must_not)[0]
+	} else if len(self.must_not) > 1 {
+		clauses["must_not"] = sources(self.must_not)
+	}
+	if self.minimum_should_match != nil {
+		clauses["minimum_should_match"] = self.minimum_should_match
+	}
+	return Object{"bool": clauses}
+}
+
+type TermQuery struct {
+	field string
+	value interface{}
+}
+
+// Term returns a query that matches documents where field is exactly value
+func Term(field string, value interface{}) *TermQuery {
+	return &TermQuery{field: field, value: value}
+}
+
+func (self *TermQuery) Source() Object {
+	return Object{"term": Object{self.field: self.value}}
+}
+
+type TermsQuery struct {
+	field string
+	values Array
+}
+
+// Terms returns a query that matches documents where field is exactly one of values
+func Terms(field string, values ...interface{}) *TermsQuery {
+	return &TermsQuery{field: field, values: Array(values)}
+}
+
+func (self *TermsQuery) Source() Object {
+	return Object{"terms": Object{self.field: self.values}}
+}
+
+type RangeQuery struct {
+	field string
+	bounds Object
+}
+
+// Range returns a query that matches documents where field is within the bounds set with Gt, Gte, Lt and Lte
+func Range(field string) *RangeQuery {
+	return &RangeQuery{field: field, bounds: Object{}}
+}
+
+func (self *RangeQuery) Gt(value interface{}) *RangeQuery {
+	self.bounds["gt"] = value
+	return self
+}
+
+func (self *RangeQuery) Gte(value interface{}) *RangeQuery {
+	self.bounds["gte"] = value
+	return self
+}
+
+func (self *RangeQuery) Lt(value interface{}) *RangeQuery {
+	self.bounds["lt"] = value
+	return self
+}
+
+func (self *RangeQuery) Lte(value interface{}) *RangeQuery {
+	self.bounds["lte"] = value
+	return self
+}
+
+// Format sets the date format used to parse the bounds
+func (self *RangeQuery) Format(format string) *RangeQuery {
+	self.bounds["format"] = format
+	return self
+}
+
+func (self *RangeQuery) Source() Object {
+	return Object{"range": Object{self.field: self.bounds}}
+}
+
+type ExistsQuery struct {
+	field string
+}
+
+// Exists returns a query that matches documents that have a value for field
+func Exists(field string) *ExistsQuery {
+	return &ExistsQuery{field: field}
+}
+
+func (self *ExistsQuery) Source() Object {
+	return Object{"exists": Object{"field": self.field}}
+}
+
+type MatchQuery struct {
+	kind string
+	field string
+	text interface{}
+}
+
+// Match returns a full text query on field
+func Match(field string, text interface{}) *MatchQuery {
+	return &MatchQuery{kind: "match", field: field, text: text}
+}
+
+// MatchPhrase returns a full text query on field that matches the exact phrase
+func MatchPhrase(field string, text string) *MatchQuery {
+	return &MatchQuery{kind: "match_phrase", field: field, text: text}
+}
+
+func (self *MatchQuery) Source() Object {
+	return Object{self.kind: Object{self.field: self.text}}
+}
+
+type MatchAllQuery struct{}
+
+// MatchAll returns a query that matches every document
+func MatchAll() *MatchAllQuery {
+	return &MatchAllQuery{}
+}
+
+func (self *MatchAllQuery) Source() Object {
+	return Object{"match_all": Object{}}
+}
+
+type NestedQuery struct {
+	path string
+	query Query
+}
+
+// Nested returns a query that matches documents with a nested object at path that matches query
+func Nested(path string, query Query) *NestedQuery {
+	return &NestedQuery{path: path, query: query}
+}
+
+func (self *NestedQuery) Source() Object {
+	return Object{"nested": Object{"path": self.path, "query": self.query.Source()}}
+}
+
+type IdsQuery struct {
+	ids []string
+}
+
+// Ids returns a query that matches documents by _id
+func Ids(ids ...string) *IdsQuery {
+	return &IdsQuery{ids: ids}
+}
+
+func (self *IdsQuery) Source() Object {
+	return Object{"ids": Object{"values": self.ids}}
+}
+
+type PatternQuery struct {
+	kind string
+	field string
+	value string
+}
+
+// Wildcard returns a query that matches documents where field matches a pattern containing * and ?
+func Wildcard(field string, pattern string) *PatternQuery {
+	return &PatternQuery{kind: "wildcard", field: field, value: pattern}
+}
+
+// Prefix returns a query that matches documents where field starts with prefix
+func Prefix(field string, prefix string) *PatternQuery {
+	return &PatternQuery{kind: "prefix", field: field, value: prefix}
+}
+
+func (self *PatternQuery) Source() Object {
+	return Object{self.kind: Object{self.field: self.value}}
+}
@@ -0,0 +1,134 @@
+package query
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// assertJson fails the test if source does not marshal to the expected json
+func assertJson(t *testing.T, source interface{}, expected string) {
+	var actual interface{}
+	var wanted interface{}
+	data, err := json.Marshal(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(data, &actual)
+	if err = json.Unmarshal([]byte(expected), &wanted); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, wanted) {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+}
+
+func TestLeafQueries(t *testing.T) {
+	tests := []struct {
+		query Query
+		expected string
+	}{
+		{Term("status", "new"), `{"term": {"status": "new"}}`},
+		{Terms("status", "new", "open"), `{"terms": {"status": ["new", "open"]}}`},
+		{Range("n").Gt(1).Lte(5), `{"range": {"n": {"gt": 1, "lte": 5}}}`},
+		{Range("created").Gte("now-1d").Lt("now").Format("epoch_millis"), `{"range": {"created": {"gte": "now-1d", "lt": "now", "format": "epoch_millis"}}}`},
+		{Exists("lock_until"), `{"exists": {"field": "lock_until"}}`},
+		{Match("title", "quick fox"), `{"match": {"title": "quick fox"}}`},
+		{MatchPhrase("title", "quick fox"), `{"match_phrase": {"title": "quick fox"}}`},
+		{MatchAll(), `{"match_all": {}}`},
+		{Nested("analysis", Term("analysis.type", "yara")), `{"nested": {"path": "analysis", "query": {"term": {"analysis.type": "yara"}}}}`},
+		{Ids("1", "2"), `{"ids": {"values": ["1", "2"]}}`},
+		{Wildcard("name", "ab*"), `{"wildcard": {"name": "ab*"}}`},
+		{Prefix("name", "ab"), `{"prefix": {"name": "ab"}}`},
+		{Raw(Object{"match_none": Object{}}), `{"match_none": {}}`},
+	}
+	for _, test := range tests {
+		assertJson(t, test.query.Source(), test.expected)
+	}
+}
+
+func TestBoolQuery(t *testing.T) {
+	assertJson(t, Bool().Source(), `{"bool": {}}`)
+	assertJson(t, Bool().
+		Must(Term("a", 1)).
+		Filter(Term("b", 2), Term("c", 3)).
+		Should(Term("d", 4)).
+		MustNot(Exists("e")).
+		MinimumShouldMatch(1).
+		Source(), `{"bool": {
+			"must": [{"term": {"a": 1}}],
+			"filter": [{"term": {"b": 2}}, {"term": {"c": 3}}],
+			"should": [{"term": {"d": 4}}],
+			"must_not": {"exists": {"field": "e"}},
+			"minimum_should_match": 1
+		}}`)
+
+	// several must_not clauses are an array and nested bools compose
+	assertJson(t, Bool().MustNot(Exists("a"), Exists("b")).Filter(Bool().Should(Term("c", 1), Term("c", 2))).Source(), `{"bool": {
+		"must_not": [{"exists": {"field": "a"}}, {"exists": {"field": "b"}}],
+		"filter": [{"bool": {"should": [{"term": {"c": 1}}, {"term": {"c": 2}}]}}]
+	}}`)
+}
+
+func TestSearchRequest(t *testing.T) {
+	assertJson(t, NewSearch(), `{}`)
+	assertJson(t, NewSearch().
+		Query(Term("status", "new")).
+		Size(10).
+		From(20).
+		Sort(Desc("priority"), Asc("created").Missing("_last")).
+		SortRaw(Array{Object{"_id": "asc"}}).
+		Slice(1, 4).
+		SearchAfter(5, "2026-01-01", "abc"), `{
+			"query": {"term": {"status": "new"}},
+			"size": 10,
+			"from": 20,
+			"sort": [{"priority": "desc"}, {"created": {"order": "asc", "missing": "_last"}}, {"_id": "asc"}],
+			"slice": {"id": 1, "max": 4},
+			"search_after": [5, "2026-01-01", "abc"]
+		}`)
+
+	// includes and excludes take precedence over FetchSource
+	assertJson(t, NewSearch().FetchSource(false), `{"_source": false}`)
+	assertJson(t, NewSearch().FetchSource(false).Includes("a", "b").Excludes("c"), `{"_source": {"includes": ["a", "b"], "excludes": ["c"]}}`)
+}
+
+func TestUpdateByQueryRequest(t *testing.T) {
+	assertJson(t, NewUpdateByQuery().
+		Query(Exists("a")).
+		Script(NewScript("ctx._source.a = params.a").Param("a", 1)).
+		Slice(0, 2), `{
+			"query": {"exists": {"field": "a"}},
+			"script": {"lang": "painless", "source": "ctx._source.a = params.a", "params": {"a": 1}},
+			"slice": {"id": 0, "max": 2}
+		}`)
+	assertJson(t, NewScript("ctx.op = 'none'").Source(), `{"lang": "painless", "source": "ctx.op = 'none'"}`)
+}
+
+func TestAggregations(t *testing.T) {
+	search := NewSearch().
+		Size(0).
+		Aggregation("status", TermsAgg("status").Size(5).SubAggregation("sizes", StatsAgg("size"))).
+		Aggregation("daily", DateHistogramAgg("created", "1d").Param("min_doc_count", 1)).
+		Aggregation("sizes", HistogramAgg("size", 100)).
+		Aggregation("analysis", NestedAgg("analysis").SubAggregation("types", TermsAgg("analysis.type"))).
+		Aggregation("ranges", RangeAgg("size").Range("small", nil, 10).Range("large", 10, nil)).
+		Aggregation("open", FiltersAgg().Filter("new", Term("status", "new")).SubAggregation("owners", CardinalityAgg("owner"))).
+		Aggregation("latency", PercentilesAgg("took", 50, 99)).
+		Aggregation("latest", TopHitsAgg(1).Param("sort", Array{Object{"created": "desc"}}))
+	assertJson(t, search, `{
+		"size": 0,
+		"aggs": {
+			"status": {"terms": {"field": "status", "size": 5}, "aggs": {"sizes": {"stats": {"field": "size"}}}},
+			"daily": {"date_histogram": {"field": "created", "calendar_interval": "1d", "min_doc_count": 1}},
+			"sizes": {"histogram": {"field": "size", "interval": 100}},
+			"analysis": {"nested": {"path": "analysis"}, "aggs": {"types": {"terms": {"field": "analysis.type"}}}},
+			"ranges": {"range": {"field": "size", "ranges": [{"key": "small", "to": 10}, {"key": "large", "from": 10}]}},
+			"open": {"filters": {"filters": {"new": {"term": {"status": "new"}}}}, "aggs": {"owners": {"cardinality": {"field": "owner"}}}},
+			"latency": {"percentiles": {"field": "took", "percents": [50, 99]}},
+			"latest": {"top_hits": {"size": 1, "sort": [{"created": "desc"}]}}
+		}
+	}`)
+}
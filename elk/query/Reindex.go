@@ -0,0 +1,86 @@
+package query
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"encoding/json"
+)
+
+// ReindexRequest builds the body of a _reindex request
+type ReindexRequest struct {
+	source []string
+	dest string
+	query Query
+	script *Script
+	slice Object
+	size *int
+	op_type string
+	conflicts string
+}
+
+// NewReindex returns a request that copies every document of the source indices to dest
+func NewReindex(dest string, source ...string) *ReindexRequest {
+	return &ReindexRequest{dest: dest, source: source, conflicts: "proceed"}
+}
+
+// Query limits the documents copied to those matching query
+func (self *ReindexRequest) Query(query Query) *ReindexRequest {
+	self.query = query
+	return self
+}
+
+// Script sets a script that transforms each document before it is written to dest
+func (self *ReindexRequest) Script(script *Script) *ReindexRequest {
+	self.script = script
+	return self
+}
+
+// Slice restricts the reindex to slice id of max slices
+func (self *ReindexRequest) Slice(id int, max int) *ReindexRequest {
+	self.slice = Object{"id": id, "max": max}
+	return self
+}
+
+// BatchSize sets the number of documents read from the source per batch
+func (self *ReindexRequest) BatchSize(size int) *ReindexRequest {
+	self.size = &size
+	return self
+}
+
+// CreateOnly only copies documents that do not already exist in dest
+func (self *ReindexRequest) CreateOnly() *ReindexRequest {
+	self.op_type = "create"
+	return self
+}
+
+// AbortOnConflict stops the reindex on the first version conflict instead of counting it and continuing
+func (self *ReindexRequest) AbortOnConflict() *ReindexRequest {
+	self.conflicts = "abort"
+	return self
+}
+
+func (self *ReindexRequest) Source() Object {
+	source := Object{"index": self.source}
+	if self.query != nil {
+		source["query"] = self.query.Source()
+	}
+	if self.slice != nil {
+		source["slice"] = self.slice
+	}
+	if self.size != nil {
+		source["size"] = *self.size
+	}
+	dest := Object{"index": self.dest}
+	if self.op_type != "" {
+		dest["op_type"] = self.op_type
+	}
+	request := Object{"source": source, "dest": dest, "conflicts": self.conflicts}
+	if self.script != nil {
+		request["script"] = self.script.Source()
+	}
+	return request
+}
+
+func (self *ReindexRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(self.Source())
+}
@@ -0,0 +1,168 @@
+package query
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"encoding/json"
+)
+
+// SearchRequest builds the body of a _search request
+type SearchRequest struct {
+	query Query
+	size *int
+	from *int
+	sort Array
+	source interface{}
+	includes []string
+	excludes []string
+	slice Object
+	search_after Array
+	aggregations map[string]Aggregation
+}
+
+// NewSearch returns an empty search request
+func NewSearch() *SearchRequest {
+	return &SearchRequest{}
+}
+
+// Query sets the query used to find documents
+func (self *SearchRequest) Query(query Query) *SearchRequest {
+	self.query = query
+	return self
+}
+
+// Size sets the number of documents to return
+func (self *SearchRequest) Size(size int) *SearchRequest {
+	self.size = &size
+	return self
+}
+
+// From sets the number of documents to skip
+func (self *SearchRequest) From(from int) *SearchRequest {
+	self.from = &from
+	return self
+}
+
+// Sort adds fields to sort the documents by
+func (self *SearchRequest) Sort(sorts ...*FieldSort) *SearchRequest {
+	for i := range sorts {
+		self.sort = append(self.sort, sorts[i].Source())
+	}
+	return self
+}
+
+// SortRaw adds handwritten sort clauses
+func (self *SearchRequest) SortRaw(sort Array) *SearchRequest {
+	self.sort = append(self.sort, sort...)
+	return self
+}
+
+// FetchSource sets whether the _source of each document is returned
+func (self *SearchRequest) FetchSource(fetch bool) *SearchRequest {
+	self.source = fetch
+	return self
+}
+
+// Includes limits the returned _source to the given fields
+func (self *SearchRequest) Includes(fields ...string) *SearchRequest {
+	self.includes = append(self.includes, fields...)
+	return self
+}
+
+// Excludes removes the given fields from the returned _source
+func (self *SearchRequest) Excludes(fields ...string) *SearchRequest {
+	self.excludes = append(self.excludes, fields...)
+	return self
+}
+
+// Slice restricts the search to slice id of max slices
+func (self *SearchRequest) Slice(id int, max int) *SearchRequest {
+	self.slice = Object{"id": id, "max": max}
+	return self
+}
+
+// SearchAfter returns the documents that sort after the given sort values of the last document of the previous page
+func (self *SearchRequest) SearchAfter(values ...interface{}) *SearchRequest {
+	self.search_after = Array(values)
+	return self
+}
+
+// Aggregation adds a named aggregation to the request
+func (self *SearchRequest) Aggregation(name string, aggregation Aggregation) *SearchRequest {
+	if self.aggregations == nil {
+		self.aggregations = map[string]Aggregation{}
+	}
+	self.aggregations[name] = aggregation
+	return self
+}
+
+func (self *SearchRequest) Source() Object {
+	request := Object{}
+	if self.query != nil {
+		request["query"] = self.query.Source()
+	}
+	if self.size != nil {
+		request["size"] = *self.size
+	}
+	if self.from != nil {
+		request["from"] = *self.from
+	}
+	if self.sort != nil {
+		request["sort"] = self.sort
+	}
+	if len(self.includes) > 0 || len(self.excludes) > 0 {
+		source := Object{}
+		if len(self.includes) > 0 {
+			source["includes"] = self.includes
+		}
+		if len(self.excludes) > 0 {
+			source["excludes"] = self.excludes
+		}
+		request["_source"] = source
+	} else if self.source != nil {
+		request["_source"] = self.source
+	}
+	if self.slice != nil {
+		request["slice"] = self.slice
+	}
+	if self.search_after != nil {
+		request["search_after"] = self.search_after
+	}
+	if len(self.aggregations) > 0 {
+		request["aggs"] = aggregationSources(self.aggregations)
+	}
+	return request
+}
+
+func (self *SearchRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(self.Source())
+}
+
+type FieldSort struct {
+	field string
+	order string
+	missing interface{}
+}
+
+// Asc sorts by field in ascending order
+func Asc(field string) *FieldSort {
+	return &FieldSort{field: field, order: "asc"}
+}
+
+// Desc sorts by field in descending order
+func Desc(field string) *FieldSort {
+	return &FieldSort{field: field, order: "desc"}
+}
+
+// Missing sets where documents without the field are sorted, either _first, _last or a custom value
+func (self *FieldSort) Missing(missing interface{}) *FieldSort {
+	self.missing = missing
+	return self
+}
+
+func (self *FieldSort) Source() Object {
+	if self.missing == nil {
+		return Object{self.field: self.order}
+	}
+	return Object{self.field: Object{"order": self.order, "missing": self.missing}}
+}
@@ -0,0 +1,82 @@
+package query
+
+import (
+	. "github.com/KarmaPenny/golib/dynamics"
+
+	"encoding/json"
+)
+
+// UpdateByQueryRequest builds the body of an _update_by_query request
+type UpdateByQueryRequest struct {
+	query Query
+	script *Script
+	slice Object
+}
+
+// NewUpdateByQuery returns an empty update by query request
+func NewUpdateByQuery() *UpdateByQueryRequest {
+	return &UpdateByQueryRequest{}
+}
+
+// Query sets the query used to find documents to update
+func (self *UpdateByQueryRequest) Query(query Query) *UpdateByQueryRequest {
+	self.query = query
+	return self
+}
+
+// Script sets the script applied to each matching document
+func (self *UpdateByQueryRequest) Script(script *Script) *UpdateByQueryRequest {
+	self.script = script
+	return self
+}
+
+// Slice restricts the update to slice id of max slices
+func (self *UpdateByQueryRequest) Slice(id int, max int) *UpdateByQueryRequest {
+	self.slice = Object{"id": id, "max": max}
+	return self
+}
+
+func (self *UpdateByQueryRequest) Source() Object {
+	request := Object{}
+	if self.query != nil {
+		request["query"] = self.query.Source()
+	}
+	if self.script != nil {
+		request["script"] = self.script.Source()
+	}
+	if self.slice != nil {
+		request["slice"] = self.slice
+	}
+	return request
+}
+
+func (self *UpdateByQueryRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(self.Source())
+}
+
+type Script struct {
+	source string
+	params Object
+}
+
+// NewScript returns a painless script
+func NewScript(source string) *Script {
+	return &Script{source: source}
+}
+
+// Param sets a parameter passed to the script
+func (self *Script) Param(name string, value interface{}) *Script {
+	if self.params == nil {
+		self.params = Object{}
+	}
+	self.params[name] = value
+	return self
+}
+
+func (self *Script) Source() Object {
+	script := Object{"lang": "painless", "source": self.source}
+	if self.params != nil {
+		script["params"] = self.params
+	}
+	return script
+}